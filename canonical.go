@@ -0,0 +1,121 @@
+package stateless
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Canonical returns a deterministic, line-oriented textual representation of the state machine configuration,
+// intended to be diffed when reviewing changes to a state machine.
+// Each line describes a single aspect of the configuration of a state, such as a transition,
+// an action or the superstate, and lines are sorted lexicographically.
+//
+// Transitions are formatted as "<state> --<trigger>--> <destination> [<guards>] / <actions>",
+// where the guards and the actions are omitted when there are none.
+func (sm *StateMachine) Canonical() string {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	var lines []string
+	for _, sr := range sm.stateConfig {
+		lines = append(lines, canonicalState(sm, sr)...)
+	}
+	sort.Strings(lines)
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func canonicalState(sm *StateMachine, sr *stateRepresentation) []string {
+	var lines []string
+	add := func(format string, a ...any) {
+		lines = append(lines, fmt.Sprintf("%v "+format, append([]any{sr.State}, a...)...))
+	}
+	if super := sr.superstate(); super != nil {
+		add("substate of %v", super.State)
+	}
+	for trigger := range sr.BlockedTriggers {
+		add("blocks %v", trigger)
+	}
+	if sr.HasInitialState {
+		add("initial %v", sr.InitialTransitionTarget)
+	}
+	for _, act := range sr.ActivateActions {
+		add("activated / %s", act.Description)
+	}
+	for _, act := range sr.DeactivateActions {
+		add("deactivated / %s", act.Description)
+	}
+	for _, act := range sr.EntryActions {
+		switch {
+		case act.Trigger != nil:
+			add("entry from %v / %s", *act.Trigger, act.Description)
+		case act.InitialOnly:
+			add("initial entry / %s", act.Description)
+		case act.Reentry == reentryOnly:
+			add("reentry / %s", act.Description)
+		case act.Reentry == reentryExcept:
+			add("entry except reentry / %s", act.Description)
+		default:
+			add("entry / %s", act.Description)
+		}
+	}
+	for _, act := range sr.ExitActions {
+		if act.Trigger == nil {
+			add("exit / %s", act.Description)
+		} else {
+			add("exit with %v / %s", *act.Trigger, act.Description)
+		}
+	}
+	for _, behaviours := range sr.TriggerBehaviours {
+		for _, behaviour := range behaviours {
+			info := newTransitionInfo(sr, behaviour)
+			var actions []string
+			switch t := behaviour.(type) {
+			case *internalTriggerBehaviour:
+				actions = append(actions, newinvocationInfo(t.Action).String())
+			case *internalDynamicTriggerBehaviour:
+				actions = append(actions, t.Description.String())
+			case *transitioningTriggerBehaviour:
+				actions = t.actions(sm.stateConfig[info.Destination])
+			case *reentryTriggerBehaviour:
+				if dest, ok := sm.stateConfig[info.Destination]; ok {
+					actions = entryActionsFor(dest.EntryActions, info.Trigger)
+				}
+			}
+			line := canonicalTransition(info, actions)
+			if t, ok := behaviour.(*transitioningTriggerBehaviour); ok && t.Override {
+				line += " (override)"
+			}
+			add("%s", line)
+		}
+	}
+	return lines
+}
+
+func canonicalTransition(info TransitionInfo, actions []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--%v--> ", info.Trigger)
+	switch info.Kind {
+	case KindTransitioning:
+		fmt.Fprint(&sb, info.Destination)
+	case KindReentry:
+		fmt.Fprintf(&sb, "%v (reentry)", info.Destination)
+	default:
+		fmt.Fprintf(&sb, "(%s)", info.Kind)
+	}
+	if len(info.GuardDescriptions) != 0 {
+		sep := ", "
+		if info.AnyGuard {
+			sep = " || "
+		}
+		fmt.Fprintf(&sb, " [%s]", strings.Join(info.GuardDescriptions, sep))
+	}
+	if len(actions) != 0 {
+		fmt.Fprintf(&sb, " / %s", strings.Join(actions, ", "))
+	}
+	return sb.String()
+}
@@ -0,0 +1,48 @@
+package stateless_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/qmuntal/stateless"
+)
+
+func TestStateMachine_Canonical(t *testing.T) {
+	tests := []func() *stateless.StateMachine{
+		emptyWithInitial,
+		withSubstate,
+		withInitialState,
+		withGuards,
+		phoneCall,
+	}
+	for _, fn := range tests {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		sp := strings.Split(name, ".")
+		name = sp[len(sp)-1]
+		t.Run(name, func(t *testing.T) {
+			got := fn().Canonical()
+			if again := fn().Canonical(); got != again {
+				t.Fatalf("output is not deterministic:\n%s\n%s", got, again)
+			}
+			name := "testdata/golden/" + name + ".txt"
+			want, err := os.ReadFile(name)
+			want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+			if *update {
+				if !bytes.Equal([]byte(got), want) {
+					os.WriteFile(name, []byte(got), 0666)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal([]byte(got), want) {
+					t.Fatalf("got:\n%swant:\n%s", got, want)
+				}
+			}
+		})
+	}
+}
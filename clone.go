@@ -0,0 +1,170 @@
+package stateless
+
+// Clone returns a new state machine with a copy of the configuration of sm, using the same firing mode
+// and storing its own state internally, starting at initialState.
+// The states, triggers, callbacks and options are copied, so configuring the clone doesn't affect sm and vice versa.
+// The current state, the activated states and the rate counters are not copied.
+//
+// The functions provided by the user, such as actions and guards, are shared.
+// Guards created with StateConfiguration.RateLimit keep counting the fires of the state machine they were created for,
+// so they should be configured again in the clone.
+func (sm *StateMachine) Clone(initialState State) *StateMachine {
+	mode := FiringQueued
+	if _, ok := sm.mode.(*fireModeImmediate); ok {
+		mode = FiringImmediate
+	}
+	clone := NewStateMachineWithMode(initialState, mode)
+
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+
+	clone.unhandledTriggerAction = sm.unhandledTriggerAction
+	clone.onTransitioningEvents = append([]TransitionFunc(nil), sm.onTransitioningEvents...)
+	clone.onTransitionedEvents = append([]TransitionFunc(nil), sm.onTransitionedEvents...)
+	clone.onStateChangedEvents = append([]StateChangedFunc(nil), sm.onStateChangedEvents...)
+	clone.defaults = defaultActions{
+		EntryActions: append([]actionBehaviour(nil), sm.defaults.EntryActions...),
+		ExitActions:  append([]actionBehaviour(nil), sm.defaults.ExitActions...),
+	}
+	if sm.defaults.Transitions != nil {
+		clone.defaults.Transitions = make(map[Trigger][]triggerBehaviour, len(sm.defaults.Transitions))
+		for trigger, behaviours := range sm.defaults.Transitions {
+			cloned := make([]triggerBehaviour, len(behaviours))
+			for i, behaviour := range behaviours {
+				cloned[i] = clone.cloneTriggerBehaviour(behaviour)
+			}
+			clone.defaults.Transitions[trigger] = cloned
+		}
+	}
+	clone.onTransitionFailed = append([]TransitionFailedFunc(nil), sm.onTransitionFailed...)
+	clone.onGuardPanic = sm.onGuardPanic
+	clone.maxChainLength = sm.maxChainLength
+	clone.maxQueueDepth = sm.maxQueueDepth
+	clone.autoDeactivate = sm.autoDeactivate
+	clone.dropUnhandledQueued = sm.dropUnhandledQueued
+	clone.argRedactor = sm.argRedactor
+	clone.reportInternal = sm.reportInternal
+	clone.argCloner = sm.argCloner
+	clone.transitionTimeout = sm.transitionTimeout
+	clone.synchronous = sm.synchronous
+	clone.timed = sm.timed
+	clone.rejectReentrant = sm.rejectReentrant
+	clone.fireArgsInContext = sm.fireArgsInContext
+	clone.requireActivation = sm.requireActivation
+	clone.guardResolution = sm.guardResolution
+	sm.subscriptions.mu.Lock()
+	clone.subscriptions.buffer = sm.subscriptions.buffer
+	clone.subscriptions.onDropped = sm.subscriptions.onDropped
+	sm.subscriptions.mu.Unlock()
+	clone.clock = sm.clock
+	sm.triggerConfigMu.RLock()
+	for trigger, config := range sm.triggerConfig {
+		config.ArgumentTypes = append(config.ArgumentTypes[:0:0], config.ArgumentTypes...)
+		clone.triggerConfig[trigger] = config
+	}
+	for trigger, arg := range sm.triggerDefaults {
+		clone.triggerDefaults[trigger] = arg
+	}
+	for trigger, deprecated := range sm.deprecatedTriggers {
+		clone.deprecatedTriggers[trigger] = &deprecatedTrigger{Message: deprecated.Message}
+	}
+	clone.onDeprecatedTrigger = sm.onDeprecatedTrigger
+	sm.triggerConfigMu.RUnlock()
+	for trigger := range sm.broadcastTriggers {
+		clone.broadcastTriggers[trigger] = struct{}{}
+	}
+
+	for state, sr := range sm.stateConfig {
+		clone.stateConfig[state] = clone.cloneStateRepresentation(sr)
+	}
+	// Rewire the hierarchy to the cloned representations.
+	for state, sr := range sm.stateConfig {
+		rep := clone.stateConfig[state]
+		if super := sr.superstate(); super != nil {
+			rep.Superstate = clone.stateConfig[super.State]
+		}
+		substates := sr.substates()
+		rep.Substates = make([]*stateRepresentation, len(substates))
+		for i, substate := range substates {
+			rep.Substates[i] = clone.stateConfig[substate.State]
+		}
+	}
+	return clone
+}
+
+// cloneStateRepresentation copies sr, except for its hierarchy, to be used by sm.
+func (sm *StateMachine) cloneStateRepresentation(sr *stateRepresentation) *stateRepresentation {
+	rep := newstateRepresentation(sr.State)
+	rep.InitialTransitionTarget = sr.InitialTransitionTarget
+	rep.InitialTransitionPath = append([]State(nil), sr.InitialTransitionPath...)
+	rep.HasInitialState = sr.HasInitialState
+	rep.HasHistory = sr.HasHistory
+	rep.Configured = sr.Configured
+	rep.UnhandledTriggerAction = sr.UnhandledTriggerAction
+	rep.Metadata = sr.metadata()
+	rep.Defaults = &sm.defaults
+	rep.Resolution = &sm.guardResolution
+	for _, r := range sr.Regions {
+		rep.Regions = append(rep.Regions, r.clone(sm))
+	}
+	rep.TimedTriggers = make([]timedTrigger, len(sr.TimedTriggers))
+	for i, tt := range sr.TimedTriggers {
+		tt.fire = sm.fireTimed
+		rep.TimedTriggers[i] = tt
+	}
+	rep.EntryActions = append([]actionBehaviour(nil), sr.EntryActions...)
+	rep.ExitActions = append([]actionBehaviour(nil), sr.ExitActions...)
+	rep.ActivateActions = append([]actionBehaviourSteady(nil), sr.ActivateActions...)
+	rep.DeactivateActions = append([]actionBehaviourSteady(nil), sr.DeactivateActions...)
+	if sr.BlockedTriggers != nil {
+		rep.BlockedTriggers = make(map[Trigger]struct{}, len(sr.BlockedTriggers))
+		for trigger := range sr.BlockedTriggers {
+			rep.BlockedTriggers[trigger] = struct{}{}
+		}
+	}
+	for trigger, behaviours := range sr.TriggerBehaviours {
+		cloned := make([]triggerBehaviour, len(behaviours))
+		for i, behaviour := range behaviours {
+			cloned[i] = sm.cloneTriggerBehaviour(behaviour)
+		}
+		rep.TriggerBehaviours[trigger] = cloned
+	}
+	return rep
+}
+
+// cloneTriggerBehaviour copies the behaviour, wrapping its guards to report panics to sm.
+func (sm *StateMachine) cloneTriggerBehaviour(behaviour triggerBehaviour) triggerBehaviour {
+	var cloned triggerBehaviour
+	switch t := behaviour.(type) {
+	case *ignoredTriggerBehaviour:
+		c := *t
+		cloned = &c
+	case *reentryTriggerBehaviour:
+		c := *t
+		cloned = &c
+	case *transitioningTriggerBehaviour:
+		c := *t
+		cloned = &c
+	case *dynamicTriggerBehaviour:
+		c := *t
+		cloned = &c
+	case *internalTriggerBehaviour:
+		c := *t
+		cloned = &c
+	case *internalDynamicTriggerBehaviour:
+		c := *t
+		cloned = &c
+	default:
+		panic("stateless: Unknown trigger behaviour.")
+	}
+	base := cloned.base()
+	guards := make([]guardCondition, len(base.Guard.Guards))
+	for i, guard := range base.Guard.Guards {
+		if guard.unwrapped != nil {
+			guard.Guard = sm.recoverGuard(base.Trigger, guard.unwrapped)
+		}
+		guards[i] = guard
+	}
+	base.Guard = transitionGuard{Guards: guards, Any: base.Guard.Any}
+	return cloned
+}
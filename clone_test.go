@@ -0,0 +1,79 @@
+package stateless
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStateMachine_Clone(t *testing.T) {
+	template := NewStateMachineWithMode(stateA, FiringImmediate)
+	var transitioned int
+	template.OnTransitioned(func(_ context.Context, _ Transition) {
+		transitioned++
+	})
+	template.Configure(stateA).Permit(triggerX, stateB)
+	template.Configure(stateB).SubstateOf(stateC)
+	template.Configure(stateC).Permit(triggerY, stateA)
+
+	clone := template.Clone(stateB)
+	if _, ok := clone.mode.(*fireModeImmediate); !ok {
+		t.Errorf("expected the clone to keep the firing mode")
+	}
+	if got := clone.MustState(); got != stateB {
+		t.Errorf("clone state = %v, want %v", got, stateB)
+	}
+	if got := template.MustState(); got != stateA {
+		t.Errorf("template state = %v, want %v", got, stateA)
+	}
+	for state, sr := range clone.stateConfig {
+		if sr == template.stateConfig[state] {
+			t.Errorf("state %v representation is shared", state)
+		}
+	}
+	rep := clone.stateConfig[stateB]
+	if rep.Superstate != clone.stateConfig[stateC] {
+		t.Error("superstate not rewired to the cloned representation")
+	}
+	if sub := clone.stateConfig[stateC].Substates; len(sub) != 1 || sub[0] != rep {
+		t.Error("substates not rewired to the cloned representation")
+	}
+
+	// The inherited trigger works in the clone without affecting the template.
+	if err := clone.Fire(triggerY); err != nil {
+		t.Fatal(err)
+	}
+	if got := clone.MustState(); got != stateA {
+		t.Errorf("clone state = %v, want %v", got, stateA)
+	}
+	if got := template.MustState(); got != stateA {
+		t.Errorf("template state = %v, want %v", got, stateA)
+	}
+	if transitioned != 1 {
+		t.Errorf("transitioned = %d, want 1", transitioned)
+	}
+
+	// Configuring the clone doesn't change the template.
+	clone.Configure(stateA).Permit(triggerZ, stateC)
+	if ok, _ := template.CanFire(triggerZ); ok {
+		t.Error("configuring the clone changed the template")
+	}
+}
+
+func TestStateMachine_Clone_GuardPanic(t *testing.T) {
+	template := NewStateMachine(stateA)
+	template.Configure(stateA).Permit(triggerX, stateB, func(_ context.Context, _ ...any) bool {
+		panic("boom")
+	})
+	clone := template.Clone(stateA)
+	var recovered any
+	clone.OnGuardPanic(func(_ context.Context, _ Trigger, r any) {
+		recovered = r
+	})
+	if ok, _ := clone.CanFire(triggerX); ok {
+		t.Error("expected the panicking guard to be unmet")
+	}
+	if recovered != "boom" {
+		t.Errorf("recovered = %v, want boom", recovered)
+	}
+	assertPanic(t, func() { template.CanFire(triggerX) })
+}
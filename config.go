@@ -3,12 +3,22 @@ package stateless
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 type transitionKey struct{}
 
+type stepTransitionKey struct{}
+
 func withTransition(ctx context.Context, transition Transition) context.Context {
-	return context.WithValue(ctx, transitionKey{}, transition)
+	return withStepTransition(ctx, transition, transition)
+}
+
+// withStepTransition returns a copy of ctx with the transition being executed
+// and the step of the transition at the level of the hierarchy whose actions are executed.
+func withStepTransition(ctx context.Context, transition, step Transition) context.Context {
+	ctx = context.WithValue(ctx, transitionKey{}, transition)
+	return context.WithValue(ctx, stepTransitionKey{}, step)
 }
 
 // GetTransition returns the transition from the context.
@@ -18,6 +28,62 @@ func GetTransition(ctx context.Context) Transition {
 	return tr
 }
 
+// GetStepTransition returns the step of the transition at the level of the state hierarchy
+// whose actions are being executed, while GetTransition returns the whole transition.
+//
+// In entry actions the source is the superstate entered just before in the same transition,
+// or the source of the transition if no superstate has been entered, and the destination is the entered state.
+// In exit actions the source is the exited state and the destination is the superstate that will be exited next,
+// or the destination of the transition if no superstate is exited.
+// In other actions it is equal to the transition. If there is no transition the returned value is empty.
+func GetStepTransition(ctx context.Context) Transition {
+	tr, _ := ctx.Value(stepTransitionKey{}).(Transition)
+	return tr
+}
+
+// GetTrigger returns the trigger that caused the action from the context.
+// It is available in entry, exit and internal transition actions, and returns false
+// when there is no transition in the context, e.g. in activation and deactivation actions.
+func GetTrigger(ctx context.Context) (Trigger, bool) {
+	tr, ok := ctx.Value(transitionKey{}).(Transition)
+	return tr.Trigger, ok
+}
+
+type rootTriggerKey struct{}
+
+// withRootTrigger returns a copy of ctx with the trigger as root trigger,
+// unless ctx already contains one.
+func withRootTrigger(ctx context.Context, trigger Trigger) context.Context {
+	if _, ok := ctx.Value(rootTriggerKey{}).(rootTrigger); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, rootTriggerKey{}, rootTrigger{trigger})
+}
+
+// rootTrigger wraps the root trigger so a nil trigger can be distinguished from a missing one.
+type rootTrigger struct {
+	Trigger Trigger
+}
+
+// GetRootTrigger returns from the context the external trigger that originated the current chain of transitions.
+// It is the trigger passed to the outermost Fire call, and it is inherited by the triggers fired
+// from within actions using the received context, as well as by completion transitions.
+// It returns false if there is no root trigger in the context.
+func GetRootTrigger(ctx context.Context) (Trigger, bool) {
+	root, ok := ctx.Value(rootTriggerKey{}).(rootTrigger)
+	return root.Trigger, ok
+}
+
+type eventArgsKey struct{}
+
+// GetEventArgs returns the trigger arguments from the context received by the transition callbacks,
+// transformed with the function configured with SetArgRedactor, if any.
+// If there is no redactor the returned value is the []any slice of arguments.
+// If there are no arguments in the context the returned value is nil.
+func GetEventArgs(ctx context.Context) any {
+	return ctx.Value(eventArgsKey{})
+}
+
 // ActionFunc describes a generic action function.
 // The context will always contain Transition information.
 type ActionFunc = func(ctx context.Context, args ...any) error
@@ -25,9 +91,60 @@ type ActionFunc = func(ctx context.Context, args ...any) error
 // GuardFunc defines a generic guard function.
 type GuardFunc = func(ctx context.Context, args ...any) bool
 
+// GuardFuncE defines a generic guard function that can fail.
+// An error returned while firing a trigger aborts the fire, and it is returned without changing the state.
+type GuardFuncE = func(ctx context.Context, args ...any) (bool, error)
+
+type guardErrorKey struct{}
+
+// guardError holds the first error returned by a GuardFuncE while resolving the handler of a trigger.
+type guardError struct {
+	err error
+}
+
+func withGuardError(ctx context.Context) (context.Context, *guardError) {
+	ge := new(guardError)
+	return context.WithValue(ctx, guardErrorKey{}, ge), ge
+}
+
+// describeGuardsE adapts the guards to GuardFunc, which report their errors to the guardError in the context
+// and are considered not met when failing. The guards keep the description of the original functions.
+func describeGuardsE(guards []GuardFuncE) []GuardDescription {
+	descriptions := make([]GuardDescription, len(guards))
+	for i, guard := range guards {
+		guard := guard
+		descriptions[i] = GuardDescription{
+			Guard: func(ctx context.Context, args ...any) bool {
+				ok, err := guard(ctx, args...)
+				if err != nil {
+					if ge, _ := ctx.Value(guardErrorKey{}).(*guardError); ge != nil && ge.err == nil {
+						ge.err = err
+					}
+					return false
+				}
+				return ok
+			},
+			Description: newinvocationInfo(guard).String(),
+		}
+	}
+	return descriptions
+}
+
+// GuardDescription associates a human-readable description with a guard function.
+// The description is used instead of the function name to describe the guard,
+// e.g. in the unmet guards reported for unhandled triggers and in the graph labels.
+type GuardDescription struct {
+	Guard       GuardFunc
+	Description string
+}
+
 // DestinationSelectorFunc defines a functions that is called to select a dynamic destination.
 type DestinationSelectorFunc = func(ctx context.Context, args ...any) (State, error)
 
+// InternalDestinationFunc defines an internal transition action that can escalate into a transition.
+// It returns the destination state and true to transition into it, or false to stay in the current state.
+type InternalDestinationFunc = func(ctx context.Context, args ...any) (State, bool, error)
+
 // StateConfiguration is the configuration for a single state value.
 type StateConfiguration struct {
 	sm     *StateMachine
@@ -45,6 +162,13 @@ func (sc *StateConfiguration) Machine() *StateMachine {
 	return sc.sm
 }
 
+// WithMetadata attaches a value to the state under key, replacing any previous value.
+// The metadata can be read with StateMachine.Metadata.
+func (sc *StateConfiguration) WithMetadata(key string, value any) *StateConfiguration {
+	sc.sr.setMetadata(key, value)
+	return sc
+}
+
 // InitialTransition adds an initial transition to this state.
 // When entering the current state the state machine will look for an initial transition,
 // and enter the target state.
@@ -59,23 +183,226 @@ func (sc *StateConfiguration) InitialTransition(targetState State) *StateConfigu
 	return sc
 }
 
+// InitialTransitionPath adds an initial transition to this state that descends directly into a nested substate,
+// entering in order each state of the path, which must start with a direct substate of this state and continue
+// with a direct substate of the previous one. The intermediate states are entered without following their own
+// initial transitions, whereas the initial transition of the last one, if any, is followed.
+//
+// The path is validated when configured, so the substates must have already been configured with SubstateOf.
+func (sc *StateConfiguration) InitialTransitionPath(states ...State) *StateConfiguration {
+	if len(states) == 0 {
+		panic("stateless: The initial transition path requires at least one state.")
+	}
+	parent := sc.sr
+	for _, state := range states {
+		sc.sm.stateMutex.RLock()
+		sr, ok := sc.sm.stateConfig[state]
+		sc.sm.stateMutex.RUnlock()
+		if !ok || sr.superstate() != parent {
+			panic(fmt.Sprintf("stateless: The state '%v' of the initial transition path is not a substate of '%v'.", state, parent.State))
+		}
+		parent = sr
+	}
+	sc.InitialTransition(states[0])
+	sc.sr.configMu.Lock()
+	sc.sr.InitialTransitionPath = append([]State(nil), states...)
+	sc.sr.configMu.Unlock()
+	return sc
+}
+
+// InitialTransitionWithHistory adds an initial transition to this state, like InitialTransition,
+// and gives it shallow history: when entering the state again the state machine enters
+// the substate that was active when the state was last exited, instead of the target state.
+// The target state is entered the first time, when there is no recorded history.
+// The history is kept in memory by the state machine, even when the state is stored externally.
+func (sc *StateConfiguration) InitialTransitionWithHistory(targetState State) *StateConfiguration {
+	sc.InitialTransition(targetState)
+	sc.sr.HasHistory = true
+	return sc
+}
+
+// newGuard creates the transition guard for the trigger, recovering guard panics if requested with OnGuardPanic.
+func (sc *StateConfiguration) newGuard(trigger Trigger, guards ...GuardFunc) transitionGuard {
+	return sc.sm.newGuard(trigger, guards...)
+}
+
+// newGuard creates the transition guard for the trigger, recovering guard panics if requested with OnGuardPanic.
+func (sm *StateMachine) newGuard(trigger Trigger, guards ...GuardFunc) transitionGuard {
+	tg := newtransitionGuard(guards...)
+	for i := range tg.Guards {
+		tg.Guards[i].unwrapped = tg.Guards[i].Guard
+		tg.Guards[i].Guard = sm.recoverGuard(trigger, tg.Guards[i].Guard)
+	}
+	return tg
+}
+
+// newDescribedGuard creates the transition guard for the trigger using the explicit guard descriptions.
+func (sc *StateConfiguration) newDescribedGuard(trigger Trigger, guards ...GuardDescription) transitionGuard {
+	funcs := make([]GuardFunc, len(guards))
+	for i, guard := range guards {
+		funcs[i] = guard.Guard
+	}
+	tg := sc.newGuard(trigger, funcs...)
+	for i, guard := range guards {
+		if guard.Description != "" {
+			tg.Guards[i].Description = invocationInfo{Method: guard.Description}
+		}
+	}
+	return tg
+}
+
 // Permit accept the specified trigger and transition to the destination state if the guard conditions are met (if any).
 func (sc *StateConfiguration) Permit(trigger Trigger, destinationState State, guards ...GuardFunc) *StateConfiguration {
 	if destinationState == sc.sr.State {
 		panic("stateless: Permit() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
 	}
 	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
-		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: newtransitionGuard(guards...)},
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
+		Destination:          destinationState,
+	})
+	return sc
+}
+
+// PermitAny accept the specified trigger and transition to the destination state if any of the guard conditions is met.
+// The unmet guard conditions are only reported when none of them is met.
+func (sc *StateConfiguration) PermitAny(trigger Trigger, destinationState State, guards ...GuardFunc) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitAny() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	guard := sc.newGuard(trigger, guards...)
+	guard.Any = true
+	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: guard},
 		Destination:          destinationState,
 	})
 	return sc
 }
 
+// PermitWithAction accept the specified trigger and transition to the destination state if the guard conditions are met (if any),
+// executing the action on the transition itself: after the exit actions of the source state and the OnTransitioning callbacks,
+// and before the state changes and the entry actions of the destination state are executed.
+// The transition is available in the action using GetTransition. If the action returns an error the state is not changed.
+func (sc *StateConfiguration) PermitWithAction(trigger Trigger, destinationState State, action ActionFunc, guards ...GuardFunc) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitWithAction() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
+		Destination:          destinationState,
+		Action:               action,
+		ActionDescription:    newinvocationInfo(action),
+	})
+	return sc
+}
+
+// PermitWith accept the specified trigger and transition to the destination state if the guard conditions are met (if any).
+// It behaves like Permit, but each guard carries an explicit description. Guards with an empty description
+// are described by their function name.
+func (sc *StateConfiguration) PermitWith(trigger Trigger, destinationState State, guards ...GuardDescription) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitWith() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newDescribedGuard(trigger, guards...)},
+		Destination:          destinationState,
+	})
+	return sc
+}
+
+// PermitAfter accept the specified trigger and transition to the destination state, like Permit,
+// and also fires the trigger automatically once the configured state has been active for the given duration,
+// like OnEntryFireAfter.
+func (sc *StateConfiguration) PermitAfter(trigger Trigger, destinationState State, after time.Duration) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitAfter() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	return sc.Permit(trigger, destinationState).OnEntryFireAfter(trigger, after)
+}
+
+// PermitE accept the specified trigger and transition to the destination state if the guard conditions are met (if any).
+// It behaves like Permit, but the guards can return an error, which aborts the fire and is returned by Fire.
+// When evaluated outside of Fire, e.g. by PermittedTriggers, a failing guard is considered not met.
+func (sc *StateConfiguration) PermitE(trigger Trigger, destinationState State, guards ...GuardFuncE) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitE() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newDescribedGuard(trigger, describeGuardsE(guards)...)},
+		Destination:          destinationState,
+	})
+	return sc
+}
+
+// PermitWithPriority accept the specified trigger and transition to the destination state if the guard conditions are met (if any),
+// using the given priority to resolve conflicts with other behaviours configured for the same trigger in this state.
+// Behaviours with higher priority are evaluated first, and when several of them are permitted the one
+// with the highest priority is selected. Behaviours configured without priority, i.e. with Permit, have priority 0.
+// Guard clauses of behaviours with the same priority must still be mutually exclusive.
+func (sc *StateConfiguration) PermitWithPriority(trigger Trigger, destinationState State, priority int, guards ...GuardFunc) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitWithPriority() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...), Priority: priority},
+		Destination:          destinationState,
+	})
+	return sc
+}
+
+// PermitOverride accept the specified trigger and transition to the destination state,
+// taking precedence over the transitions configured for the trigger in the substates.
+// Substates normally handle a trigger before their superstates, but when in any substate of the configured state,
+// a permitted override transition is taken regardless of the substate handlers.
+// If several superstates have permitted overrides for the same trigger, the outermost one wins.
+func (sc *StateConfiguration) PermitOverride(trigger Trigger, destinationState State, guards ...GuardFunc) *StateConfiguration {
+	if destinationState == sc.sr.State {
+		panic("stateless: PermitOverride() require that the destination state is not equal to the source state. To accept a trigger without changing state, use either Ignore() or PermitReentry().")
+	}
+	sc.sr.AddTriggerBehaviour(&transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
+		Destination:          destinationState,
+		Override:             true,
+	})
+	return sc
+}
+
+// PermitCompletion accept the CompletionTrigger and transition to the destination state if the guard conditions are met (if any).
+// The CompletionTrigger is fired automatically once the configured state has been entered and
+// all its entry actions have completed, so no external Fire is required. This also applies when the configured
+// state is entered as a superstate of the destination, but not when the transition stays within the configured state.
+func (sc *StateConfiguration) PermitCompletion(destinationState State, guards ...GuardFunc) *StateConfiguration {
+	return sc.Permit(CompletionTrigger, destinationState, guards...)
+}
+
 // InternalTransition add an internal transition to the state machine.
 // An internal action does not cause the Exit and Entry actions to be triggered, and does not change the state of the state machine.
 func (sc *StateConfiguration) InternalTransition(trigger Trigger, action ActionFunc, guards ...GuardFunc) *StateConfiguration {
 	sc.sr.AddTriggerBehaviour(&internalTriggerBehaviour{
-		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: newtransitionGuard(guards...)},
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
+		Action:               action,
+	})
+	return sc
+}
+
+// InternalTransitionDynamic add an internal transition whose action can optionally escalate it into a transition.
+// The action is executed like the one of InternalTransition, without exiting the state. If it returns a destination
+// and true, the state machine then transitions to the destination as if the trigger had been configured with Permit,
+// executing the exit and entry actions. If it returns false the transition stays internal.
+// Like internal transitions, it is handled by the superstates when the current state doesn't handle the trigger.
+func (sc *StateConfiguration) InternalTransitionDynamic(trigger Trigger, action InternalDestinationFunc, guards ...GuardFunc) *StateConfiguration {
+	sc.sr.AddTriggerBehaviour(&internalDynamicTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
+		Action:               action,
+		Description:          newinvocationInfo(action),
+	})
+	return sc
+}
+
+// InternalTransitionE add an internal transition to the state machine.
+// It behaves like InternalTransition, but the guards can return an error, which aborts the fire and is returned by Fire.
+func (sc *StateConfiguration) InternalTransitionE(trigger Trigger, action ActionFunc, guards ...GuardFuncE) *StateConfiguration {
+	sc.sr.AddTriggerBehaviour(&internalTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newDescribedGuard(trigger, describeGuardsE(guards)...)},
 		Action:               action,
 	})
 	return sc
@@ -85,9 +412,14 @@ func (sc *StateConfiguration) InternalTransition(trigger Trigger, action ActionF
 // Reentry behaves as though the configured state transitions to an identical sibling state.
 // Applies to the current state only. Will not re-execute superstate actions, or
 // cause actions to execute transitioning between super- and sub-states.
+//
+// If the configured state has an initial transition, reentering it also re-runs the initial transition,
+// so the state machine lands in the initial substate. When the trigger is handled from one of its substates,
+// the substate is exited first, then the configured state is exited and entered again.
+// The entry actions receive the trigger and its arguments, so the ones registered with OnEntryFrom for the trigger are executed.
 func (sc *StateConfiguration) PermitReentry(trigger Trigger, guards ...GuardFunc) *StateConfiguration {
 	sc.sr.AddTriggerBehaviour(&reentryTriggerBehaviour{
-		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: newtransitionGuard(guards...)},
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
 		Destination:          sc.sr.State,
 	})
 	return sc
@@ -96,11 +428,28 @@ func (sc *StateConfiguration) PermitReentry(trigger Trigger, guards ...GuardFunc
 // Ignore the specified trigger when in the configured state, if the guards return true.
 func (sc *StateConfiguration) Ignore(trigger Trigger, guards ...GuardFunc) *StateConfiguration {
 	sc.sr.AddTriggerBehaviour(&ignoredTriggerBehaviour{
-		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: newtransitionGuard(guards...)},
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
 	})
 	return sc
 }
 
+// IgnoreE ignore the specified trigger when in the configured state, if the guards return true.
+// It behaves like Ignore, but the guards can return an error, which aborts the fire and is returned by Fire.
+func (sc *StateConfiguration) IgnoreE(trigger Trigger, guards ...GuardFuncE) *StateConfiguration {
+	sc.sr.AddTriggerBehaviour(&ignoredTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newDescribedGuard(trigger, describeGuardsE(guards)...)},
+	})
+	return sc
+}
+
+// Block prevents the handlers configured for the trigger in the superstates from being
+// consulted when in the configured state, so the trigger is unhandled unless the state itself handles it.
+// Unlike Ignore, which consumes the trigger, a blocked trigger is reported as unhandled.
+func (sc *StateConfiguration) Block(trigger Trigger) *StateConfiguration {
+	sc.sr.block(trigger)
+	return sc
+}
+
 // PermitDynamic accept the specified trigger and transition to the destination state, calculated dynamically by the supplied function.
 func (sc *StateConfiguration) PermitDynamic(trigger Trigger, selector DestinationSelectorFunc, guards ...GuardFunc) *StateConfiguration {
 	guardDescriptors := make([]invocationInfo, len(guards))
@@ -108,8 +457,26 @@ func (sc *StateConfiguration) PermitDynamic(trigger Trigger, selector Destinatio
 		guardDescriptors[i] = newinvocationInfo(guard)
 	}
 	sc.sr.AddTriggerBehaviour(&dynamicTriggerBehaviour{
-		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: newtransitionGuard(guards...)},
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
 		Destination:          selector,
+		Description:          newinvocationInfo(selector),
+	})
+	return sc
+}
+
+// PermitDynamicDest behaves like PermitDynamic but also declares the states the selector may return,
+// so they are known statically and can be inspected with PermittedTransitions, drawn by ToGraph and checked by Validate.
+// When the trigger is fired, an error wrapping ErrUndeclaredDestination is returned
+// if the selector returns a state that is not declared, and the state is not changed.
+func (sc *StateConfiguration) PermitDynamicDest(trigger Trigger, selector DestinationSelectorFunc, destinations []State, guards ...GuardFunc) *StateConfiguration {
+	if len(destinations) == 0 {
+		panic("stateless: PermitDynamicDest requires at least one possible destination.")
+	}
+	sc.sr.AddTriggerBehaviour(&dynamicTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sc.newGuard(trigger, guards...)},
+		Destination:          selector,
+		Description:          newinvocationInfo(selector),
+		PossibleDestinations: append([]State(nil), destinations...),
 	})
 	return sc
 }
@@ -141,7 +508,44 @@ func (sc *StateConfiguration) OnEntry(action ActionFunc) *StateConfiguration {
 	return sc
 }
 
+// OnEntryExceptReentry specify an action that will execute when transitioning into the configured state,
+// except when reentering it, e.g. to skip expensive initialization on self-transitions configured with PermitReentry.
+func (sc *StateConfiguration) OnEntryExceptReentry(action ActionFunc) *StateConfiguration {
+	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
+		Action:      action,
+		Description: newinvocationInfo(action),
+		Reentry:     reentryExcept,
+	})
+	return sc
+}
+
+// OnReentry specify an action that will execute only when reentering the configured state,
+// i.e. when firing a trigger configured with PermitReentry.
+func (sc *StateConfiguration) OnReentry(action ActionFunc) *StateConfiguration {
+	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
+		Action:      action,
+		Description: newinvocationInfo(action),
+		Reentry:     reentryOnly,
+	})
+	return sc
+}
+
+// OnEntryInitial specify an action that will execute only when the configured state is entered
+// by the initial transition of its superstate, and not when it is the destination of a trigger.
+// The transition passed to the action, available using GetTransition, reports true in IsInitial.
+func (sc *StateConfiguration) OnEntryInitial(action ActionFunc) *StateConfiguration {
+	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
+		Action:      action,
+		Description: newinvocationInfo(action),
+		InitialOnly: true,
+	})
+	return sc
+}
+
 // OnEntryFrom Specify an action that will execute when transitioning into the configured state from a specific trigger.
+// It is also executed when the state is reentered with PermitReentry or entered through an initial transition
+// as a result of the trigger, so a reentry can be discriminated by trigger, e.g. combining PermitReentry(triggerX),
+// OnEntryFrom(triggerX, ...) and Ignore(triggerY).
 func (sc *StateConfiguration) OnEntryFrom(trigger Trigger, action ActionFunc) *StateConfiguration {
 	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
 		Action:      action,
@@ -151,7 +555,25 @@ func (sc *StateConfiguration) OnEntryFrom(trigger Trigger, action ActionFunc) *S
 	return sc
 }
 
+// OnEntryFireAfter fires the specified trigger automatically once the configured state has been active
+// for the given duration. The trigger is handled as configured for the state, e.g. with Permit or PermitReentry,
+// the latter arming the timer again. The timer is armed every time the state is entered through a transition,
+// after executing its entry actions, or when the state machine is activated with Activate in the state,
+// and it is canceled when the state is exited, so the trigger is never fired automatically after leaving the state.
+//
+// The trigger is fired from its own goroutine using Fire, so in FiringQueued mode it is enqueued
+// if the state machine is processing another trigger, and it is dropped if the state is exited before
+// the trigger is processed. In FiringImmediate mode the Fire calls are serialized as if SetSynchronous(true)
+// had been called, so the timed trigger is never fired concurrently with other triggers.
+// Errors are reported to the standard logger.
+func (sc *StateConfiguration) OnEntryFireAfter(trigger Trigger, after time.Duration) *StateConfiguration {
+	sc.sm.timed = true
+	sc.sr.TimedTriggers = append(sc.sr.TimedTriggers, timedTrigger{Trigger: trigger, After: after, fire: sc.sm.fireTimed})
+	return sc
+}
+
 // OnExit specify an action that will execute when transitioning from the configured state.
+// The action can return ErrAbortTransition to cancel the transition, leaving the state unchanged.
 func (sc *StateConfiguration) OnExit(action ActionFunc) *StateConfiguration {
 	sc.sr.ExitActions = append(sc.sr.ExitActions, actionBehaviour{
 		Action:      action,
@@ -170,13 +592,32 @@ func (sc *StateConfiguration) OnExitWith(trigger Trigger, action ActionFunc) *St
 	return sc
 }
 
+// OnUnhandledTrigger specify an action that will execute when a trigger is not handled in the configured state or any of its substates.
+// It takes precedence over the handlers configured for the superstates, which in turn take precedence
+// over the machine-wide handler set with StateMachine.OnUnhandledTrigger.
+func (sc *StateConfiguration) OnUnhandledTrigger(fn UnhandledTriggerActionFunc) *StateConfiguration {
+	sc.sr.UnhandledTriggerAction = fn
+	return sc
+}
+
+// OnUnhandled specify an action that will execute when a trigger is not handled in the configured state or any of its substates.
+//
+// Deprecated: Use OnUnhandledTrigger instead.
+func (sc *StateConfiguration) OnUnhandled(fn UnhandledTriggerActionFunc) *StateConfiguration {
+	return sc.OnUnhandledTrigger(fn)
+}
+
 // SubstateOf sets the superstate that the configured state is a substate of.
 // Substates inherit the allowed transitions of their superstate.
 // When entering directly into a substate from outside of the superstate,
 // entry actions for the superstate are executed.
 // Likewise when leaving from the substate to outside the supserstate,
 // exit actions for the superstate will execute.
+// Calling it again moves the configured state to the new superstate.
 func (sc *StateConfiguration) SubstateOf(superstate State) *StateConfiguration {
+	// Check and change the hierarchy at once, so concurrent calls can't create a cycle.
+	sc.sm.hierarchyMu.Lock()
+	defer sc.sm.hierarchyMu.Unlock()
 	state := sc.sr.State
 	// Check for accidental identical cyclic configuration
 	if state == superstate {
@@ -189,18 +630,16 @@ func (sc *StateConfiguration) SubstateOf(superstate State) *StateConfiguration {
 	// Build list of super states and check for
 
 	activeSc := sc.lookup(superstate)
-	for activeSc.Superstate != nil {
+	for super := activeSc.superstate(); super != nil; super = activeSc.superstate() {
 		// Check if superstate is already added to hashset
-		if _, ok := supersets[activeSc.Superstate.state()]; ok {
+		if _, ok := supersets[super.state()]; ok {
 			panic(fmt.Sprintf("stateless: Configuring %v as a substate of %v creates an illegal nested cyclic configuration.", state, supersets))
 		}
-		supersets[activeSc.Superstate.state()] = empty
-		activeSc = sc.lookup(activeSc.Superstate.state())
+		supersets[super.state()] = empty
+		activeSc = sc.lookup(super.state())
 	}
 
 	// The check was OK, we can add this
-	superRepresentation := sc.lookup(superstate)
-	sc.sr.Superstate = superRepresentation
-	superRepresentation.Substates = append(superRepresentation.Substates, sc.sr)
+	sc.sr.setSuperstate(sc.lookup(superstate))
 	return sc
 }
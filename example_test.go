@@ -128,3 +128,22 @@ func startCallTimer(_ context.Context, _ ...any) error {
 func stopCallTimer() {
 	fmt.Println("[Timer:] Call ended at 11:30am")
 }
+
+func ExampleStateMachine_OverrideGuard() {
+	sm := stateless.NewStateMachine("Draft")
+	sm.Configure("Draft").
+		Permit("Publish", "Published", func(_ context.Context, args ...any) bool {
+			return len(args) > 0 && args[0].(string) == "admin"
+		})
+
+	restore := sm.OverrideGuard("Draft", "Publish", false)
+	ok, _ := sm.CanFire("Publish", "admin")
+	fmt.Println("Can publish with overridden guard:", ok)
+
+	restore()
+	ok, _ = sm.CanFire("Publish", "admin")
+	fmt.Println("Can publish with restored guard:", ok)
+	// Output:
+	// Can publish with overridden guard: false
+	// Can publish with restored guard: true
+}
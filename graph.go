@@ -10,7 +10,30 @@ import (
 	"unicode"
 )
 
+// GraphOptions configures the DOT representation of the state machine.
+type GraphOptions struct {
+	// Tooltips moves the guards and actions of the transitions from the edge labels
+	// to the edge tooltips, which are displayed on hover in interactive outputs such as SVG.
+	Tooltips bool
+	// RankDir sets the direction of the graph layout, such as "TB" or "RL". Defaults to "LR".
+	RankDir string
+	// StateAttributes contains additional DOT attributes for the node of each state, e.g. {"color": "red"}.
+	// States that are only referenced as a destination have no node, so they can't be styled.
+	StateAttributes map[State]map[string]string
+	// HighlightCurrent draws the node of the current state in bold.
+	// The style can be overridden using StateAttributes.
+	HighlightCurrent bool
+	// HighlightActive fills the nodes of the current state and of all its superstates in light blue.
+	// The style can be overridden using StateAttributes.
+	HighlightActive bool
+	// MetadataLabels uses the "label" metadata of the states, attached with StateConfiguration.WithMetadata,
+	// as the label of their nodes instead of the state value.
+	MetadataLabels bool
+}
+
 type graph struct {
+	opts    GraphOptions
+	current *stateRepresentation
 }
 
 type transitionLabel struct {
@@ -18,22 +41,32 @@ type transitionLabel struct {
 	internal      []string
 	transitioning []string
 	ignored       []string
+	tooltips      []string
 }
 
 func (g *graph) formatStateMachine(sm *StateMachine) string {
 	var sb strings.Builder
-	sb.WriteString("digraph {\n\tcompound=true;\n\tnode [shape=Mrecord];\n\trankdir=\"LR\";\n\n")
+	rankDir := g.opts.RankDir
+	if rankDir == "" {
+		rankDir = "LR"
+	}
+	sb.WriteString(fmt.Sprintf("digraph {\n\tcompound=true;\n\tnode [shape=Mrecord];\n\trankdir=\"%s\";\n\n", rankDir))
+	if g.opts.HighlightCurrent || g.opts.HighlightActive {
+		if current, err := sm.State(context.Background()); err == nil {
+			g.current = sm.stateConfig[current]
+		}
+	}
 
 	stateList := make([]*stateRepresentation, 0, len(sm.stateConfig))
 	for _, st := range sm.stateConfig {
 		stateList = append(stateList, st)
 	}
 	sort.Slice(stateList, func(i, j int) bool {
-		return fmt.Sprint(stateList[i].State) < fmt.Sprint(stateList[j].State)
+		return lessByString(stateList[i].State, stateList[j].State)
 	})
 
 	for _, sr := range stateList {
-		if sr.Superstate == nil {
+		if sr.superstate() == nil {
 			g.formatOneState(&sb, sr, 1)
 		}
 	}
@@ -61,18 +94,24 @@ func (g *graph) formatStateMachine(sm *StateMachine) string {
 func (g *graph) formatActions(sr *stateRepresentation) string {
 	es := make([]string, 0, len(sr.EntryActions)+len(sr.ExitActions)+len(sr.ActivateActions)+len(sr.DeactivateActions))
 	for _, act := range sr.ActivateActions {
-		es = append(es, fmt.Sprintf("activated / %s", esc(act.Description.String(), false)))
+		es = append(es, fmt.Sprintf("activated / %s", recordEsc(act.Description.String())))
 	}
 	for _, act := range sr.DeactivateActions {
-		es = append(es, fmt.Sprintf("deactivated / %s", esc(act.Description.String(), false)))
+		es = append(es, fmt.Sprintf("deactivated / %s", recordEsc(act.Description.String())))
 	}
 	for _, act := range sr.EntryActions {
 		if act.Trigger == nil {
-			es = append(es, fmt.Sprintf("entry / %s", esc(act.Description.String(), false)))
+			if act.InitialOnly {
+				es = append(es, fmt.Sprintf("initial entry / %s", recordEsc(act.Description.String())))
+			} else if act.Reentry == reentryOnly {
+				es = append(es, fmt.Sprintf("reentry / %s", recordEsc(act.Description.String())))
+			} else {
+				es = append(es, fmt.Sprintf("entry / %s", recordEsc(act.Description.String())))
+			}
 		}
 	}
 	for _, act := range sr.ExitActions {
-		es = append(es, fmt.Sprintf("exit / %s", esc(act.Description.String(), false)))
+		es = append(es, fmt.Sprintf("exit / %s", recordEsc(act.Description.String())))
 	}
 	return strings.Join(es, "\\n")
 }
@@ -82,50 +121,69 @@ func (g *graph) formatOneState(sb *strings.Builder, sr *stateRepresentation, lev
 	for i := 0; i < level; i++ {
 		indent += "\t"
 	}
-	sb.WriteString(fmt.Sprintf("%s%s [label=\"%s", indent, str(sr.State, true), str(sr.State, false)))
+	label := g.stateLabel(sr)
+	sb.WriteString(fmt.Sprintf("%s%s [label=\"%s", indent, str(sr.State, true), recordEsc(label)))
 	act := g.formatActions(sr)
 	if act != "" {
-		if len(sr.Substates) == 0 {
+		if len(sr.substates()) == 0 {
 			sb.WriteString("|")
 		} else {
 			sb.WriteString("\\n----------\\n")
 		}
 		sb.WriteString(act)
 	}
-	sb.WriteString("\"];\n")
-	if len(sr.Substates) != 0 {
-		sb.WriteString(fmt.Sprintf("%ssubgraph %s {\n%s\tlabel=\"Substates of\\n%s\";\n", indent, clusterStr(sr.State, true, false), indent, str(sr.State, false)))
+	sb.WriteString("\"")
+	for _, attr := range g.stateAttributes(sr.State) {
+		sb.WriteString(", " + attr)
+	}
+	sb.WriteString("];\n")
+	if len(sr.substates()) != 0 {
+		sb.WriteString(fmt.Sprintf("%ssubgraph %s {\n%s\tlabel=\"Substates of\\n%s\";\n", indent, clusterStr(sr.State, true, false), indent, esc(label, false)))
 		sb.WriteString(fmt.Sprintf("%s\tstyle=\"dashed\";\n", indent))
 		if sr.HasInitialState {
 			sb.WriteString(fmt.Sprintf("%s\t\"%s\" [label=\"\", shape=point];\n", indent, clusterStr(sr.State, false, true)))
 		}
-		for _, substate := range sr.Substates {
+		for _, substate := range sr.substates() {
 			g.formatOneState(sb, substate, level+1)
 		}
 		sb.WriteString(indent + "}\n")
 	}
 }
 
-func (g *graph) getEntryActions(ab []actionBehaviour, t Trigger) []string {
-	var actions []string
-	for _, ea := range ab {
-		if ea.Trigger != nil && *ea.Trigger == t {
-			actions = append(actions, esc(ea.Description.String(), false))
+// stateLabel returns the unescaped label of the node of sr.
+func (g *graph) stateLabel(sr *stateRepresentation) string {
+	if g.opts.MetadataLabels {
+		if label, ok := sr.metadata()["label"]; ok {
+			return fmt.Sprint(label)
 		}
 	}
-	return actions
+	return fmt.Sprint(sr.State)
+}
+
+// stateAttributes returns the additional attributes of the node of state, sorted by name.
+func (g *graph) stateAttributes(state State) []string {
+	attrs := make(map[string]string)
+	if g.opts.HighlightCurrent && g.current != nil && g.current.State == state {
+		attrs["style"] = "bold"
+	}
+	if g.opts.HighlightActive && g.current != nil && g.current.IsIncludedInState(state) {
+		attrs["style"] = "filled"
+		attrs["fillcolor"] = "lightblue"
+	}
+	for name, value := range g.opts.StateAttributes[state] {
+		attrs[name] = value
+	}
+	list := make([]string, 0, len(attrs))
+	for name, value := range attrs {
+		list = append(list, fmt.Sprintf("%s=\"%s\"", name, strings.ReplaceAll(value, `"`, `\"`)))
+	}
+	sort.Strings(list)
+	return list
 }
 
 func (g *graph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine, sr *stateRepresentation) {
-	triggerList := make([]triggerBehaviour, 0, len(sr.TriggerBehaviours))
-	for _, triggers := range sr.TriggerBehaviours {
-		triggerList = append(triggerList, triggers...)
-	}
-	sort.Slice(triggerList, func(i, j int) bool {
-		ti := triggerList[i].GetTrigger()
-		tj := triggerList[j].GetTrigger()
-		return fmt.Sprint(ti) < fmt.Sprint(tj)
-	})
+	// The behaviours of the same trigger keep their configuration order.
+	triggerList := sortedBehaviours(sr)
 
 	type line struct {
 		source      State
@@ -134,6 +192,7 @@ func (g *graph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine,
 
 	lines := make(map[line]transitionLabel, len(triggerList))
 	order := make([]line, 0, len(triggerList))
+	var dynamics []*dynamicTriggerBehaviour
 	for _, trigger := range triggerList {
 		switch t := trigger.(type) {
 		case *ignoredTriggerBehaviour:
@@ -142,25 +201,35 @@ func (g *graph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine,
 				order = append(order, ln)
 			}
 			transition := lines[ln]
-			transition.ignored = append(transition.ignored, formatOneTransition(t.Trigger, nil, t.Guard))
+			transition.ignored = append(transition.ignored, g.formatTransition(&transition, t.Trigger, nil, t.Guard))
 			lines[ln] = transition
 		case *reentryTriggerBehaviour:
-			actions := g.getEntryActions(sr.EntryActions, t.Trigger)
+			actions := entryActionsFor(sr.EntryActions, t.Trigger)
 			ln := line{sr.State, t.Destination}
 			if _, ok := lines[ln]; !ok {
 				order = append(order, ln)
 			}
 			transition := lines[ln]
-			transition.reentry = append(transition.reentry, formatOneTransition(t.Trigger, actions, t.Guard))
+			transition.reentry = append(transition.reentry, g.formatTransition(&transition, t.Trigger, actions, t.Guard))
 			lines[ln] = transition
 		case *internalTriggerBehaviour:
-			actions := g.getEntryActions(sr.EntryActions, t.Trigger)
+			// Internal transitions don't execute entry actions.
+			var actions []string
 			ln := line{sr.State, sr.State}
 			if _, ok := lines[ln]; !ok {
 				order = append(order, ln)
 			}
 			transition := lines[ln]
-			transition.internal = append(transition.internal, formatOneTransition(t.Trigger, actions, t.Guard))
+			transition.internal = append(transition.internal, g.formatTransition(&transition, t.Trigger, actions, t.Guard))
+			lines[ln] = transition
+		case *internalDynamicTriggerBehaviour:
+			// Only the internal part can be drawn, the escalation destination is unknown.
+			ln := line{sr.State, sr.State}
+			if _, ok := lines[ln]; !ok {
+				order = append(order, ln)
+			}
+			transition := lines[ln]
+			transition.internal = append(transition.internal, g.formatTransition(&transition, t.Trigger, nil, t.Guard))
 			lines[ln] = transition
 		case *transitioningTriggerBehaviour:
 			src := sm.stateConfig[sr.State]
@@ -168,10 +237,7 @@ func (g *graph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine,
 				continue
 			}
 			dest := sm.stateConfig[t.Destination]
-			var actions []string
-			if dest != nil {
-				actions = g.getEntryActions(dest.EntryActions, t.Trigger)
-			}
+			actions := t.actions(dest)
 			var destState State
 			if dest == nil {
 				destState = t.Destination
@@ -183,16 +249,45 @@ func (g *graph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine,
 				order = append(order, ln)
 			}
 			transition := lines[ln]
-			transition.transitioning = append(transition.transitioning, formatOneTransition(t.Trigger, actions, t.Guard))
+			transition.transitioning = append(transition.transitioning, g.formatTransition(&transition, t.Trigger, actions, t.Guard))
 			lines[ln] = transition
 		case *dynamicTriggerBehaviour:
-			// TODO: not supported yet
+			dynamics = append(dynamics, t)
 		}
 	}
 
 	for _, ln := range order {
 		content := lines[ln]
-		formatOneLine(sb, str(ln.source, true), str(ln.destination, true), toTransitionsLabel(content))
+		var attrs []string
+		if len(content.tooltips) > 0 {
+			attrs = append(attrs, fmt.Sprintf("tooltip=\"%s\"", strings.ReplaceAll(strings.Join(content.tooltips, "\\n"), `"`, `\"`)))
+		}
+		formatOneLine(sb, str(ln.source, true), str(ln.destination, true), toTransitionsLabel(content), attrs...)
+	}
+	g.formatDynamicTransitions(sb, sr, dynamics)
+}
+
+// formatDynamicTransitions draws each dynamic transition as a dashed edge to a decision node
+// labeled with the destination selector, as the destination is only known at runtime.
+// The declared possible destinations are linked to the decision node with dashed edges.
+func (g *graph) formatDynamicTransitions(sb *strings.Builder, sr *stateRepresentation, dynamics []*dynamicTriggerBehaviour) {
+	for i, t := range dynamics {
+		node := fmt.Sprintf("%v_dynamic", sr.State)
+		if i > 0 {
+			node = fmt.Sprintf("%s_%d", node, i+1)
+		}
+		node = esc(node, true)
+		sb.WriteString(fmt.Sprintf("\t%s [label=%s, shape=diamond];\n", node, esc(t.Description.String(), true)))
+		var content transitionLabel
+		content.transitioning = append(content.transitioning, g.formatTransition(&content, t.Trigger, nil, t.Guard))
+		attrs := []string{`style="dashed"`}
+		if len(content.tooltips) > 0 {
+			attrs = append(attrs, fmt.Sprintf("tooltip=\"%s\"", strings.ReplaceAll(strings.Join(content.tooltips, "\\n"), `"`, `\"`)))
+		}
+		formatOneLine(sb, str(sr.State, true), node, toTransitionsLabel(content), attrs...)
+		for _, dest := range t.PossibleDestinations {
+			formatOneLine(sb, node, str(dest, true), esc(fmt.Sprint(t.Trigger), true), `style="dashed"`)
+		}
 	}
 }
 
@@ -232,24 +327,54 @@ func toTransitionsLabel(transitions transitionLabel) string {
 	return sb.String()
 }
 
+// formatTransition returns the label of a transition. When tooltips are enabled the label
+// only contains the trigger, and the actions and guards are added to the tooltip of the edge.
+func (g *graph) formatTransition(tl *transitionLabel, trigger Trigger, actions []string, guards transitionGuard) string {
+	if !g.opts.Tooltips {
+		return formatOneTransition(trigger, actions, guards)
+	}
+	var details []string
+	if descriptions := guards.descriptions(); len(descriptions) > 0 {
+		for i, description := range descriptions {
+			descriptions[i] = esc(description, false)
+		}
+		details = append(details, "guard: "+strings.Join(descriptions, ", "))
+	}
+	if len(actions) > 0 {
+		descriptions := make([]string, len(actions))
+		for i, action := range actions {
+			descriptions[i] = esc(action, false)
+		}
+		details = append(details, "action: "+strings.Join(descriptions, ", "))
+	}
+	if len(details) > 0 {
+		tl.tooltips = append(tl.tooltips, fmt.Sprintf("%s: %s", str(trigger, false), strings.Join(details, "; ")))
+	}
+	return formatOneTransition(trigger, nil, transitionGuard{})
+}
+
+// formatOneTransition returns the unescaped text of a transition, which is escaped by toTransitionsLabel.
 func formatOneTransition(trigger Trigger, actions []string, guards transitionGuard) string {
 	var sb strings.Builder
-	sb.WriteString(str(trigger, false))
+	sb.WriteString(fmt.Sprint(trigger))
 	if len(actions) > 0 {
 		sb.WriteString(" / ")
 		sb.WriteString(strings.Join(actions, ", "))
 	}
-	for _, info := range guards.Guards {
+	for _, description := range guards.descriptions() {
 		if sb.Len() > 0 {
 			sb.WriteString(" ")
 		}
-		sb.WriteString(fmt.Sprintf("[%s]", esc(info.Description.String(), false)))
+		sb.WriteString(fmt.Sprintf("[%s]", description))
 	}
 	return sb.String()
 }
 
-func formatOneLine(sb *strings.Builder, fromNodeName, toNodeName, label string) {
+func formatOneLine(sb *strings.Builder, fromNodeName, toNodeName, label string, attrs ...string) {
 	sb.WriteString(fmt.Sprintf("\t%s -> %s [label=%s", fromNodeName, toNodeName, label))
+	for _, attr := range attrs {
+		sb.WriteString(", " + attr)
+	}
 	sb.WriteString("];\n")
 }
 
@@ -346,6 +471,16 @@ func isStringLit(s string) bool {
 	return true
 }
 
+var recordReplacer = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`, "|", `\|`, "{", `\{`, "}", `\}`, "<", `\<`, ">", `\>`,
+)
+
+// recordEsc escapes s to be used as the text of a field of a record label,
+// where '|', '{', '}', '<' and '>' delimit the fields and the ports.
+func recordEsc(s string) string {
+	return recordReplacer.Replace(s)
+}
+
 func esc(s string, quote bool) string {
 	if len(s) == 0 {
 		return s
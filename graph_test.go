@@ -3,6 +3,7 @@ package stateless_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"os"
 	"reflect"
@@ -122,6 +123,63 @@ func phoneCall() *stateless.StateMachine {
 	return phoneCall
 }
 
+func onEnterFromX(_ context.Context, _ ...any) error { return nil }
+
+func onEnterFromY(_ context.Context, _ ...any) error { return nil }
+
+func onEnterFromZ(_ context.Context, _ ...any) error { return nil }
+
+func withEntryFrom() *stateless.StateMachine {
+	sm := stateless.NewStateMachine("A")
+	sm.Configure("A").
+		Permit("X", "C")
+	sm.Configure("B").
+		Permit("Y", "C")
+	sm.Configure("C").
+		OnEntryFrom("X", onEnterFromX).
+		OnEntryFrom("Y", onEnterFromY).
+		OnEntryFrom("Z", onEnterFromZ).
+		InternalTransition("Z", func(_ context.Context, _ ...any) error {
+			return nil
+		}).
+		Permit("W", "A")
+	return sm
+}
+
+func selectDestination(_ context.Context, _ ...any) (stateless.State, error) {
+	return "B", nil
+}
+
+func isAllowed(_ context.Context, _ ...any) bool { return true }
+
+func withDynamic() *stateless.StateMachine {
+	sm := stateless.NewStateMachine("A")
+	sm.Configure("A").
+		Permit("X", "B").
+		PermitDynamic("Y", selectDestination, isAllowed).
+		PermitDynamic("Z", selectDestination).
+		PermitDynamicDest("W", selectDestination, []stateless.State{"B", "C"})
+	sm.Configure("B")
+	return sm
+}
+
+func withSpecialNames() *stateless.StateMachine {
+	sm := stateless.NewStateMachine("order/paid")
+	sm.Configure("order/paid").
+		OnEntry(onEnterFromX).
+		Permit("X", "a|b")
+	sm.Configure("a|b").
+		OnExit(onEnterFromY).
+		Permit("Y", "{x}")
+	sm.Configure("{x}").
+		InitialTransition("<y>")
+	sm.Configure("<y>").
+		SubstateOf("{x}").
+		OnEntry(onEnterFromZ).
+		Permit("Z", `back\slash "quoted"`)
+	return sm
+}
+
 func TestStateMachine_ToGraph(t *testing.T) {
 	tests := []func() *stateless.StateMachine{
 		emptyWithInitial,
@@ -129,6 +187,9 @@ func TestStateMachine_ToGraph(t *testing.T) {
 		withInitialState,
 		withGuards,
 		withUnicodeNames,
+		withEntryFrom,
+		withDynamic,
+		withSpecialNames,
 		phoneCall,
 	}
 	for _, fn := range tests {
@@ -156,6 +217,175 @@ func TestStateMachine_ToGraph(t *testing.T) {
 	}
 }
 
+func TestStateMachine_ToGraphWithOptions_Tooltips(t *testing.T) {
+	tests := []func() *stateless.StateMachine{
+		withGuards,
+		phoneCall,
+	}
+	for _, fn := range tests {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		sp := strings.Split(name, ".")
+		name = sp[len(sp)-1]
+		t.Run(name, func(t *testing.T) {
+			got := fn().ToGraphWithOptions(stateless.GraphOptions{Tooltips: true})
+			name := "testdata/golden/" + name + "_tooltips.dot"
+			want, err := os.ReadFile(name)
+			want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+			if *update {
+				if !bytes.Equal([]byte(got), want) {
+					os.WriteFile(name, []byte(got), 0666)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal([]byte(got), want) {
+					t.Fatalf("got:\n%swant:\n%s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStateMachine_ToGraphWithOptions_Styled(t *testing.T) {
+	got := phoneCall().ToGraphWithOptions(stateless.GraphOptions{
+		RankDir: "TB",
+		StateAttributes: map[stateless.State]map[string]string{
+			stateOnHold:  {"color": "red", "fontcolor": "red"},
+			stateRinging: {"style": "filled", "fillcolor": "yellow"},
+		},
+		HighlightCurrent: true,
+	})
+	name := "testdata/golden/phoneCall_styled.dot"
+	want, err := os.ReadFile(name)
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if *update {
+		if !bytes.Equal([]byte(got), want) {
+			os.WriteFile(name, []byte(got), 0666)
+		}
+	} else {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte(got), want) {
+			t.Fatalf("got:\n%swant:\n%s", got, want)
+		}
+	}
+}
+
+func TestStateMachine_ToGraphWithOptions_MetadataLabels(t *testing.T) {
+	sm := stateless.NewStateMachine("A")
+	sm.Configure("A").
+		WithMetadata("label", "Start").
+		Permit("X", "B")
+	sm.Configure("B").
+		WithMetadata("label", "End")
+	sm.Configure("C").
+		SubstateOf("B")
+
+	got := sm.ToGraphWithOptions(stateless.GraphOptions{MetadataLabels: true})
+	for _, want := range []string{
+		`A [label="Start"];`,
+		`B [label="End"];`,
+		`label="Substates of\nEnd";`,
+		`C [label="C"];`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the graph to contain %s, got:\n%s", want, got)
+		}
+	}
+	if got := sm.ToGraph(); !strings.Contains(got, `A [label="A"];`) {
+		t.Errorf("expected the metadata labels to be opt-in, got:\n%s", got)
+	}
+}
+
+func TestStateMachine_ToGraphCurrent(t *testing.T) {
+	sm := phoneCall()
+	sm.Fire(triggerCallDialed, "qmuntal")
+	sm.Fire(triggerCallConnected)
+	sm.Fire(triggerPlacedOnHold)
+	got, err := sm.ToGraphCurrent(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "testdata/golden/phoneCall_current.dot"
+	want, err := os.ReadFile(name)
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if *update {
+		if !bytes.Equal([]byte(got), want) {
+			os.WriteFile(name, []byte(got), 0666)
+		}
+	} else {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte(got), want) {
+			t.Fatalf("got:\n%swant:\n%s", got, want)
+		}
+	}
+}
+
+func TestStateMachine_ToGraphCurrent_Error(t *testing.T) {
+	wantErr := errors.New("storage error")
+	sm := stateless.NewStateMachineWithExternalStorage(func(_ context.Context) (stateless.State, error) {
+		return nil, wantErr
+	}, func(_ context.Context, _ stateless.State) error {
+		return nil
+	}, stateless.FiringQueued)
+	if _, err := sm.ToGraphCurrent(context.Background()); !errors.Is(err, wantErr) {
+		t.Errorf("expected %v, got %v", wantErr, err)
+	}
+}
+
+func TestStateMachine_ToGraph_PermitAny(t *testing.T) {
+	sm := stateless.NewStateMachine("A")
+	sm.Configure("A").
+		PermitAny("X", "B", isPremium, hasCredit)
+
+	want := `A -> B [label=<<TABLE BORDER="0"><TR><TD ALIGN="LEFT">X [isPremium || hasCredit]</TD></TR></TABLE>>];`
+	if got := sm.ToGraph(); !strings.Contains(got, want) {
+		t.Errorf("expected the graph to contain %s, got:\n%s", want, got)
+	}
+}
+
+func TestStateMachine_ToGraph_Deterministic(t *testing.T) {
+	// States and triggers of different types that print the same must be ordered too.
+	sm := stateless.NewStateMachine("1")
+	sm.Configure("1").
+		Permit(1, 1).
+		Permit("1", "2").
+		Permit("2", 1).
+		Ignore("3")
+	sm.Configure(1).
+		Permit("1", "1").
+		Permit(1, "2")
+	sm.Configure("2")
+
+	want := sm.ToGraph()
+	for i := 0; i < 50; i++ {
+		if got := sm.ToGraph(); got != want {
+			t.Fatalf("expected the same graph in every execution, got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestStateMachine_ToGraph_PermitWithAction(t *testing.T) {
+	sm := stateless.NewStateMachine("A")
+	sm.Configure("A").
+		PermitWithAction("X", "B", chargeCard, isPremium)
+
+	want := `A -> B [label=<<TABLE BORDER="0"><TR><TD ALIGN="LEFT">X / chargeCard [isPremium]</TD></TR></TABLE>>];`
+	if got := sm.ToGraph(); !strings.Contains(got, want) {
+		t.Errorf("expected the graph to contain %s, got:\n%s", want, got)
+	}
+}
+
+func chargeCard(_ context.Context, _ ...any) error { return nil }
+
+func isPremium(_ context.Context, _ ...any) bool { return true }
+
+func hasCredit(_ context.Context, _ ...any) bool { return false }
+
 func BenchmarkToGraph(b *testing.B) {
 	sm := phoneCall()
 	b.ResetTimer()
@@ -0,0 +1,116 @@
+package stateless
+
+// TransitionKind describes how a trigger is handled by a state.
+type TransitionKind uint8
+
+const (
+	// KindTransitioning is a transition to another state, configured with Permit.
+	KindTransitioning TransitionKind = iota
+	// KindReentry is a transition that exits and re-enters the state, configured with PermitReentry.
+	KindReentry
+	// KindInternal is a transition that executes an action without exiting the state, configured with InternalTransition.
+	KindInternal
+	// KindIgnored is a trigger that is ignored, configured with Ignore.
+	KindIgnored
+	// KindDynamic is a transition whose destination is selected at runtime, configured with PermitDynamic.
+	KindDynamic
+)
+
+func (k TransitionKind) String() string {
+	switch k {
+	case KindTransitioning:
+		return "transitioning"
+	case KindReentry:
+		return "reentry"
+	case KindInternal:
+		return "internal"
+	case KindIgnored:
+		return "ignored"
+	case KindDynamic:
+		return "dynamic"
+	}
+	return "unknown"
+}
+
+// TransitionInfo describes a configured transition.
+type TransitionInfo struct {
+	// Trigger is the trigger that causes the transition.
+	Trigger Trigger
+	// Source is the state where the transition is configured,
+	// which can be a superstate of the state being inspected.
+	Source State
+	// Destination is the destination state of the transition.
+	// It is nil for ignored triggers and dynamic transitions, and equal to Source for internal transitions.
+	Destination State
+	// GuardDescriptions contains the descriptions of the guards that gate the transition.
+	GuardDescriptions []string
+	// AnyGuard is true if the transition is permitted when any of the guards is met, instead of all of them.
+	AnyGuard bool
+	// Kind describes how the trigger is handled.
+	Kind TransitionKind
+	// PossibleDestinations contains the states a dynamic transition may select,
+	// if they have been declared with PermitDynamicDest.
+	PossibleDestinations []State
+}
+
+func newTransitionInfo(sr *stateRepresentation, behaviour triggerBehaviour) TransitionInfo {
+	info := TransitionInfo{Trigger: behaviour.GetTrigger(), Source: sr.State}
+	var guard transitionGuard
+	switch t := behaviour.(type) {
+	case *transitioningTriggerBehaviour:
+		info.Kind, info.Destination, guard = KindTransitioning, t.Destination, t.Guard
+	case *reentryTriggerBehaviour:
+		info.Kind, info.Destination, guard = KindReentry, t.Destination, t.Guard
+	case *internalTriggerBehaviour:
+		info.Kind, info.Destination, guard = KindInternal, sr.State, t.Guard
+	case *ignoredTriggerBehaviour:
+		info.Kind, guard = KindIgnored, t.Guard
+	case *dynamicTriggerBehaviour:
+		info.Kind, guard = KindDynamic, t.Guard
+		info.PossibleDestinations = append([]State(nil), t.PossibleDestinations...)
+	case *internalDynamicTriggerBehaviour:
+		info.Kind, guard = KindDynamic, t.Guard
+	}
+	info.AnyGuard = guard.Any
+	if len(guard.Guards) != 0 {
+		info.GuardDescriptions = make([]string, len(guard.Guards))
+		for i, g := range guard.Guards {
+			info.GuardDescriptions[i] = g.Description.String()
+		}
+	}
+	return info
+}
+
+// StateNode is a node of the state hierarchy returned by StateMachine.Hierarchy.
+type StateNode struct {
+	// State is the state represented by the node.
+	State State
+	// Children contains the substates of the state, in the order they were configured.
+	Children []*StateNode
+	// HasInitialTransition is true if the state has been configured with an initial transition.
+	HasInitialTransition bool
+	// InitialTransitionTarget is the target of the initial transition, if any.
+	InitialTransitionTarget State
+	// IsInitial is true if the state is the target of the initial transition of its superstate.
+	IsInitial bool
+}
+
+// IsLeaf returns true if the state has no substates.
+func (n *StateNode) IsLeaf() bool {
+	return len(n.Children) == 0
+}
+
+func newStateNode(sr *stateRepresentation) *StateNode {
+	node := &StateNode{
+		State:                   sr.State,
+		HasInitialTransition:    sr.HasInitialState,
+		InitialTransitionTarget: sr.InitialTransitionTarget,
+	}
+	if super := sr.superstate(); super != nil && super.HasInitialState {
+		node.IsInitial = super.InitialTransitionTarget == sr.State
+	}
+	for _, substate := range sr.substates() {
+		node.Children = append(node.Children, newStateNode(substate))
+	}
+	return node
+}
@@ -0,0 +1,72 @@
+package stateless_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qmuntal/stateless"
+)
+
+func TestStateMachine_Hierarchy(t *testing.T) {
+	got := withInitialState().Hierarchy()
+	want := []*stateless.StateNode{
+		{State: "A"},
+		{
+			State:                   "B",
+			HasInitialTransition:    true,
+			InitialTransitionTarget: "C",
+			Children: []*stateless.StateNode{
+				{
+					State:                   "C",
+					HasInitialTransition:    true,
+					InitialTransitionTarget: "D",
+					IsInitial:               true,
+					Children: []*stateless.StateNode{
+						{State: "D", IsInitial: true},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Hierarchy() = %v, want %v", got, want)
+	}
+	if got[0].IsLeaf() != true || got[1].IsLeaf() != false || got[1].Children[0].Children[0].IsLeaf() != true {
+		t.Error("unexpected leaf nodes")
+	}
+}
+
+func TestStateMachine_Hierarchy_Forest(t *testing.T) {
+	got := withSubstate().Hierarchy()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(got))
+	}
+	if got[0].State != "A" || got[1].State != "C" {
+		t.Errorf("roots = %v, %v, want A, C", got[0].State, got[1].State)
+	}
+	if len(got[1].Children) != 1 || got[1].Children[0].State != "B" || got[1].Children[0].IsInitial {
+		t.Errorf("unexpected children of C: %v", got[1].Children)
+	}
+}
+
+func TestStateMachine_CommonAncestor(t *testing.T) {
+	sm := withInitialState()
+	tests := []struct {
+		a, b   string
+		want   stateless.State
+		wantOk bool
+	}{
+		{"D", "C", "C", true},
+		{"C", "D", "C", true},
+		{"D", "B", "B", true},
+		{"D", "D", "D", true},
+		{"A", "D", nil, false},
+		{"A", "Z", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := sm.CommonAncestor(tt.a, tt.b)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("CommonAncestor(%v, %v) = %v, %v, want %v, %v", tt.a, tt.b, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package stateless
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type jsonStateMachine struct {
+	State             State     `json:"state"`
+	Firing            bool      `json:"firing"`
+	PermittedTriggers []Trigger `json:"permittedTriggers"`
+}
+
+type jsonError struct {
+	Error string `json:"error"`
+}
+
+// MarshalJSON implements json.Marshaler, so the state machine can be logged directly
+// by structured logging frameworks. The output contains the current state, whether the state machine
+// is firing and the currently-permissible triggers, sorted by their string representation:
+//
+//	{"state":"OffHook","firing":false,"permittedTriggers":["CallDialed"]}
+//
+// If the state accessor returns an error, the output is {"error":"<message>"}.
+func (sm *StateMachine) MarshalJSON() ([]byte, error) {
+	ctx := context.Background()
+	sr, err := sm.currentState(ctx)
+	if err != nil {
+		return json.Marshal(jsonError{Error: err.Error()})
+	}
+	triggers := sr.PermittedTriggers(ctx)
+	sort.Slice(triggers, func(i, j int) bool {
+		return fmt.Sprint(triggers[i]) < fmt.Sprint(triggers[j])
+	})
+	if triggers == nil {
+		triggers = []Trigger{}
+	}
+	return json.Marshal(jsonStateMachine{
+		State:             sr.State,
+		Firing:            sm.Firing(),
+		PermittedTriggers: triggers,
+	})
+}
+
+type jsonConfig struct {
+	States []jsonStateConfig `json:"states"`
+}
+
+type jsonStateConfig struct {
+	State             State            `json:"state"`
+	Superstate        State            `json:"superstate,omitempty"`
+	InitialTransition State            `json:"initialTransition,omitempty"`
+	History           bool             `json:"history,omitempty"`
+	EntryActions      []jsonAction     `json:"entryActions,omitempty"`
+	ExitActions       []jsonAction     `json:"exitActions,omitempty"`
+	ActivateActions   []jsonAction     `json:"activateActions,omitempty"`
+	DeactivateActions []jsonAction     `json:"deactivateActions,omitempty"`
+	Transitions       []jsonTransition `json:"transitions,omitempty"`
+	BlockedTriggers   []Trigger        `json:"blockedTriggers,omitempty"`
+}
+
+type jsonAction struct {
+	Description string  `json:"description"`
+	Trigger     Trigger `json:"trigger,omitempty"`
+	Reentry     string  `json:"reentry,omitempty"`
+	Initial     bool    `json:"initial,omitempty"`
+}
+
+type jsonTransition struct {
+	Trigger      Trigger  `json:"trigger"`
+	Kind         string   `json:"kind"`
+	Destination  State    `json:"destination,omitempty"`
+	Selector     string   `json:"selector,omitempty"`
+	Destinations []State  `json:"destinations,omitempty"`
+	Guards       []string `json:"guards,omitempty"`
+	AnyGuard     bool     `json:"anyGuard,omitempty"`
+	Priority     int      `json:"priority,omitempty"`
+	Override     bool     `json:"override,omitempty"`
+}
+
+// MarshalConfig returns the JSON representation of the static configuration of the state machine,
+// which is stable and suitable for tooling and diffing. The current state is not included.
+//
+// The output contains every known state, sorted by their string representation, with its superstate,
+// initial transition, actions and transitions. Functions are represented by their descriptions,
+// the same ones used in ToGraph. The transitions of each state are sorted by the string representation
+// of their trigger, keeping the configuration order for the same trigger.
+func (sm *StateMachine) MarshalConfig() ([]byte, error) {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	stateList := make([]*stateRepresentation, 0, len(sm.stateConfig))
+	for _, sr := range sm.stateConfig {
+		stateList = append(stateList, sr)
+	}
+	sort.Slice(stateList, func(i, j int) bool {
+		return fmt.Sprint(stateList[i].State) < fmt.Sprint(stateList[j].State)
+	})
+	config := jsonConfig{States: make([]jsonStateConfig, len(stateList))}
+	for i, sr := range stateList {
+		config.States[i] = newJSONStateConfig(sr)
+	}
+	return json.Marshal(config)
+}
+
+func newJSONStateConfig(sr *stateRepresentation) jsonStateConfig {
+	sc := jsonStateConfig{State: sr.State, History: sr.HasHistory}
+	if super := sr.superstate(); super != nil {
+		sc.Superstate = super.State
+	}
+	if sr.HasInitialState {
+		sc.InitialTransition = sr.InitialTransitionTarget
+	}
+	for _, act := range sr.EntryActions {
+		action := jsonAction{Description: act.Description.String(), Initial: act.InitialOnly}
+		if act.Trigger != nil {
+			action.Trigger = *act.Trigger
+		}
+		switch act.Reentry {
+		case reentryOnly:
+			action.Reentry = "only"
+		case reentryExcept:
+			action.Reentry = "except"
+		}
+		sc.EntryActions = append(sc.EntryActions, action)
+	}
+	for _, act := range sr.ExitActions {
+		sc.ExitActions = append(sc.ExitActions, jsonAction{Description: act.Description.String()})
+	}
+	for _, act := range sr.ActivateActions {
+		sc.ActivateActions = append(sc.ActivateActions, jsonAction{Description: act.Description.String()})
+	}
+	for _, act := range sr.DeactivateActions {
+		sc.DeactivateActions = append(sc.DeactivateActions, jsonAction{Description: act.Description.String()})
+	}
+	var behaviours []triggerBehaviour
+	for _, bs := range sr.TriggerBehaviours {
+		behaviours = append(behaviours, bs...)
+	}
+	sort.SliceStable(behaviours, func(i, j int) bool {
+		return fmt.Sprint(behaviours[i].GetTrigger()) < fmt.Sprint(behaviours[j].GetTrigger())
+	})
+	for _, behaviour := range behaviours {
+		info := newTransitionInfo(sr, behaviour)
+		transition := jsonTransition{
+			Trigger:     info.Trigger,
+			Kind:        info.Kind.String(),
+			Destination: info.Destination,
+			Guards:      info.GuardDescriptions,
+			AnyGuard:    info.AnyGuard,
+			Priority:    behaviour.GetPriority(),
+		}
+		switch t := behaviour.(type) {
+		case *dynamicTriggerBehaviour:
+			transition.Selector = t.Description.String()
+			transition.Destinations = info.PossibleDestinations
+		case *transitioningTriggerBehaviour:
+			transition.Override = t.Override
+		}
+		sc.Transitions = append(sc.Transitions, transition)
+	}
+	for trigger := range sr.BlockedTriggers {
+		sc.BlockedTriggers = append(sc.BlockedTriggers, trigger)
+	}
+	sort.Slice(sc.BlockedTriggers, func(i, j int) bool {
+		return fmt.Sprint(sc.BlockedTriggers[i]) < fmt.Sprint(sc.BlockedTriggers[j])
+	})
+	return sc
+}
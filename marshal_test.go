@@ -0,0 +1,87 @@
+package stateless_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/qmuntal/stateless"
+)
+
+func TestStateMachine_MarshalJSON(t *testing.T) {
+	sm := phoneCall()
+	sm.Fire(triggerCallDialed, "qmuntal")
+	sm.Fire(triggerCallConnected)
+
+	got, err := json.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"state":"Connected","firing":false,"permittedTriggers":["LeftMessage","MuteMicrophone","PlacedOnHold","SetVolume","UnmuteMicrophone"]}`
+	if string(got) != want {
+		t.Errorf("json.Marshal() = %s, want %s", got, want)
+	}
+}
+
+func TestStateMachine_MarshalJSON_Error(t *testing.T) {
+	sm := stateless.NewStateMachineWithExternalStorage(func(_ context.Context) (stateless.State, error) {
+		return nil, errors.New("storage unavailable")
+	}, func(_ context.Context, _ stateless.State) error {
+		return nil
+	}, stateless.FiringQueued)
+
+	got, err := json.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"error":"storage unavailable"}`; string(got) != want {
+		t.Errorf("json.Marshal() = %s, want %s", got, want)
+	}
+}
+
+func TestStateMachine_MarshalConfig(t *testing.T) {
+	tests := []func() *stateless.StateMachine{
+		withSubstate,
+		withInitialState,
+		withEntryFrom,
+		withDynamic,
+		phoneCall,
+	}
+	for _, fn := range tests {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		sp := strings.Split(name, ".")
+		name = sp[len(sp)-1]
+		t.Run(name, func(t *testing.T) {
+			got, err := fn().MarshalConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, got, "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			indented.WriteByte('\n')
+			name := "testdata/golden/" + name + ".json"
+			want, err := os.ReadFile(name)
+			want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+			if *update {
+				if !bytes.Equal(indented.Bytes(), want) {
+					os.WriteFile(name, indented.Bytes(), 0666)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(indented.Bytes(), want) {
+					t.Fatalf("got:\n%swant:\n%s", indented.Bytes(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package stateless
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// mermaidGraph renders a state machine as a Mermaid stateDiagram-v2.
+type mermaidGraph struct {
+	ids map[State]string
+}
+
+func (g *mermaidGraph) formatStateMachine(sm *StateMachine) string {
+	var sb strings.Builder
+	sb.WriteString("stateDiagram-v2\n")
+
+	stateList := make([]*stateRepresentation, 0, len(sm.stateConfig))
+	for _, st := range sm.stateConfig {
+		stateList = append(stateList, st)
+	}
+	sort.Slice(stateList, func(i, j int) bool {
+		return fmt.Sprint(stateList[i].State) < fmt.Sprint(stateList[j].State)
+	})
+
+	g.ids = make(map[State]string, len(stateList))
+	for _, sr := range stateList {
+		if sr.superstate() == nil {
+			g.formatOneState(&sb, sr, 1)
+		}
+	}
+	for _, sr := range stateList {
+		g.formatAllStateTransitions(&sb, sm, sr)
+	}
+	initialState, err := sm.State(context.Background())
+	if err == nil {
+		sb.WriteString(fmt.Sprintf("\t[*] --> %s\n", g.id(&sb, initialState, "\t")))
+	}
+	return sb.String()
+}
+
+// id returns the identifier of the state in the diagram. States whose name is not a valid
+// Mermaid identifier are declared with an alias the first time they are referenced.
+func (g *mermaidGraph) id(sb *strings.Builder, state State, indent string) string {
+	if id, ok := g.ids[state]; ok {
+		return id
+	}
+	name := fmt.Sprint(state)
+	id := name
+	if !isMermaidID(name) {
+		id = fmt.Sprintf("_s%d", len(g.ids))
+		sb.WriteString(fmt.Sprintf("%sstate \"%s\" as %s\n", indent, mermaidEsc(name), id))
+	}
+	g.ids[state] = id
+	return id
+}
+
+func (g *mermaidGraph) formatOneState(sb *strings.Builder, sr *stateRepresentation, level int) {
+	indent := strings.Repeat("\t", level)
+	id := g.id(sb, sr.State, indent)
+	if len(sr.substates()) == 0 {
+		// Aliased states have already been declared by id.
+		if id == fmt.Sprint(sr.State) {
+			sb.WriteString(fmt.Sprintf("%s%s\n", indent, id))
+		}
+	} else {
+		sb.WriteString(fmt.Sprintf("%sstate %s {\n", indent, id))
+		for _, substate := range sr.substates() {
+			g.formatOneState(sb, substate, level+1)
+		}
+		if sr.HasInitialState {
+			sb.WriteString(fmt.Sprintf("%s\t[*] --> %s\n", indent, g.id(sb, sr.InitialTransitionTarget, indent+"\t")))
+		}
+		sb.WriteString(indent + "}\n")
+	}
+	for _, act := range g.formatActions(sr) {
+		sb.WriteString(fmt.Sprintf("%s%s : %s\n", indent, id, act))
+	}
+}
+
+func (g *mermaidGraph) formatActions(sr *stateRepresentation) []string {
+	es := make([]string, 0, len(sr.EntryActions)+len(sr.ExitActions)+len(sr.ActivateActions)+len(sr.DeactivateActions))
+	for _, act := range sr.ActivateActions {
+		es = append(es, "activated / "+mermaidEsc(act.Description.String()))
+	}
+	for _, act := range sr.DeactivateActions {
+		es = append(es, "deactivated / "+mermaidEsc(act.Description.String()))
+	}
+	for _, act := range sr.EntryActions {
+		if act.Trigger == nil {
+			if act.InitialOnly {
+				es = append(es, "initial entry / "+mermaidEsc(act.Description.String()))
+			} else if act.Reentry == reentryOnly {
+				es = append(es, "reentry / "+mermaidEsc(act.Description.String()))
+			} else {
+				es = append(es, "entry / "+mermaidEsc(act.Description.String()))
+			}
+		}
+	}
+	for _, act := range sr.ExitActions {
+		es = append(es, "exit / "+mermaidEsc(act.Description.String()))
+	}
+	return es
+}
+
+func (g *mermaidGraph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine, sr *stateRepresentation) {
+	triggerList := make([]triggerBehaviour, 0, len(sr.TriggerBehaviours))
+	for _, triggers := range sr.TriggerBehaviours {
+		triggerList = append(triggerList, triggers...)
+	}
+	sort.SliceStable(triggerList, func(i, j int) bool {
+		return fmt.Sprint(triggerList[i].GetTrigger()) < fmt.Sprint(triggerList[j].GetTrigger())
+	})
+
+	src := g.id(sb, sr.State, "\t")
+	for _, trigger := range triggerList {
+		var (
+			dest    State
+			actions []actionBehaviour
+			kind    string
+		)
+		switch t := trigger.(type) {
+		case *ignoredTriggerBehaviour:
+			dest, kind = sr.State, "ignored"
+		case *reentryTriggerBehaviour:
+			dest, actions, kind = t.Destination, sr.EntryActions, "reentry"
+		case *internalTriggerBehaviour, *internalDynamicTriggerBehaviour:
+			// Internal transitions don't execute entry actions.
+			dest, kind = sr.State, "internal"
+		case *transitioningTriggerBehaviour:
+			dest = t.Destination
+			if t.Action != nil {
+				trigger := t.Trigger
+				actions = append(actions, actionBehaviour{Description: t.ActionDescription, Trigger: &trigger})
+			}
+			if rep := sm.stateConfig[t.Destination]; rep != nil {
+				actions = append(actions, rep.EntryActions...)
+			}
+		case *dynamicTriggerBehaviour:
+			// Only the declared possible destinations can be drawn.
+			label := g.formatOneTransition(trigger.GetTrigger(), nil, t.Guard) + " (dynamic)"
+			for _, dest := range t.PossibleDestinations {
+				sb.WriteString(fmt.Sprintf("\t%s --> %s : %s\n", src, g.id(sb, dest, "\t"), label))
+			}
+			continue
+		default:
+			continue
+		}
+		label := g.formatOneTransition(trigger.GetTrigger(), actions, trigger.base().Guard)
+		if kind != "" {
+			label += " (" + kind + ")"
+		}
+		sb.WriteString(fmt.Sprintf("\t%s --> %s : %s\n", src, g.id(sb, dest, "\t"), label))
+	}
+}
+
+func (g *mermaidGraph) formatOneTransition(trigger Trigger, entryActions []actionBehaviour, guards transitionGuard) string {
+	var sb strings.Builder
+	sb.WriteString(mermaidEsc(fmt.Sprint(trigger)))
+	var actions []string
+	for _, ea := range entryActions {
+		if ea.Trigger != nil && *ea.Trigger == trigger {
+			actions = append(actions, mermaidEsc(ea.Description.String()))
+		}
+	}
+	if len(actions) > 0 {
+		sb.WriteString(" / ")
+		sb.WriteString(strings.Join(actions, ", "))
+	}
+	for _, description := range guards.descriptions() {
+		sb.WriteString(fmt.Sprintf(" [%s]", mermaidEsc(description)))
+	}
+	return sb.String()
+}
+
+// isMermaidID reports whether s can be used as a state identifier without an alias.
+func isMermaidID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case '0' <= c && c <= '9':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// mermaidEsc replaces the characters that have a special meaning in Mermaid labels by their entity codes.
+func mermaidEsc(s string) string {
+	return strings.NewReplacer(
+		"#", "#35;",
+		"\"", "#quot;",
+		":", "#58;",
+		";", "#59;",
+		"<", "#lt;",
+		">", "#gt;",
+		"\n", " ",
+	).Replace(s)
+}
@@ -8,7 +8,10 @@ import (
 
 type fireMode interface {
 	Fire(ctx context.Context, trigger Trigger, args ...any) error
+	FirePrepared(ctx context.Context, p *PreparedFire) error
 	Firing() bool
+	QueueLen() int
+	Reset()
 }
 
 type fireModeImmediate struct {
@@ -20,16 +23,45 @@ func (f *fireModeImmediate) Firing() bool {
 	return f.ops.Load() > 0
 }
 
+func (f *fireModeImmediate) QueueLen() int {
+	return 0
+}
+
+func (f *fireModeImmediate) Reset() {}
+
 func (f *fireModeImmediate) Fire(ctx context.Context, trigger Trigger, args ...any) error {
-	f.ops.Add(1)
 	defer f.ops.Add(^uint64(0))
+	if f.ops.Add(1) > 1 && f.sm.rejectReentrant && trigger != CompletionTrigger {
+		return ErrReentrantFire
+	}
 	return f.sm.internalFireOne(ctx, trigger, args...)
 }
 
+func (f *fireModeImmediate) FirePrepared(ctx context.Context, p *PreparedFire) error {
+	defer f.ops.Add(^uint64(0))
+	if f.ops.Add(1) > 1 && f.sm.rejectReentrant {
+		return ErrReentrantFire
+	}
+	return f.sm.firePreparedOne(ctx, p)
+}
+
 type queuedTrigger struct {
 	Context context.Context
 	Trigger Trigger
 	Args    []any
+	// Queued is true when the trigger was enqueued while another one was being processed.
+	Queued bool
+	// Prepared is not nil when the handler has been resolved by PrepareFire.
+	Prepared *PreparedFire
+	// Batch is not nil when the trigger has been enqueued by FireBatch.
+	Batch *triggerBatch
+	// BatchIndex is the position of the trigger in Batch.
+	BatchIndex int
+}
+
+// triggerBatch tracks the progress of a batch of triggers enqueued by FireBatch.
+type triggerBatch struct {
+	done atomic.Int64 // number of triggers of the batch processed successfully
 }
 
 type fireModeQueued struct {
@@ -44,45 +76,150 @@ func (f *fireModeQueued) Firing() bool {
 	return f.firing.Load()
 }
 
+func (f *fireModeQueued) QueueLen() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.triggers)
+}
+
+// Reset discards the triggers that are waiting to be processed.
+func (f *fireModeQueued) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.triggers = nil
+}
+
 func (f *fireModeQueued) Fire(ctx context.Context, trigger Trigger, args ...any) error {
-	f.enqueue(ctx, trigger, args...)
+	if outer, ok := ctx.Value(transitionTimeoutKey{}).(context.Context); ok {
+		// Queued triggers are processed after the current transition, so its timeout doesn't apply.
+		ctx = outer
+	}
+	if err := f.enqueue(ctx, trigger, args...); err != nil {
+		return err
+	}
+	return f.drain(ctx)
+}
+
+// drain processes the queued triggers until the queue is empty.
+// It stops when ctx is done, leaving the remaining triggers queued for a later drain.
+func (f *fireModeQueued) drain(ctx context.Context) error {
 	for {
-		et, ok := f.fetch()
+		et, ok, err := f.fetch(ctx)
+		if err != nil {
+			return err
+		}
 		if !ok {
 			break
 		}
-		err := f.execute(et)
-		if err != nil {
+		if err = f.execute(et); err != nil {
 			return err
 		}
+		if et.Batch != nil {
+			et.Batch.done.Store(int64(et.BatchIndex + 1))
+		}
 	}
 	return nil
 }
 
-func (f *fireModeQueued) enqueue(ctx context.Context, trigger Trigger, args ...any) {
+func (f *fireModeQueued) FireBatch(ctx context.Context, triggers []TriggerArg) (int, error) {
+	if outer, ok := ctx.Value(transitionTimeoutKey{}).(context.Context); ok {
+		ctx = outer
+	}
+	batch := new(triggerBatch)
+	queued := f.Firing()
+	for i, ta := range triggers {
+		tctx, args := f.sm.fireContext(ctx, ta.Trigger, ta.Args)
+		if f.sm.argCloner != nil {
+			args = f.sm.argCloner(args)
+		}
+		if err := f.push(queuedTrigger{Context: tctx, Trigger: ta.Trigger, Args: args, Queued: queued, Batch: batch, BatchIndex: i}); err != nil {
+			f.discard(batch)
+			return i, err
+		}
+	}
+	if queued {
+		return len(triggers), nil
+	}
+	if err := f.drain(ctx); err != nil {
+		f.discard(batch)
+		if done := int(batch.done.Load()); done < len(triggers) {
+			return done, err
+		}
+		// The error comes from a trigger queued after the batch, which has been fully processed.
+	}
+	return len(triggers), nil
+}
+
+// discard removes the triggers of batch that are still queued.
+func (f *fireModeQueued) discard(batch *triggerBatch) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	triggers := f.triggers[:0]
+	for _, et := range f.triggers {
+		if et.Batch != batch {
+			triggers = append(triggers, et)
+		}
+	}
+	f.triggers = triggers
+}
 
-	f.triggers = append(f.triggers, queuedTrigger{Context: ctx, Trigger: trigger, Args: args})
+func (f *fireModeQueued) FirePrepared(ctx context.Context, p *PreparedFire) error {
+	if outer, ok := ctx.Value(transitionTimeoutKey{}).(context.Context); ok {
+		ctx = outer
+	}
+	if err := f.push(queuedTrigger{Context: ctx, Trigger: p.trigger, Args: p.args, Queued: f.Firing(), Prepared: p}); err != nil {
+		return err
+	}
+	return f.drain(ctx)
 }
 
-func (f *fireModeQueued) fetch() (et queuedTrigger, ok bool) {
+func (f *fireModeQueued) enqueue(ctx context.Context, trigger Trigger, args ...any) error {
+	if f.sm.argCloner != nil {
+		args = f.sm.argCloner(args)
+	}
+	return f.push(queuedTrigger{Context: ctx, Trigger: trigger, Args: args, Queued: f.Firing()})
+}
+
+func (f *fireModeQueued) push(et queuedTrigger) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.sm.maxQueueDepth > 0 && len(f.triggers) >= f.sm.maxQueueDepth {
+		return ErrQueueFull
+	}
+	f.triggers = append(f.triggers, et)
+	return nil
+}
+
+func (f *fireModeQueued) fetch(ctx context.Context) (et queuedTrigger, ok bool, err error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	if len(f.triggers) == 0 {
-		return queuedTrigger{}, false
+		return queuedTrigger{}, false, nil
 	}
 
 	if !f.firing.CompareAndSwap(false, true) {
-		return queuedTrigger{}, false
+		return queuedTrigger{}, false, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		f.firing.Store(false)
+		return queuedTrigger{}, false, err
 	}
 
 	et, f.triggers = f.triggers[0], f.triggers[1:]
-	return et, true
+	return et, true, nil
 }
 
 func (f *fireModeQueued) execute(et queuedTrigger) error {
 	defer f.firing.Swap(false)
-	return f.sm.internalFireOne(et.Context, et.Trigger, et.Args...)
+	ctx := et.Context
+	if et.Queued && f.sm.dropUnhandledQueued {
+		ctx = context.WithValue(ctx, droppableTriggerKey{}, true)
+	}
+	if et.Prepared != nil {
+		return f.sm.firePreparedOne(ctx, et.Prepared)
+	}
+	return f.sm.internalFireOne(ctx, et.Trigger, et.Args...)
 }
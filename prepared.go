@@ -0,0 +1,85 @@
+package stateless
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStalePreparedFire is returned by FirePrepared when the state of the state machine
+// has changed since the fire was prepared.
+var ErrStalePreparedFire = errors.New("stateless: the state has changed since the fire was prepared")
+
+// PreparedFire is a trigger whose handler has already been resolved by PrepareFire.
+// It is only valid while the state machine stays in the state it was prepared in.
+type PreparedFire struct {
+	trigger     Trigger
+	args        []any
+	source      State
+	sr          *stateRepresentation
+	handler     triggerBehaviour // nil if the trigger is not handled
+	unmetGuards []string
+}
+
+// PrepareFire resolves the handler of the trigger in the current state, evaluating the guards with the given arguments,
+// and returns a handle that can be fired with FirePrepared without resolving it again.
+// This is an escape hatch for hot paths that fire the same trigger from the same state many times.
+//
+// No action nor callback is executed until the handle is fired: if the trigger can't be handled,
+// FirePrepared executes the unhandled trigger action. An error is only returned if the state accessor
+// or a guard fails.
+func (sm *StateMachine) PrepareFire(ctx context.Context, trigger Trigger, args ...any) (*PreparedFire, error) {
+	ctx, args = sm.fireContext(ctx, trigger, args)
+	source, sr, err := sm.fireSource(ctx, trigger, args)
+	if err != nil {
+		return nil, err
+	}
+	result, ok, err := sm.resolveHandler(ctx, sr, trigger, args)
+	if err != nil {
+		return nil, err
+	}
+	p := &PreparedFire{trigger: trigger, args: args, source: source, sr: sr}
+	if ok {
+		p.handler = result.Handler
+	} else {
+		p.unmetGuards = result.UnmetGuardConditions
+	}
+	return p, nil
+}
+
+// FirePrepared fires a trigger prepared with PrepareFire, skipping the handler resolution.
+// The guards are not evaluated again, so it is up to the caller to ensure they still hold.
+// Otherwise it behaves like FireCtx with the arguments passed to PrepareFire.
+// It returns ErrStalePreparedFire if the state has changed since the fire was prepared.
+func (sm *StateMachine) FirePrepared(ctx context.Context, p *PreparedFire) error {
+	ctx, unlock := sm.lockSynchronous(ctx)
+	defer unlock()
+	if err := sm.checkActivated(); err != nil {
+		return err
+	}
+	ctx, _ = sm.fireContext(ctx, p.trigger, p.args)
+	return sm.mode.FirePrepared(ctx, p)
+}
+
+func (sm *StateMachine) firePreparedOne(ctx context.Context, p *PreparedFire) error {
+	if err := sm.checkChainLength(ctx); err != nil {
+		return err
+	}
+	sm.noticeDeprecated(ctx, p.trigger)
+	source, err := sm.State(ctx)
+	if err != nil {
+		return err
+	}
+	if source != p.source {
+		return ErrStalePreparedFire
+	}
+	if handled, err := sm.fireRegions(ctx, p.sr, p.trigger, p.args...); handled || err != nil {
+		if err == nil {
+			reportRegionFire(ctx, source, p.trigger)
+		}
+		return err
+	}
+	if p.handler == nil {
+		return sm.fireUnhandled(ctx, p.sr, p.trigger, p.unmetGuards)
+	}
+	return sm.fireHandler(ctx, source, p.sr, p.trigger, p.handler, p.args...)
+}
@@ -0,0 +1,138 @@
+package stateless
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStateMachine_FirePrepared(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		var guardCalls, entries int
+		sm.Configure(stateA).
+			PermitReentry(triggerX, func(_ context.Context, _ ...any) bool {
+				guardCalls++
+				return true
+			}).
+			OnEntry(func(_ context.Context, _ ...any) error {
+				entries++
+				return nil
+			}).
+			Permit(triggerY, stateB)
+
+		p, err := sm.PrepareFire(context.Background(), triggerX)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := sm.FirePrepared(context.Background(), p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if guardCalls != 1 || entries != 3 {
+			t.Errorf("mode %d: guardCalls = %d, entries = %d, want 1 and 3", mode, guardCalls, entries)
+		}
+
+		sm.Fire(triggerY)
+		if err := sm.FirePrepared(context.Background(), p); !errors.Is(err, ErrStalePreparedFire) {
+			t.Errorf("mode %d: FirePrepared() = %v, want ErrStalePreparedFire", mode, err)
+		}
+	}
+}
+
+func TestStateMachine_PrepareFire_Unhandled(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var calls int
+	sm.OnUnhandledTrigger(func(_ context.Context, state State, trigger Trigger, _ []string) error {
+		calls++
+		return &UnhandledTriggerError{State: state, Trigger: trigger}
+	})
+	p, err := sm.PrepareFire(context.Background(), triggerX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Errorf("unhandled trigger action called %d times while preparing, want 0", calls)
+	}
+	if err := sm.FirePrepared(context.Background(), p); !errors.Is(err, ErrUnhandledTrigger) {
+		t.Errorf("FirePrepared() = %v, want ErrUnhandledTrigger", err)
+	}
+	if calls != 1 {
+		t.Errorf("unhandled trigger action called %d times, want 1", calls)
+	}
+}
+
+func TestStateMachine_FirePrepared_FireContext(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetMaxChainLength(1)
+	var root Trigger
+	sm.Configure(stateA).PermitReentry(triggerX)
+	sm.Configure(stateA).OnEntry(func(ctx context.Context, _ ...any) error {
+		root, _ = GetRootTrigger(ctx)
+		return sm.FireCtx(ctx, triggerX)
+	})
+
+	p, err := sm.PrepareFire(context.Background(), triggerX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.FirePrepared(context.Background(), p); !errors.Is(err, ErrChainTooLong) {
+		t.Errorf("FirePrepared() = %v, want ErrChainTooLong", err)
+	}
+	if root != triggerX {
+		t.Errorf("root trigger = %v, want %v", root, triggerX)
+	}
+}
+
+func TestStateMachine_FirePrepared_DropUnhandledQueued(t *testing.T) {
+	sm := NewStateMachineWithMode(stateA, FiringQueued)
+	sm.SetDropUnhandledQueued(true)
+	p, err := sm.PrepareFire(context.Background(), triggerY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prepared error
+	sm.Configure(stateA).InternalTransition(triggerX, func(ctx context.Context, _ ...any) error {
+		prepared = sm.FirePrepared(ctx, p)
+		return nil
+	})
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if prepared != nil {
+		t.Errorf("FirePrepared() = %v, want nil", prepared)
+	}
+}
+
+func newPreparedBenchmarkMachine() *StateMachine {
+	sm := NewStateMachine(stateA)
+	sc := sm.Configure(stateA)
+	for i := 0; i < 10; i++ {
+		i := i
+		sc.PermitReentry(triggerX, func(_ context.Context, args ...any) bool {
+			return args[0].(int) == i
+		})
+	}
+	return sm
+}
+
+func BenchmarkStateMachine_Fire(b *testing.B) {
+	sm := newPreparedBenchmarkMachine()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = sm.Fire(triggerX, 9)
+	}
+}
+
+func BenchmarkStateMachine_FirePrepared(b *testing.B) {
+	sm := newPreparedBenchmarkMachine()
+	p, err := sm.PrepareFire(context.Background(), triggerX, 9)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = sm.FirePrepared(context.Background(), p)
+	}
+}
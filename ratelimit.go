@@ -0,0 +1,110 @@
+package stateless
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateTracker records the times a trigger has been fired in a given state,
+// for the pairs of state and trigger with a configured window.
+type rateTracker struct {
+	mu      sync.Mutex
+	windows map[StateTrigger]time.Duration
+	fires   map[StateTrigger][]time.Time
+}
+
+func (r *rateTracker) track(key StateTrigger, window time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.windows == nil {
+		r.windows = make(map[StateTrigger]time.Duration)
+		r.fires = make(map[StateTrigger][]time.Time)
+	}
+	if w, ok := r.windows[key]; !ok || window > w {
+		r.windows[key] = window
+	}
+}
+
+func (r *rateTracker) record(key StateTrigger, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.windows[key]; !ok {
+		return
+	}
+	r.fires[key] = append(r.prune(key, now), now)
+}
+
+func (r *rateTracker) count(key StateTrigger, window time.Duration, now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int
+	for _, t := range r.prune(key, now) {
+		if now.Sub(t) < window {
+			n++
+		}
+	}
+	return n
+}
+
+// prune removes the fires that are outside the tracked window.
+func (r *rateTracker) prune(key StateTrigger, now time.Time) []time.Time {
+	fires := r.fires[key]
+	i := 0
+	for i < len(fires) && now.Sub(fires[i]) >= r.windows[key] {
+		i++
+	}
+	fires = fires[i:]
+	r.fires[key] = fires
+	return fires
+}
+
+// RateLimit returns a guard that is met while the trigger has been fired less than max times
+// in the configured state during the last window. Fires are tracked from the moment RateLimit is called,
+// using the clock configured with StateMachine.SetClock.
+//
+// The trigger is counted as fired every time it is handled successfully while in the configured state or any of
+// its substates, regardless of the behaviour that handled it. Fires that fail, time out or are aborted by an exit
+// action are not counted.
+func (sc *StateConfiguration) RateLimit(trigger Trigger, max int, window time.Duration) GuardFunc {
+	sm, key := sc.sm, StateTrigger{State: sc.sr.State, Trigger: trigger}
+	sm.rates.track(key, window)
+	return func(_ context.Context, _ ...any) bool {
+		return sm.rates.count(key, window, sm.now()) < max
+	}
+}
+
+// TriggerRate returns the number of times the trigger has been fired in the state
+// during the widest window configured for them with StateConfiguration.RateLimit.
+// It returns 0 if the state and trigger are not tracked.
+func (sm *StateMachine) TriggerRate(state State, trigger Trigger) int {
+	key := StateTrigger{State: state, Trigger: trigger}
+	sm.rates.mu.Lock()
+	window, ok := sm.rates.windows[key]
+	sm.rates.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return sm.rates.count(key, window, sm.now())
+}
+
+// SetClock sets the function used by the state machine to get the current time,
+// which is time.Now by default. It is useful to control time-based behaviours, such as RateLimit, in tests.
+func (sm *StateMachine) SetClock(now func() time.Time) {
+	sm.clock = now
+}
+
+func (sm *StateMachine) now() time.Time {
+	if sm.clock != nil {
+		return sm.clock()
+	}
+	return time.Now()
+}
+
+// recordFire records the trigger as fired in the state and all its superstates.
+func (sm *StateMachine) recordFire(sr *stateRepresentation, trigger Trigger) {
+	now := sm.now()
+	for ; sr != nil; sr = sr.superstate() {
+		sm.rates.record(StateTrigger{State: sr.State, Trigger: trigger}, now)
+	}
+}
@@ -0,0 +1,78 @@
+package stateless
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStateConfiguration_RateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sm := NewStateMachine(stateB)
+	sm.SetClock(func() time.Time { return now })
+	var handled int
+	sc := sm.Configure(stateA)
+	sc.InternalTransition(triggerX, func(_ context.Context, _ ...any) error {
+		handled++
+		return nil
+	}, sc.RateLimit(triggerX, 2, time.Minute))
+	sm.Configure(stateB).SubstateOf(stateA)
+
+	for i := 0; i < 3; i++ {
+		sm.Fire(triggerX)
+		now = now.Add(time.Second)
+	}
+	if handled != 2 {
+		t.Errorf("handled = %d, want 2", handled)
+	}
+	if got := sm.TriggerRate(stateA, triggerX); got != 2 {
+		t.Errorf("TriggerRate() = %d, want 2", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got := sm.TriggerRate(stateA, triggerX); got != 0 {
+		t.Errorf("TriggerRate() = %d, want 0", got)
+	}
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if handled != 3 {
+		t.Errorf("handled = %d, want 3", handled)
+	}
+}
+
+func TestStateMachine_TriggerRate_NotTracked(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).PermitReentry(triggerX)
+	sm.Fire(triggerX)
+	if got := sm.TriggerRate(stateA, triggerX); got != 0 {
+		t.Errorf("TriggerRate() = %d, want 0", got)
+	}
+}
+
+func TestStateConfiguration_RateLimit_FailedFires(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sc := sm.Configure(stateA)
+	fail := true
+	sc.InternalTransition(triggerX, func(_ context.Context, _ ...any) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}, sc.RateLimit(triggerX, 1, time.Minute))
+
+	if err := sm.Fire(triggerX); err == nil {
+		t.Fatal("expected the action error")
+	}
+	if got := sm.TriggerRate(stateA, triggerX); got != 0 {
+		t.Errorf("TriggerRate() = %d, want 0", got)
+	}
+	fail = false
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.TriggerRate(stateA, triggerX); got != 1 {
+		t.Errorf("TriggerRate() = %d, want 1", got)
+	}
+}
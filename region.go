@@ -0,0 +1,235 @@
+package stateless
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Region is an orthogonal region of a composite state, added with StateConfiguration.AddRegion.
+// Each region tracks its own current state, independently of the state machine and of the other
+// regions of the same state, using a state machine in FiringImmediate mode.
+//
+// Entering the composite state enters the initial state of all its regions, after the entry actions
+// of the composite state, and leaving it exits the current state of all its regions, before the exit
+// actions of the composite state. The regions are only left once all the exit actions have succeeded,
+// so if one of them aborts the transition with ErrAbortTransition the regions keep their current state.
+// If the state machine starts in the composite state, or in one of its substates, the regions start
+// in their initial state without executing its entry actions.
+//
+// While the composite state is active, firing a trigger dispatches it to the regions whose current
+// state can handle it, including the ones that ignore it, and the state machine only handles the trigger
+// itself when none of them does. A trigger handled by the regions is reported as handled by FireCtxHandled,
+// without changing the state of the state machine, and the transitions of the regions are not reported
+// by FireCtxResult nor FireDetailed. The regions are not taken into account by CanFire, PermittedTriggers
+// and the graph representations.
+type Region struct {
+	name    string
+	owner   State
+	parent  *StateMachine
+	sm      *StateMachine
+	initial State
+
+	mu         sync.Mutex
+	hasInitial bool
+	entered    bool
+}
+
+func newRegion(name string, owner State, parent *StateMachine) *Region {
+	return &Region{
+		name:   name,
+		owner:  owner,
+		parent: parent,
+		sm:     NewStateMachineWithMode(nil, FiringImmediate),
+	}
+}
+
+// AddRegion adds an orthogonal region named name to the configured state.
+// The states of the region are configured with Region.Configure and its initial state with Region.InitialState.
+// It panics if the state already has a region with the same name.
+func (sc *StateConfiguration) AddRegion(name string) *Region {
+	for _, r := range sc.sr.Regions {
+		if r.name == name {
+			panic(fmt.Sprintf("stateless: The state '%v' already has a region named '%s'.", sc.sr.State, name))
+		}
+	}
+	r := newRegion(name, sc.sr.State, sc.sm)
+	sc.sr.Regions = append(sc.sr.Regions, r)
+	return r
+}
+
+// Name returns the name of the region.
+func (r *Region) Name() string {
+	return r.name
+}
+
+// Configure begin configuration of the entry/exit actions and allowed transitions
+// when the region is in a particular state.
+func (r *Region) Configure(state State) *StateConfiguration {
+	return r.sm.Configure(state)
+}
+
+// InitialState sets the state entered by the region when the composite state is entered.
+func (r *Region) InitialState(state State) *Region {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.initial = state
+	r.hasInitial = true
+	return r
+}
+
+// Machine returns the state machine that tracks the current state of the region,
+// which can be used to inspect it or to register callbacks.
+func (r *Region) Machine() *StateMachine {
+	return r.sm
+}
+
+// State returns the current state of the region.
+// It returns an error if the composite state of the region is not active.
+func (r *Region) State(ctx context.Context) (State, error) {
+	active, err := r.parent.IsInStateCtx(ctx, r.owner)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, fmt.Errorf("stateless: The region '%s' of state '%v' is not active.", r.name, r.owner)
+	}
+	sr, err := r.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sr.State, nil
+}
+
+// current returns the current state of the region, starting at the initial state
+// if the region hasn't been entered yet.
+func (r *Region) current(ctx context.Context) (*stateRepresentation, error) {
+	r.mu.Lock()
+	if !r.entered {
+		initial := r.initialState()
+		if err := r.sm.setState(ctx, initial); err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+		r.entered = true
+	}
+	r.mu.Unlock()
+	return r.sm.currentState(ctx)
+}
+
+// initialState returns the initial state of the region, panicking if it has not been set.
+// The caller must hold r.mu.
+func (r *Region) initialState() State {
+	if !r.hasInitial {
+		panic(fmt.Sprintf("stateless: The region '%s' of state '%v' has no initial state.", r.name, r.owner))
+	}
+	return r.initial
+}
+
+// enter sets the region to its initial state and executes the entry actions.
+func (r *Region) enter(ctx context.Context, transition Transition, args ...any) error {
+	r.mu.Lock()
+	initial := r.initialState()
+	if err := r.sm.setState(ctx, initial, args...); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.entered = true
+	r.mu.Unlock()
+	transition = Transition{Source: transition.Source, Destination: initial, Trigger: transition.Trigger}
+	rep, err := r.sm.enterState(ctx, r.sm.stateRepresentation(initial), transition, args...)
+	if err != nil {
+		return err
+	}
+	if rep.State != initial {
+		return r.sm.setState(ctx, rep.State, args...)
+	}
+	return nil
+}
+
+// exit executes the exit actions of the current state of the region and its superstates.
+func (r *Region) exit(ctx context.Context, transition Transition, args ...any) error {
+	sr, err := r.current(ctx)
+	if err != nil {
+		return err
+	}
+	transition = Transition{Source: sr.State, Destination: transition.Destination, Trigger: transition.Trigger}
+	if err := sr.Exit(ctx, transition, args...); err != nil {
+		return err
+	}
+	if log, ok := ctx.Value(exitLogKey{}).(*exitLog); ok {
+		log.regions = append(log.regions, r)
+	} else {
+		r.leave()
+	}
+	return nil
+}
+
+// leave marks the region as exited, so it starts again at its initial state.
+func (r *Region) leave() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entered = false
+}
+
+// clone copies the region to be owned by a state of parent.
+func (r *Region) clone(parent *StateMachine) *Region {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Region{
+		name:       r.name,
+		owner:      r.owner,
+		parent:     parent,
+		sm:         r.sm.Clone(nil),
+		initial:    r.initial,
+		hasInitial: r.hasInitial,
+	}
+}
+
+// withoutFireResults returns a copy of ctx without the values that collect the outcome of a fire,
+// such as the ones of FireCtxResult, FireCtxHandled and FireDetailed,
+// so the transitions of a region don't overwrite the outcome of the fire of the state machine.
+func withoutFireResults(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, fireResultKey{}, (*Transition)(nil))
+	ctx = context.WithValue(ctx, fireHandledKey{}, (*bool)(nil))
+	return context.WithValue(ctx, fireChainKey{}, nil)
+}
+
+// reportRegionFire sets the outcome of a fire handled by the regions of the source state,
+// which leaves the state machine in the source state.
+func reportRegionFire(ctx context.Context, source State, trigger Trigger) {
+	if result, _ := ctx.Value(fireResultKey{}).(*Transition); result != nil {
+		*result = Transition{Source: source, Destination: source, Trigger: trigger}
+	}
+	if handled, _ := ctx.Value(fireHandledKey{}).(*bool); handled != nil {
+		*handled = true
+	}
+}
+
+// fireRegions fires the trigger in the regions of sr and its superstates whose current state can handle it,
+// reporting whether any of them has handled it.
+func (sm *StateMachine) fireRegions(ctx context.Context, sr *stateRepresentation, trigger Trigger, args ...any) (handled bool, err error) {
+	var regionCtx context.Context
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		for _, r := range rep.Regions {
+			if regionCtx == nil {
+				regionCtx = withoutFireResults(ctx)
+			}
+			if _, err := r.current(regionCtx); err != nil {
+				return handled, err
+			}
+			ok, err := r.sm.CanFireCtx(regionCtx, trigger, args...)
+			if err != nil {
+				return handled, err
+			}
+			if !ok {
+				continue
+			}
+			handled = true
+			if err := r.sm.FireCtx(regionCtx, trigger, args...); err != nil {
+				return handled, err
+			}
+		}
+	}
+	return handled, nil
+}
@@ -0,0 +1,189 @@
+package stateless
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func newDevice(t *testing.T, actions *[]string) (*StateMachine, *Region, *Region) {
+	t.Helper()
+	record := func(action string) ActionFunc {
+		return func(_ context.Context, _ ...any) error {
+			*actions = append(*actions, action)
+			return nil
+		}
+	}
+	sm := NewStateMachine("Off")
+	sm.Configure("Off").
+		Permit("PowerOn", "On")
+	on := sm.Configure("On").
+		OnEntry(record("enter On")).
+		OnExit(record("exit On")).
+		Permit("PowerOff", "Off")
+	audio := on.AddRegion("audio").InitialState("Muted")
+	audio.Configure("Muted").
+		OnEntry(record("enter Muted")).
+		OnExit(record("exit Muted")).
+		Permit("Toggle", "Playing")
+	audio.Configure("Playing").
+		Permit("Toggle", "Muted")
+	video := on.AddRegion("video").InitialState("Blank")
+	video.Configure("Blank").
+		OnExit(record("exit Blank")).
+		Permit("Toggle", "Showing").
+		Permit("Show", "Showing")
+	video.Configure("Showing").
+		OnExit(record("exit Showing"))
+	return sm, audio, video
+}
+
+func regionState(t *testing.T, r *Region) State {
+	t.Helper()
+	state, err := r.State(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestStateMachine_Regions(t *testing.T) {
+	var actions []string
+	sm, audio, video := newDevice(t, &actions)
+
+	if _, err := audio.State(context.Background()); err == nil {
+		t.Error("expected error reading an inactive region")
+	}
+	if err := sm.Fire("PowerOn"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"enter On", "enter Muted"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+
+	// Only the video region handles Show.
+	if err := sm.Fire("Show"); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(t, audio); got != "Muted" {
+		t.Errorf("audio = %v, want Muted", got)
+	}
+	if got := regionState(t, video); got != "Showing" {
+		t.Errorf("video = %v, want Showing", got)
+	}
+
+	// Toggle is only handled by the audio region, as the video one can't handle it in Showing.
+	if err := sm.Fire("Toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(t, audio); got != "Playing" {
+		t.Errorf("audio = %v, want Playing", got)
+	}
+	if got := sm.MustState(); got != "On" {
+		t.Errorf("state = %v, want On", got)
+	}
+
+	actions = nil
+	if err := sm.Fire("PowerOff"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"exit Showing", "exit On"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+
+	// Entering again starts the regions from their initial state.
+	if err := sm.Fire("PowerOn"); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(t, audio); got != "Muted" {
+		t.Errorf("audio = %v, want Muted", got)
+	}
+	if got := regionState(t, video); got != "Blank" {
+		t.Errorf("video = %v, want Blank", got)
+	}
+}
+
+func TestStateMachine_Regions_BothHandle(t *testing.T) {
+	var actions []string
+	sm, audio, video := newDevice(t, &actions)
+	sm.Fire("PowerOn")
+
+	if err := sm.Fire("Toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(t, audio); got != "Playing" {
+		t.Errorf("audio = %v, want Playing", got)
+	}
+	if got := regionState(t, video); got != "Showing" {
+		t.Errorf("video = %v, want Showing", got)
+	}
+}
+
+func TestStateMachine_Regions_InitialState(t *testing.T) {
+	var actions []string
+	sm, audio, _ := newDevice(t, &actions)
+	sm = sm.Clone("On")
+	audio = sm.stateRepresentation("On").Regions[0]
+
+	if got := regionState(t, audio); got != "Muted" {
+		t.Errorf("audio = %v, want Muted", got)
+	}
+	if err := sm.Fire("PowerOff"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"exit Muted", "exit Blank", "exit On"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+}
+
+func TestStateConfiguration_AddRegion_Invalid(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).AddRegion("r")
+	assertPanic(t, func() { sm.Configure(stateA).AddRegion("r") })
+
+	sm.Configure(stateB).AddRegion("r")
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	assertPanic(t, func() { sm.Fire(triggerX) })
+}
+
+func TestStateMachine_Regions_FireResults(t *testing.T) {
+	var actions []string
+	sm, _, video := newDevice(t, &actions)
+	sm.Fire("PowerOn")
+
+	handled, err := sm.FireCtxHandled(context.Background(), "Show")
+	if err != nil || !handled {
+		t.Errorf("FireCtxHandled() = %t, %v, want true, nil", handled, err)
+	}
+	result, err := sm.FireDetailed("Toggle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Transitions) != 0 || result.State != "On" {
+		t.Errorf("FireDetailed() = %+v, want no transitions in state On", result)
+	}
+	tr, err := sm.FireCtxResult(context.Background(), "Toggle")
+	if want := (Transition{Source: "On", Destination: "On", Trigger: "Toggle"}); err != nil || tr != want {
+		t.Errorf("FireCtxResult() = %v, %v, want %v", tr, err, want)
+	}
+	if got := regionState(t, video); got != "Showing" {
+		t.Errorf("video = %v, want Showing", got)
+	}
+}
+
+func TestStateMachine_Regions_FirePrepared(t *testing.T) {
+	var actions []string
+	sm, audio, _ := newDevice(t, &actions)
+	sm.Fire("PowerOn")
+
+	p, err := sm.PrepareFire(context.Background(), "Toggle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.FirePrepared(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if got := regionState(t, audio); got != "Playing" {
+		t.Errorf("audio = %v, want Playing", got)
+	}
+}
@@ -0,0 +1,199 @@
+package stateless
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scxmlGraph renders a state machine as a W3C SCXML document.
+type scxmlGraph struct{}
+
+func (g *scxmlGraph) formatStateMachine(sm *StateMachine) string {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0"`)
+	initialState, initialErr := sm.State(context.Background())
+	if initialErr == nil {
+		sb.WriteString(fmt.Sprintf(` initial="%s"`, xmlEsc(fmt.Sprint(initialState))))
+	}
+	sb.WriteString(">\n")
+
+	stateList := make([]*stateRepresentation, 0, len(sm.stateConfig))
+	for _, st := range sm.stateConfig {
+		stateList = append(stateList, st)
+	}
+	sort.Slice(stateList, func(i, j int) bool {
+		return fmt.Sprint(stateList[i].State) < fmt.Sprint(stateList[j].State)
+	})
+
+	// States that are only referenced, e.g. as a destination, must be declared too.
+	referenced := make(map[string]struct{})
+	reference := func(state State) {
+		if _, ok := sm.stateConfig[state]; !ok {
+			referenced[fmt.Sprint(state)] = struct{}{}
+		}
+	}
+	if initialErr == nil {
+		reference(initialState)
+	}
+	for _, sr := range stateList {
+		if sr.superstate() == nil {
+			g.formatOneState(&sb, sm, sr, 1)
+		}
+		if sr.HasInitialState {
+			reference(sr.InitialTransitionTarget)
+		}
+		for _, tb := range sortedBehaviours(sr) {
+			dests, _ := behaviourDestinations(tb)
+			for _, dest := range dests {
+				reference(dest)
+			}
+		}
+	}
+	names := make([]string, 0, len(referenced))
+	for name := range referenced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("\t<state id=\"%s\"/>\n", xmlEsc(name)))
+	}
+	sb.WriteString("</scxml>\n")
+	return sb.String()
+}
+
+func (g *scxmlGraph) formatOneState(sb *strings.Builder, sm *StateMachine, sr *stateRepresentation, level int) {
+	indent := strings.Repeat("\t", level)
+	id := xmlEsc(fmt.Sprint(sr.State))
+	if !sr.HasInitialState && len(sr.substates()) == 0 && len(sr.TriggerBehaviours) == 0 &&
+		len(g.entryActions(sr)) == 0 && len(sr.ExitActions) == 0 {
+		sb.WriteString(fmt.Sprintf("%s<state id=\"%s\"/>\n", indent, id))
+		return
+	}
+	sb.WriteString(fmt.Sprintf("%s<state id=\"%s\">\n", indent, id))
+	if sr.HasInitialState {
+		sb.WriteString(fmt.Sprintf("%s\t<initial>\n%s\t\t<transition target=\"%s\"/>\n%s\t</initial>\n",
+			indent, indent, xmlEsc(fmt.Sprint(sr.InitialTransitionTarget)), indent))
+	}
+	g.formatActions(sb, "onentry", g.entryActions(sr), indent+"\t")
+	g.formatActions(sb, "onexit", g.exitActions(sr), indent+"\t")
+	g.formatAllStateTransitions(sb, sm, sr, indent+"\t")
+	substates := append([]*stateRepresentation(nil), sr.substates()...)
+	sort.Slice(substates, func(i, j int) bool {
+		return fmt.Sprint(substates[i].State) < fmt.Sprint(substates[j].State)
+	})
+	for _, substate := range substates {
+		g.formatOneState(sb, sm, substate, level+1)
+	}
+	sb.WriteString(indent + "</state>\n")
+}
+
+func (g *scxmlGraph) entryActions(sr *stateRepresentation) []string {
+	var es []string
+	for _, act := range sr.EntryActions {
+		if act.Trigger != nil {
+			// Rendered in the transitions that fire the trigger.
+			continue
+		}
+		switch {
+		case act.InitialOnly:
+			es = append(es, "initial entry / "+act.Description.String())
+		case act.Reentry == reentryOnly:
+			es = append(es, "reentry / "+act.Description.String())
+		default:
+			es = append(es, act.Description.String())
+		}
+	}
+	return es
+}
+
+func (g *scxmlGraph) exitActions(sr *stateRepresentation) []string {
+	es := make([]string, 0, len(sr.ExitActions))
+	for _, act := range sr.ExitActions {
+		es = append(es, act.Description.String())
+	}
+	return es
+}
+
+// formatActions writes the actions as log placeholders inside an element named tag.
+func (g *scxmlGraph) formatActions(sb *strings.Builder, tag string, actions []string, indent string) {
+	if len(actions) == 0 {
+		return
+	}
+	sb.WriteString(fmt.Sprintf("%s<%s>\n", indent, tag))
+	for _, act := range actions {
+		sb.WriteString(fmt.Sprintf("%s\t<log label=\"%s\"/>\n", indent, xmlEsc(act)))
+	}
+	sb.WriteString(fmt.Sprintf("%s</%s>\n", indent, tag))
+}
+
+func (g *scxmlGraph) formatAllStateTransitions(sb *strings.Builder, sm *StateMachine, sr *stateRepresentation, indent string) {
+	for _, trigger := range sortedBehaviours(sr) {
+		attrs := fmt.Sprintf(` event="%s"`, xmlEsc(fmt.Sprint(trigger.GetTrigger())))
+		var actions []string
+		switch t := trigger.(type) {
+		case *ignoredTriggerBehaviour:
+		case *internalTriggerBehaviour, *internalDynamicTriggerBehaviour:
+			attrs += ` type="internal"`
+		case *reentryTriggerBehaviour:
+			attrs += fmt.Sprintf(` target="%s"`, xmlEsc(fmt.Sprint(t.Destination)))
+			actions = g.triggerEntryActions(sr, t.GetTrigger())
+		case *transitioningTriggerBehaviour:
+			attrs += fmt.Sprintf(` target="%s"`, xmlEsc(fmt.Sprint(t.Destination)))
+			actions = t.actions(sm.stateConfig[t.Destination])
+		case *dynamicTriggerBehaviour:
+			comment := "dynamic destination: " + t.Description.String()
+			if len(t.PossibleDestinations) > 0 {
+				comment += fmt.Sprintf(", possible destinations: %v", t.PossibleDestinations)
+			}
+			sb.WriteString(fmt.Sprintf("%s<!-- %s -->\n", indent, xmlCommentEsc(comment)))
+		default:
+			continue
+		}
+		if guard := trigger.base().Guard; len(guard.Guards) > 0 {
+			conds := make([]string, len(guard.Guards))
+			for i, info := range guard.Guards {
+				conds[i] = info.Description.String()
+			}
+			op := " && "
+			if guard.Any {
+				op = " || "
+			}
+			attrs += fmt.Sprintf(` cond="%s"`, xmlEsc(strings.Join(conds, op)))
+		}
+		if len(actions) == 0 {
+			sb.WriteString(fmt.Sprintf("%s<transition%s/>\n", indent, attrs))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%s<transition%s>\n", indent, attrs))
+		for _, act := range actions {
+			sb.WriteString(fmt.Sprintf("%s\t<log label=\"%s\"/>\n", indent, xmlEsc(act)))
+		}
+		sb.WriteString(indent + "</transition>\n")
+	}
+}
+
+// triggerEntryActions returns the descriptions of the entry actions of sr configured for trigger with OnEntryFrom.
+func (g *scxmlGraph) triggerEntryActions(sr *stateRepresentation, trigger Trigger) []string {
+	var actions []string
+	for _, ea := range sr.EntryActions {
+		if ea.Trigger != nil && *ea.Trigger == trigger {
+			actions = append(actions, ea.Description.String())
+		}
+	}
+	return actions
+}
+
+func xmlEsc(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+// xmlCommentEsc makes s safe to be used inside an XML comment, which can't contain "--".
+func xmlCommentEsc(s string) string {
+	return strings.ReplaceAll(s, "--", "- -")
+}
@@ -2,9 +2,14 @@ package stateless
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // State is used to to represent the possible machine states.
@@ -30,7 +35,8 @@ type Transition struct {
 	Destination State
 	Trigger     Trigger
 
-	isInitial bool
+	isInitial  bool
+	isInternal bool
 }
 
 // IsReentry returns true if the transition is a re-entry,
@@ -39,17 +45,294 @@ func (t *Transition) IsReentry() bool {
 	return t.Source == t.Destination
 }
 
+// IsInitial returns true if the transition is the result of an initial transition,
+// i.e. it enters a substate automatically after entering its superstate.
+func (t *Transition) IsInitial() bool {
+	return t.isInitial
+}
+
+// IsInternal returns true if the transition is an internal transition,
+// i.e. it executes an action without exiting nor entering any state.
+func (t *Transition) IsInternal() bool {
+	return t.isInternal
+}
+
+// Equal returns true if both transitions have the same source, destination and trigger.
+// Internal information, such as whether the transition is initial, is not compared.
+func (t *Transition) Equal(other Transition) bool {
+	return t.Source == other.Source && t.Destination == other.Destination && t.Trigger == other.Trigger
+}
+
 type TransitionFunc = func(context.Context, Transition)
 
+// GuardResolution defines how a trigger is resolved when the guards of several of its behaviours
+// configured in the same state are met at the same time.
+type GuardResolution uint8
+
+const (
+	// ExclusiveMatch requires the guards to be mutually exclusive, panicking when more than one is met.
+	ExclusiveMatch GuardResolution = iota
+	// FirstMatch selects the behaviour configured first among the ones whose guards are met.
+	FirstMatch
+)
+
+// TransitionFailedFunc defines a function that will be called when a transition fails.
+type TransitionFailedFunc = func(context.Context, Transition, error)
+
 // UnhandledTriggerActionFunc defines a function that will be called when a trigger is not handled.
 type UnhandledTriggerActionFunc = func(ctx context.Context, state State, trigger Trigger, unmetGuards []string) error
 
+// DeprecatedTriggerFunc defines a function that will be called the first time a deprecated trigger is fired.
+type DeprecatedTriggerFunc = func(ctx context.Context, trigger Trigger, message string)
+
+// GuardPanicFunc defines a function that will be called when a guard function panics.
+type GuardPanicFunc = func(ctx context.Context, trigger Trigger, recovered any)
+
 // DefaultUnhandledTriggerAction is the default unhandled trigger action.
+// It returns an *UnhandledTriggerError, which matches ErrGuardNotMet if there are unmet guards
+// and ErrUnhandledTrigger otherwise.
 func DefaultUnhandledTriggerAction(_ context.Context, state State, trigger Trigger, unmetGuards []string) error {
-	if len(unmetGuards) != 0 {
-		return fmt.Errorf("stateless: Trigger '%v' is valid for transition from state '%v' but a guard conditions are not met. Guard descriptions: '%v", trigger, state, unmetGuards)
+	return &UnhandledTriggerError{State: state, Trigger: trigger, UnmetGuards: unmetGuards}
+}
+
+// ErrUnhandledTrigger is matched by the errors returned when firing a trigger
+// that is not configured in the current state nor in its superstates.
+var ErrUnhandledTrigger = errors.New("stateless: unhandled trigger")
+
+// ErrGuardNotMet is matched by the errors returned when firing a trigger
+// whose transitions are all gated by guard conditions that are not met.
+var ErrGuardNotMet = errors.New("stateless: guard conditions not met")
+
+// UnhandledTriggerError is returned by DefaultUnhandledTriggerAction when a trigger can't be handled in the current state.
+// It matches ErrGuardNotMet if the trigger is configured but its guards are not met, and ErrUnhandledTrigger otherwise.
+type UnhandledTriggerError struct {
+	State   State
+	Trigger Trigger
+	// UnmetGuards contains the descriptions of the guard conditions that are not met, if any.
+	UnmetGuards []string
+}
+
+func (e *UnhandledTriggerError) Error() string {
+	if len(e.UnmetGuards) != 0 {
+		return fmt.Sprintf("stateless: Trigger '%v' is valid for transition from state '%v' but a guard conditions are not met. Guard descriptions: '%v", e.Trigger, e.State, e.UnmetGuards)
+	}
+	return fmt.Sprintf("stateless: No valid leaving transitions are permitted from state '%v' for trigger '%v', consider ignoring the trigger", e.State, e.Trigger)
+}
+
+// Is reports whether target is ErrGuardNotMet or ErrUnhandledTrigger, depending on the unmet guards.
+func (e *UnhandledTriggerError) Is(target error) bool {
+	if len(e.UnmetGuards) != 0 {
+		return target == ErrGuardNotMet
+	}
+	return target == ErrUnhandledTrigger
+}
+
+// ActionError wraps the error returned by an action executed while handling a trigger,
+// such as an entry, exit, internal or transition action, so it can be told apart from other errors
+// while the original error is still available using errors.Is and errors.As.
+type ActionError struct {
+	// Transition is the transition being executed when the action failed.
+	Transition Transition
+	Err        error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("stateless: Action failed handling trigger '%v' from state '%v' to state '%v': %v", e.Transition.Trigger, e.Transition.Source, e.Transition.Destination, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// actionErr wraps err, returned by an action executed during transition, in an *ActionError.
+// Errors that already are an *ActionError, e.g. returned by a nested Fire, are not wrapped again.
+func actionErr(transition Transition, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ActionError); ok {
+		return err
+	}
+	return &ActionError{Transition: transition, Err: err}
+}
+
+type completionTrigger struct{}
+
+func (completionTrigger) String() string {
+	return "completion"
+}
+
+// CompletionTrigger is the trigger fired automatically by the state machine
+// after entering a state configured with PermitCompletion.
+var CompletionTrigger Trigger = completionTrigger{}
+
+// ErrChainTooLong is returned when firing a trigger produces more automatic transitions
+// than the limit configured with SetMaxChainLength.
+var ErrChainTooLong = errors.New("stateless: transition chain too long")
+
+// ErrReentrantFire is returned when a trigger is fired in FiringImmediate mode while another one
+// is being processed and reentrant fires have been disallowed with SetAllowReentrantFire.
+var ErrReentrantFire = errors.New("stateless: reentrant fire")
+
+// ErrNotActivated is returned when a trigger is fired while the state machine is not activated
+// and activation has been required with SetRequireActivation.
+var ErrNotActivated = errors.New("stateless: the state machine is not activated")
+
+// ErrUndeclaredDestination is returned when the selector of a dynamic transition configured
+// with PermitDynamicDest returns a state that has not been declared as a possible destination.
+var ErrUndeclaredDestination = errors.New("stateless: undeclared dynamic destination")
+
+// ErrQueueFull is returned when a trigger is fired in FiringQueued mode while the queue
+// already holds the number of triggers configured with SetMaxQueueDepth.
+var ErrQueueFull = errors.New("stateless: trigger queue is full")
+
+// ErrAbortTransition can be returned, optionally wrapped, by an exit action to cancel the transition
+// before the state changes. Fire then returns nil, the trigger is not considered handled and the state,
+// the timers of PermitAfter and the regions are left unchanged.
+// The exit actions that have already been executed, such as the ones of the substates, are not undone.
+var ErrAbortTransition = errors.New("stateless: transition aborted")
+
+// FireResult describes the outcome of firing a trigger.
+type FireResult struct {
+	// Transitions contains, in order, all the transitions performed as a result of firing the trigger,
+	// including the ones chained automatically by completion transitions or by triggers fired from actions.
+	Transitions []Transition
+	// State is the state of the machine once all the chained transitions have been performed.
+	State State
+}
+
+// Chained returns true if firing the trigger resulted in more than one transition.
+func (r *FireResult) Chained() bool {
+	return len(r.Transitions) > 1
+}
+
+// StateChangedEvent describes a change of the current state of the state machine.
+type StateChangedEvent struct {
+	// From is the state before firing the trigger.
+	From State
+	// To is the state after firing the trigger, which is always different from From.
+	To State
+	// Trigger is the trigger that caused the change.
+	Trigger Trigger
+	// At is the time when the new state was entered.
+	At time.Time
+	// Args are the arguments the trigger was fired with.
+	Args []any
+}
+
+// StateChangedFunc defines a function that will be called when the current state changes.
+type StateChangedFunc = func(context.Context, StateChangedEvent)
+
+type fireChainKey struct{}
+
+// fireChain collects the transitions performed while firing a trigger.
+type fireChain struct {
+	mu          sync.Mutex
+	transitions []Transition
+}
+
+func (c *fireChain) add(transition Transition) {
+	c.mu.Lock()
+	c.transitions = append(c.transitions, transition)
+	c.mu.Unlock()
+}
+
+func (c *fireChain) Transitions() []Transition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Transition(nil), c.transitions...)
+}
+
+func (c *fireChain) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.transitions)
+}
+
+// path returns the sequence of states visited by the chain.
+func (c *fireChain) path() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sb strings.Builder
+	for i, t := range c.transitions {
+		if i == 0 {
+			fmt.Fprint(&sb, t.Source)
+		}
+		fmt.Fprintf(&sb, " -> %v", t.Destination)
+	}
+	return sb.String()
+}
+
+type fireResultKey struct{}
+
+type fireHandledKey struct{}
+
+type transitionTimeoutKey struct{}
+
+type fireTimeoutKey struct{}
+
+type droppableTriggerKey struct{}
+
+type synchronousKey struct{}
+
+type fireArgsKey struct{}
+
+// transitionTimeoutErr returns the context error if ctx has been
+// created to enforce the transition timeout or the deadline of FireTimeout.
+func transitionTimeoutErr(ctx context.Context) error {
+	if ctx.Value(transitionTimeoutKey{}) == nil && ctx.Value(fireTimeoutKey{}) == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// activationSet tracks the states activated by a state machine.
+type activationSet struct {
+	mu     sync.Mutex
+	states map[State]struct{}
+	// machine is true between a successful Activate and the next Deactivate.
+	machine bool
+}
+
+func (a *activationSet) setMachine(active bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.machine = active
+}
+
+func (a *activationSet) machineActive() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.machine
+}
+
+func (a *activationSet) contains(state State) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.states[state]
+	return ok
+}
+
+func (a *activationSet) add(state State) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.states == nil {
+		a.states = make(map[State]struct{})
 	}
-	return fmt.Errorf("stateless: No valid leaving transitions are permitted from state '%v' for trigger '%v', consider ignoring the trigger", state, trigger)
+	a.states[state] = struct{}{}
+}
+
+func (a *activationSet) clear() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.states = nil
+	a.machine = false
+}
+
+func (a *activationSet) remove(state State) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.states, state)
 }
 
 func callEvents(events []TransitionFunc, ctx context.Context, transition Transition) {
@@ -60,7 +343,7 @@ func callEvents(events []TransitionFunc, ctx context.Context, transition Transit
 
 // A StateMachine is an abstract machine that can be in exactly one of a finite number of states at any given time.
 // It is safe to use the StateMachine concurrently, but non of the callbacks (state manipulation, actions, events, ...) are guarded,
-// so it is up to the client to protect them against race conditions.
+// so it is up to the client to protect them against race conditions, or to serialize the Fire calls using SetSynchronous.
 type StateMachine struct {
 	stateConfig            map[State]*stateRepresentation
 	triggerConfig          map[Trigger]triggerWithParameters
@@ -69,7 +352,36 @@ type StateMachine struct {
 	unhandledTriggerAction UnhandledTriggerActionFunc
 	onTransitioningEvents  []TransitionFunc
 	onTransitionedEvents   []TransitionFunc
+	onTransitionFailed     []TransitionFailedFunc
+	onStateChangedEvents   []StateChangedFunc
+	defaults               defaultActions
+	onDeprecatedTrigger    DeprecatedTriggerFunc
+	onGuardPanic           GuardPanicFunc
+	deprecatedTriggers     map[Trigger]*deprecatedTrigger
+	triggerDefaults        map[Trigger]any
+	broadcastTriggers      map[Trigger]struct{}
+	maxChainLength         int
+	maxQueueDepth          int
+	autoDeactivate         bool
+	dropUnhandledQueued    bool
+	argRedactor            func([]any) any
+	reportInternal         bool
+	argCloner              func([]any) []any
+	transitionTimeout      time.Duration
+	synchronous            bool
+	timed                  bool // some state has timed triggers
+	rejectReentrant        bool
+	fireArgsInContext      bool
+	requireActivation      bool
+	guardResolution        GuardResolution
+	syncMutex              sync.Mutex // serializes Fire calls, see serialized
+	activated              activationSet
+	rates                  rateTracker
+	subscriptions          subscriptions
+	clock                  func() time.Time
 	stateMutex             sync.RWMutex
+	triggerConfigMu        sync.RWMutex // guards triggerConfig, triggerDefaults and the deprecated triggers
+	hierarchyMu            sync.Mutex   // serializes the changes of the superstate/substate relationships
 	mode                   fireMode
 }
 
@@ -77,6 +389,9 @@ func newStateMachine(firingMode FiringMode) *StateMachine {
 	sm := &StateMachine{
 		stateConfig:            make(map[State]*stateRepresentation),
 		triggerConfig:          make(map[Trigger]triggerWithParameters),
+		deprecatedTriggers:     make(map[Trigger]*deprecatedTrigger),
+		triggerDefaults:        make(map[Trigger]any),
+		broadcastTriggers:      make(map[Trigger]struct{}),
 		unhandledTriggerAction: UnhandledTriggerActionFunc(DefaultUnhandledTriggerAction),
 	}
 	if firingMode == FiringImmediate {
@@ -136,12 +451,62 @@ func NewStateMachineWithExternalStorageAndArgs(stateAccessor func(context.Contex
 	return sm
 }
 
+// NewStateMachineWithExternalStorageArgs returns a state machine with external state storage whose state accessor
+// and state mutator receive the arguments of the trigger being fired, e.g. to route the state to the right shard.
+// The state accessor receives no arguments when it is called outside of Fire, such as from State or Activate
+// with a context that doesn't come from an action.
+func NewStateMachineWithExternalStorageArgs(stateAccessor func(context.Context, ...any) (State, error), stateMutator func(context.Context, State, ...any) error, firingMode FiringMode) *StateMachine {
+	sm := newStateMachine(firingMode)
+	sm.fireArgsInContext = true
+	sm.stateAccessor = func(ctx context.Context) (State, []any, error) {
+		args, _ := ctx.Value(fireArgsKey{}).([]any)
+		state, err := stateAccessor(ctx, args...)
+		return state, nil, err
+	}
+	sm.stateMutator = stateMutator
+	return sm
+}
+
 // ToGraph returns the DOT representation of the state machine.
-// It is not guaranteed that the returned string will be the same in different executions.
+// The output is deterministic: states and triggers are sorted by their string representation,
+// so the same configuration always produces the same string.
 func (sm *StateMachine) ToGraph() string {
 	return new(graph).formatStateMachine(sm)
 }
 
+// ToGraphWithOptions returns the DOT representation of the state machine using the given options.
+// As with ToGraph, the output is deterministic.
+func (sm *StateMachine) ToGraphWithOptions(opts GraphOptions) string {
+	return (&graph{opts: opts}).formatStateMachine(sm)
+}
+
+// ToGraphCurrent returns the DOT representation of the state machine like ToGraph,
+// filling the node of the current state and the ones of its superstates, as GraphOptions.HighlightActive does.
+// The current state is read using the state accessor, so an error is returned if it fails.
+func (sm *StateMachine) ToGraphCurrent(ctx context.Context) (string, error) {
+	if _, err := sm.State(ctx); err != nil {
+		return "", err
+	}
+	return sm.ToGraphWithOptions(GraphOptions{HighlightActive: true}), nil
+}
+
+// ToMermaid returns the Mermaid stateDiagram-v2 representation of the state machine,
+// which can be embedded in Markdown documents.
+// Substates are rendered as composite states, and the guards and actions are described
+// the same way as in ToGraph.
+func (sm *StateMachine) ToMermaid() string {
+	return new(mermaidGraph).formatStateMachine(sm)
+}
+
+// ToSCXML returns the W3C SCXML representation of the state machine, which can be imported
+// by other statechart tools. Substates are nested inside their superstates, guards are
+// described in the cond attribute of the transitions and actions are rendered as log placeholders.
+// Dynamic transitions are rendered as transitions without target.
+// The document only describes the structure of the state machine, it can't be executed.
+func (sm *StateMachine) ToSCXML() string {
+	return new(scxmlGraph).formatStateMachine(sm)
+}
+
 // State returns the current state.
 func (sm *StateMachine) State(ctx context.Context) (State, error) {
 	state, _, err := sm.stateAccessor(ctx)
@@ -171,7 +536,141 @@ func (sm *StateMachine) PermittedTriggersCtx(ctx context.Context, args ...any) (
 	if err != nil {
 		return nil, err
 	}
-	return sr.PermittedTriggers(ctx, args...), nil
+	return append(sr.PermittedTriggers(ctx, args...), sr.permittedDefaultTriggers(ctx, args...)...), nil
+}
+
+// HasAnyPermittedTrigger see HasAnyPermittedTriggerCtx.
+func (sm *StateMachine) HasAnyPermittedTrigger(args ...any) (bool, error) {
+	return sm.HasAnyPermittedTriggerCtx(context.Background(), args...)
+}
+
+// HasAnyPermittedTriggerCtx returns true if there is at least one currently-permissible trigger.
+// It is equivalent to checking the length of PermittedTriggersCtx, but it stops evaluating guards
+// as soon as a permitted trigger is found.
+func (sm *StateMachine) HasAnyPermittedTriggerCtx(ctx context.Context, args ...any) (bool, error) {
+	sr, err := sm.currentState(ctx)
+	if err != nil {
+		return false, err
+	}
+	return sr.HasAnyPermittedTrigger(ctx, args...), nil
+}
+
+// PermittedTransitions see PermittedTransitionsCtx.
+func (sm *StateMachine) PermittedTransitions(args ...any) ([]TransitionInfo, error) {
+	return sm.PermittedTransitionsCtx(context.Background(), args...)
+}
+
+// PermittedTransitionsCtx returns the currently-permissible triggers together with the transition
+// each of them would take, including its destination when it is static and its kind.
+// Dynamic transitions are reported with KindDynamic and a nil destination,
+// together with their possible destinations if they have been declared with PermitDynamicDest.
+// The guards are evaluated with the given arguments, and the triggers configured in the superstates
+// are only reported when the current state doesn't handle them, exactly like PermittedTriggersCtx.
+// The returned transitions are sorted by the string representation of their trigger.
+func (sm *StateMachine) PermittedTransitionsCtx(ctx context.Context, args ...any) ([]TransitionInfo, error) {
+	sr, err := sm.currentState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sr.PermittedTransitions(ctx, args...), nil
+}
+
+// PermittedTriggersInfo see PermittedTriggersInfoCtx.
+//
+// Deprecated: Use PermittedTransitions instead.
+func (sm *StateMachine) PermittedTriggersInfo(args ...any) ([]TransitionInfo, error) {
+	return sm.PermittedTransitionsCtx(context.Background(), args...)
+}
+
+// PermittedTriggersInfoCtx returns the currently-permissible triggers together with the transition
+// each of them would take.
+//
+// Deprecated: Use PermittedTransitionsCtx instead.
+func (sm *StateMachine) PermittedTriggersInfoCtx(ctx context.Context, args ...any) ([]TransitionInfo, error) {
+	return sm.PermittedTransitionsCtx(ctx, args...)
+}
+
+// Hierarchy returns the state hierarchy as a forest, where each root is a state without superstate
+// and the children of each node are its substates.
+// The roots are sorted by the string representation of their state.
+func (sm *StateMachine) Hierarchy() []*StateNode {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	var roots []*StateNode
+	for _, sr := range sm.stateConfig {
+		if sr.superstate() == nil {
+			roots = append(roots, newStateNode(sr))
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return fmt.Sprint(roots[i].State) < fmt.Sprint(roots[j].State)
+	})
+	return roots
+}
+
+// CommonAncestor returns the nearest state that includes both a and b, i.e. their lowest common ancestor
+// in the state hierarchy. A state is considered to include itself, so if a is a superstate of b the result is a.
+// It returns false if the states don't share any ancestor or if any of them is not configured.
+func (sm *StateMachine) CommonAncestor(a, b State) (State, bool) {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	srA, okA := sm.stateConfig[a]
+	srB, okB := sm.stateConfig[b]
+	if !okA || !okB {
+		return nil, false
+	}
+	for rep := srA; rep != nil; rep = rep.superstate() {
+		if srB.IsIncludedInState(rep.State) {
+			return rep.State, true
+		}
+	}
+	return nil, false
+}
+
+// GuardsFor returns the transitions configured for the trigger in the given state, together with
+// the descriptions of the guards that gate each of them. The transitions configured in the superstates
+// are also returned, after the ones of the state itself. Guards are not evaluated.
+func (sm *StateMachine) GuardsFor(state State, trigger Trigger) []TransitionInfo {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	var infos []TransitionInfo
+	for sr := sm.stateConfig[state]; sr != nil; sr = sr.superstateFor(trigger) {
+		for _, behaviour := range sr.behaviours(trigger) {
+			infos = append(infos, newTransitionInfo(sr, behaviour))
+		}
+	}
+	return infos
+}
+
+// AllGuardDescriptions returns the distinct descriptions of the guards configured for each trigger
+// across all the states, sorted alphabetically. Triggers without guards are not included.
+// Guards are not evaluated.
+func (sm *StateMachine) AllGuardDescriptions() map[Trigger][]string {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	seen := make(map[Trigger]map[string]struct{})
+	for _, sr := range sm.stateConfig {
+		for trigger, behaviours := range sr.TriggerBehaviours {
+			for _, behaviour := range behaviours {
+				for _, guard := range behaviour.base().Guard.Guards {
+					if seen[trigger] == nil {
+						seen[trigger] = make(map[string]struct{})
+					}
+					seen[trigger][guard.Description.String()] = struct{}{}
+				}
+			}
+		}
+	}
+	descriptions := make(map[Trigger][]string, len(seen))
+	for trigger, set := range seen {
+		list := make([]string, 0, len(set))
+		for desc := range set {
+			list = append(list, desc)
+		}
+		sort.Strings(list)
+		descriptions[trigger] = list
+	}
+	return descriptions
 }
 
 // Activate see ActivateCtx.
@@ -182,12 +681,25 @@ func (sm *StateMachine) Activate() error {
 // ActivateCtx activates current state. Actions associated with activating the current state will be invoked.
 // The activation is idempotent and subsequent activation of the same current state
 // will not lead to re-execution of activation callbacks.
+// The activated states are tracked per state machine, so machines sharing the same configuration
+// don't affect each other.
+//
+// As the initial state is not entered through a transition, activating the state machine also arms
+// the timers configured with OnEntryFireAfter and PermitAfter for the current state and its superstates,
+// unless they are already armed.
 func (sm *StateMachine) ActivateCtx(ctx context.Context) error {
 	sr, err := sm.currentState(ctx)
 	if err != nil {
 		return err
 	}
-	return sr.Activate(ctx)
+	if err := sr.Activate(ctx, &sm.activated); err != nil {
+		return err
+	}
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		rep.armIdleTimers()
+	}
+	sm.activated.setMachine(true)
+	return nil
 }
 
 // Deactivate see DeactivateCtx.
@@ -203,7 +715,40 @@ func (sm *StateMachine) DeactivateCtx(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	return sr.Deactivate(ctx)
+	if err := sr.Deactivate(ctx, &sm.activated); err != nil {
+		return err
+	}
+	sm.activated.setMachine(false)
+	return nil
+}
+
+// IsActivated returns true if the state machine has been activated with Activate
+// and it has not been deactivated with Deactivate or reset with Reset since then.
+func (sm *StateMachine) IsActivated() bool {
+	return sm.activated.machineActive()
+}
+
+// SetGuardResolution configures how a trigger is resolved when the guards of several behaviours
+// configured for it in the same state are met at the same time. It applies to all kind of behaviours,
+// including the dynamic ones configured with PermitDynamic. Behaviours with a higher priority,
+// configured with PermitWithPriority, are always selected first. The default is ExclusiveMatch.
+func (sm *StateMachine) SetGuardResolution(resolution GuardResolution) {
+	sm.guardResolution = resolution
+}
+
+// SetRequireActivation configures whether triggers can only be fired while the state machine is activated.
+// If enabled, firing a trigger before calling Activate, or after calling Deactivate, returns ErrNotActivated
+// without doing anything. By default triggers can be fired regardless of the activation.
+func (sm *StateMachine) SetRequireActivation(require bool) {
+	sm.requireActivation = require
+}
+
+// checkActivated returns ErrNotActivated if activation is required and the state machine is not activated.
+func (sm *StateMachine) checkActivated() error {
+	if sm.requireActivation && !sm.IsActivated() {
+		return ErrNotActivated
+	}
+	return nil
 }
 
 // IsInState see IsInStateCtx.
@@ -227,24 +772,325 @@ func (sm *StateMachine) CanFire(trigger Trigger, args ...any) (bool, error) {
 }
 
 // CanFireCtx returns true if the trigger can be fired in the current state.
+// The error returned by a failing guard configured with PermitE, IgnoreE or InternalTransitionE is returned.
 func (sm *StateMachine) CanFireCtx(ctx context.Context, trigger Trigger, args ...any) (bool, error) {
 	sr, err := sm.currentState(ctx)
 	if err != nil {
 		return false, err
 	}
-	return sr.CanHandle(ctx, trigger, args...), nil
+	ctx, guardErr := withGuardError(ctx)
+	ok := sr.CanHandle(ctx, trigger, args...)
+	if guardErr.err != nil {
+		return false, guardErr.err
+	}
+	return ok, nil
+}
+
+// UnmetGuards see UnmetGuardsCtx.
+func (sm *StateMachine) UnmetGuards(trigger Trigger, args ...any) ([]string, error) {
+	return sm.UnmetGuardsCtx(context.Background(), trigger, args...)
+}
+
+// UnmetGuardsCtx returns the descriptions of the guards that prevent the trigger from being fired in the current state,
+// which are the ones passed to the unhandled trigger action when firing it. The guards are evaluated like in CanFireCtx,
+// without executing any action.
+// It returns nil if the trigger can be fired, and also if it is not configured at all, which can be told apart using CanFireCtx.
+func (sm *StateMachine) UnmetGuardsCtx(ctx context.Context, trigger Trigger, args ...any) ([]string, error) {
+	sr, err := sm.currentState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, guardErr := withGuardError(ctx)
+	result, ok := sr.FindHandler(ctx, trigger, args...)
+	if guardErr.err != nil {
+		return nil, guardErr.err
+	}
+	if ok {
+		return nil, nil
+	}
+	return result.UnmetGuardConditions, nil
+}
+
+// FilterFireable returns the candidates for which the trigger can be fired in the current state,
+// supplying each candidate as the only argument of the trigger.
+// It is equivalent to calling CanFireCtx once per candidate, but the current state
+// and the states that handle the trigger are resolved only once, so only the guards are evaluated per candidate.
+func (sm *StateMachine) FilterFireable(ctx context.Context, trigger Trigger, candidates []any) ([]any, error) {
+	sr, err := sm.currentState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var handlers []*stateRepresentation
+	for rep := sr; rep != nil; rep = rep.superstateFor(trigger) {
+		if len(rep.behaviours(trigger)) != 0 {
+			handlers = append(handlers, rep)
+		}
+	}
+	var accepted []any
+	for _, arg := range candidates {
+		for _, rep := range handlers {
+			if _, ok := rep.findHandler(ctx, trigger, arg); ok {
+				accepted = append(accepted, arg)
+				break
+			}
+		}
+	}
+	return accepted, nil
 }
 
 // SetTriggerParameters specify the arguments that must be supplied when a specific trigger is fired.
 func (sm *StateMachine) SetTriggerParameters(trigger Trigger, argumentTypes ...reflect.Type) {
-	config := triggerWithParameters{Trigger: trigger, ArgumentTypes: argumentTypes}
+	sm.setTriggerParameters(triggerWithParameters{Trigger: trigger, ArgumentTypes: argumentTypes})
+}
+
+// SetTriggerParametersVariadic specify the arguments that must be supplied when a specific trigger is fired,
+// where the last argument type accepts zero or more arguments, similar to a variadic function.
+func (sm *StateMachine) SetTriggerParametersVariadic(trigger Trigger, argumentTypes ...reflect.Type) {
+	if len(argumentTypes) == 0 {
+		panic(fmt.Sprintf("stateless: Variadic parameters for the trigger '%v' require at least one argument type.", trigger))
+	}
+	sm.setTriggerParameters(triggerWithParameters{Trigger: trigger, ArgumentTypes: argumentTypes, VariadicLast: true})
+}
+
+func (sm *StateMachine) setTriggerParameters(config triggerWithParameters) {
+	sm.triggerConfigMu.Lock()
+	defer sm.triggerConfigMu.Unlock()
 	if _, ok := sm.triggerConfig[config.Trigger]; ok {
-		panic(fmt.Sprintf("stateless: Parameters for the trigger '%v' have already been configured.", trigger))
+		panic(fmt.Sprintf("stateless: Parameters for the trigger '%v' have already been configured.", config.Trigger))
+	}
+	sm.triggerConfig[config.Trigger] = config
+}
+
+// triggerParameters returns the parameters configured for trigger, and whether they have been configured.
+func (sm *StateMachine) triggerParameters(trigger Trigger) (triggerWithParameters, bool) {
+	sm.triggerConfigMu.RLock()
+	defer sm.triggerConfigMu.RUnlock()
+	config, ok := sm.triggerConfig[trigger]
+	return config, ok
+}
+
+// TriggerParameters returns the argument types configured for a specific trigger
+// using SetTriggerParameters or SetTriggerParametersVariadic, and whether they have been configured.
+// If the parameters are variadic, the last type accepts zero or more arguments.
+func (sm *StateMachine) TriggerParameters(trigger Trigger) ([]reflect.Type, bool) {
+	config, ok := sm.triggerParameters(trigger)
+	if !ok {
+		return nil, false
 	}
-	sm.triggerConfig[trigger] = config
+	return append([]reflect.Type(nil), config.ArgumentTypes...), true
 }
 
-// Fire see FireCtx
+// ResetTriggerParameters clears the arguments previously configured for a specific trigger
+// using SetTriggerParameters, so they can be configured again.
+// It panics if called while the state machine is firing a trigger.
+func (sm *StateMachine) ResetTriggerParameters(trigger Trigger) {
+	if sm.Firing() {
+		panic(fmt.Sprintf("stateless: Parameters for the trigger '%v' cannot be reset while the state machine is firing.", trigger))
+	}
+	sm.triggerConfigMu.Lock()
+	defer sm.triggerConfigMu.Unlock()
+	delete(sm.triggerConfig, trigger)
+}
+
+// SetTriggerDefault specify the argument that will be supplied when a specific trigger is fired without arguments.
+// The default is not used when the caller supplies any argument, even if it is nil.
+func (sm *StateMachine) SetTriggerDefault(trigger Trigger, defaultArg any) {
+	sm.triggerConfigMu.Lock()
+	defer sm.triggerConfigMu.Unlock()
+	sm.triggerDefaults[trigger] = defaultArg
+}
+
+// argsOrDefault returns the default argument of the trigger if args is empty and a default has been set.
+func (sm *StateMachine) argsOrDefault(trigger Trigger, args []any) []any {
+	if len(args) == 0 {
+		sm.triggerConfigMu.RLock()
+		arg, ok := sm.triggerDefaults[trigger]
+		sm.triggerConfigMu.RUnlock()
+		if ok {
+			return []any{arg}
+		}
+	}
+	return args
+}
+
+// DeprecateTrigger marks a trigger as deprecated. Deprecated triggers keep working as usual,
+// but the first time one is fired a deprecation notice with the given message is emitted
+// to the function registered with OnDeprecatedTrigger, or to the standard logger if there is none.
+func (sm *StateMachine) DeprecateTrigger(trigger Trigger, message string) {
+	sm.triggerConfigMu.Lock()
+	defer sm.triggerConfigMu.Unlock()
+	sm.deprecatedTriggers[trigger] = &deprecatedTrigger{Message: message}
+}
+
+// OnDeprecatedTrigger registers a callback that will be invoked the first time
+// a trigger marked as deprecated with DeprecateTrigger is fired.
+func (sm *StateMachine) OnDeprecatedTrigger(fn DeprecatedTriggerFunc) {
+	sm.triggerConfigMu.Lock()
+	defer sm.triggerConfigMu.Unlock()
+	sm.onDeprecatedTrigger = fn
+}
+
+// OnGuardPanic registers a callback that will be invoked when a guard function panics.
+// The panic is recovered, reported to the callback together with the trigger being evaluated,
+// and the guard is treated as if it had returned false.
+// If no callback is registered, panics raised by guards are not recovered.
+func (sm *StateMachine) OnGuardPanic(fn GuardPanicFunc) {
+	sm.onGuardPanic = fn
+}
+
+// recoverGuard wraps guard so that its panics are recovered and reported to the
+// OnGuardPanic callback, if one is registered at the time the guard is evaluated.
+func (sm *StateMachine) recoverGuard(trigger Trigger, guard GuardFunc) GuardFunc {
+	return func(ctx context.Context, args ...any) (ok bool) {
+		if fn := sm.onGuardPanic; fn != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					fn(ctx, trigger, r)
+					ok = false
+				}
+			}()
+		}
+		return guard(ctx, args...)
+	}
+}
+
+// SetBroadcastTrigger marks a trigger as broadcast. When a broadcast trigger is handled by an internal transition,
+// the internal transitions configured for that trigger at every level of the active state hierarchy
+// whose guards are met are executed, starting from the current state and continuing with its superstates,
+// instead of only the closest one.
+// This is useful to model commands, such as a refresh, that every layer handles independently.
+func (sm *StateMachine) SetBroadcastTrigger(trigger Trigger) {
+	sm.broadcastTriggers[trigger] = struct{}{}
+}
+
+// SetArgCloner sets a function used to copy the arguments of the triggers
+// that are enqueued in FiringQueued mode, so that the queued execution sees a snapshot
+// of the arguments even if the caller mutates them afterwards.
+// By default the arguments are not copied. It has no effect in FiringImmediate mode.
+func (sm *StateMachine) SetArgCloner(cloner func([]any) []any) {
+	sm.argCloner = cloner
+}
+
+// SetReportInternalTransitions configures whether internal transitions invoke the callbacks registered
+// with OnTransitioning and OnTransitioned. The reported transitions have the same source and destination
+// and Transition.IsInternal returns true. By default internal transitions are not reported,
+// as they don't change the state of the state machine.
+func (sm *StateMachine) SetReportInternalTransitions(report bool) {
+	sm.reportInternal = report
+}
+
+// SetArgRedactor sets a function used to transform the trigger arguments before they are included
+// in any emitted event, such as the ones registered with OnTransitioning and OnTransitioned,
+// which can retrieve them using GetEventArgs. It can be used to redact secrets or to convert
+// the arguments into a serializable form. By default the arguments are reported unchanged.
+// The arguments received by guards and actions are never modified.
+func (sm *StateMachine) SetArgRedactor(redactor func(args []any) any) {
+	sm.argRedactor = redactor
+}
+
+// SetDropUnhandledQueued configures how unhandled triggers that have been queued while
+// processing another trigger, i.e. fired from within an action in FiringQueued mode, are treated.
+// By default, the error returned by the unhandled trigger action is returned by the Fire call
+// that is processing the queue, failing the whole chain. If drop is true, the error is logged
+// and the trigger is dropped, so the queue processing continues.
+func (sm *StateMachine) SetDropUnhandledQueued(drop bool) {
+	sm.dropUnhandledQueued = drop
+}
+
+// SetAutoDeactivateOnExit configures whether the activated states are deactivated when they are left by a transition.
+// If enabled, after executing the exit actions of a transition, the deactivation actions of the states
+// that have been activated with Activate and are being left are executed, starting from the deepest substate.
+// The states that are also part of the destination hierarchy are not deactivated.
+// By default states are only deactivated when calling Deactivate.
+func (sm *StateMachine) SetAutoDeactivateOnExit(enabled bool) {
+	sm.autoDeactivate = enabled
+}
+
+// SetMaxChainLength limits the number of automatic transitions, such as completion transitions
+// or transitions caused by triggers fired from actions, that a single call to Fire can produce.
+// When the limit is exceeded Fire returns an error wrapping ErrChainTooLong that contains
+// the path of the visited states. This is a safety valve against misconfigured machines that loop forever.
+// A value lower or equal than 0, which is the default, means unlimited.
+//
+// Triggers fired from actions are only accounted if they are fired with the context received by the action.
+func (sm *StateMachine) SetMaxChainLength(n int) {
+	sm.maxChainLength = n
+}
+
+// SetMaxQueueDepth limits the number of triggers that can wait in the queue in FiringQueued mode.
+// When the queue is full, firing a trigger returns ErrQueueFull instead of enqueuing it, which stops
+// runaway loops of actions that keep firing triggers. If the trigger is fired from an action,
+// the error is returned by the action and fails the Fire call that is processing the queue.
+// A value lower or equal than 0, which is the default, means unlimited. It has no effect in FiringImmediate mode.
+func (sm *StateMachine) SetMaxQueueDepth(n int) {
+	sm.maxQueueDepth = n
+}
+
+// QueueLen returns the number of triggers waiting to be processed in FiringQueued mode,
+// not including the one being processed. It is always 0 in FiringImmediate mode.
+func (sm *StateMachine) QueueLen() int {
+	return sm.mode.QueueLen()
+}
+
+// SetTransitionTimeout limits the time the exit and entry actions of a single transition can take.
+// The actions receive a context with the corresponding deadline, and once it is exceeded
+// the remaining actions are not executed and Fire returns context.DeadlineExceeded.
+// As there is no rollback mechanism, the state will have already changed if the deadline
+// is exceeded while executing the entry actions.
+// A value lower or equal than 0, which is the default, means no timeout.
+func (sm *StateMachine) SetTransitionTimeout(d time.Duration) {
+	sm.transitionTimeout = d
+}
+
+// SetSynchronous configures whether the Fire calls are serialized under a lock owned by the state machine,
+// so the actions and callbacks executed by concurrent calls never overlap. This is distinct from the
+// run-to-completion semantics of FiringQueued mode, which still allows concurrent calls to enter Fire.
+// By default Fire calls are not serialized.
+//
+// Triggers fired from within an action using the context received by the action don't acquire the lock again.
+// Firing a trigger from an action with any other context, or waiting in an action for another goroutine
+// that fires a trigger, deadlocks.
+func (sm *StateMachine) SetSynchronous(synchronous bool) {
+	sm.synchronous = synchronous
+}
+
+// SetAllowReentrantFire configures whether a trigger can be fired in FiringImmediate mode while another one
+// is being processed, e.g. from within an entry action. Reentrant fires are processed recursively,
+// which leads to interleaved exit and entry actions and can deadlock if the state accessor or mutator
+// acquire a lock held by the outer call. If allow is false, such fires return ErrReentrantFire without
+// doing anything. By default reentrant fires are allowed. It has no effect in FiringQueued mode,
+// where the triggers fired while processing another one are queued.
+//
+// The state machine can't tell a reentrant fire from a concurrent one, so Fire calls made from other
+// goroutines while a trigger is being processed are also rejected. Completion transitions are not affected.
+func (sm *StateMachine) SetAllowReentrantFire(allow bool) {
+	sm.rejectReentrant = !allow
+}
+
+// serialized reports whether the Fire calls are serialized under syncMutex, which is the case
+// if the state machine is synchronous, or if it has timed triggers in FiringImmediate mode,
+// so the timed triggers are never fired concurrently with other triggers.
+func (sm *StateMachine) serialized() bool {
+	if sm.synchronous {
+		return true
+	}
+	_, immediate := sm.mode.(*fireModeImmediate)
+	return sm.timed && immediate
+}
+
+// lockSynchronous acquires the lock used to serialize Fire calls if the state machine is serialized
+// and ctx doesn't come from a call already holding it. The returned function releases the lock.
+func (sm *StateMachine) lockSynchronous(ctx context.Context) (context.Context, func()) {
+	if !sm.serialized() || ctx.Value(synchronousKey{}) == sm {
+		return ctx, func() {}
+	}
+	sm.syncMutex.Lock()
+	return context.WithValue(ctx, synchronousKey{}, sm), sm.syncMutex.Unlock
+}
+
+// Fire see FireCtx.
+// The arguments are optional: a trigger without parameters is fired as Fire(trigger),
+// whereas Fire(trigger, nil) supplies a single nil argument.
 func (sm *StateMachine) Fire(trigger Trigger, args ...any) error {
 	return sm.FireCtx(context.Background(), trigger, args...)
 }
@@ -253,40 +1099,371 @@ func (sm *StateMachine) Fire(trigger Trigger, args ...any) error {
 // The target state is determined by the configuration of the current state.
 // Actions associated with leaving the current state and entering the new one will be invoked.
 //
-// An error is returned if any of the state machine actions or the state callbacks return an error
-// without wrapping. It can also return an error if the trigger is not mapped to any state change,
-// being this error the one returned by `OnUnhandledTrigger` func.
+// An error is returned if any of the state machine actions or the state callbacks return an error.
+// The errors returned by the actions are wrapped in an *ActionError. It can also return an error if the trigger
+// is not mapped to any state change, being this error the one returned by `OnUnhandledTrigger` func,
+// which by default is an *UnhandledTriggerError.
 //
 // There is no rollback mechanism in case there is an action error after the state has been changed.
 // Guard clauses or error states can be used gracefully handle this situations.
+// An exit action can cancel the transition before the state changes returning ErrAbortTransition.
 //
 // The context is passed down to all actions and callbacks called within the scope of this method.
-// There is no context error checking, although it may be implemented in future releases.
+// There is no context error checking, except for the transition timeout configured with SetTransitionTimeout
+// and, in FiringQueued mode, between the queued triggers: if the context is done, the context error is returned
+// and the triggers not yet processed stay queued until the next Fire.
 func (sm *StateMachine) FireCtx(ctx context.Context, trigger Trigger, args ...any) error {
 	return sm.internalFire(ctx, trigger, args...)
 }
 
+// FireCtxResult behaves like FireCtx but also returns the transition applied as a result of firing the trigger.
+// The destination of the transition is the state the machine ends up in, after following the initial transitions
+// of the entered states. When the trigger is ignored or handled by an internal transition,
+// the source and the destination of the returned transition are the same.
+//
+// Transitions caused by triggers fired from the actions, or by completion transitions, are not taken into account.
+// The returned transition is the zero value if an error occurs, if the trigger is handled by the unhandled trigger action,
+// or if it is called from within an action in FiringQueued mode, as the trigger is only enqueued.
+func (sm *StateMachine) FireCtxResult(ctx context.Context, trigger Trigger, args ...any) (Transition, error) {
+	result := new(Transition)
+	if err := sm.internalFire(context.WithValue(ctx, fireResultKey{}, result), trigger, args...); err != nil {
+		return Transition{}, err
+	}
+	return *result, nil
+}
+
+// FireTimeout behaves like FireCtx but with a context that is canceled after d.
+// Unlike in FireCtx, the deadline is checked before each action and before the state is changed:
+// if it is exceeded, context.DeadlineExceeded is returned and the state is not updated.
+// The actions executed before the deadline are not rolled back.
+func (sm *StateMachine) FireTimeout(trigger Trigger, d time.Duration, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return sm.FireCtx(context.WithValue(ctx, fireTimeoutKey{}, true), trigger, args...)
+}
+
+// FireCtxHandled behaves like FireCtx but also reports whether the trigger has been handled.
+// handled is false when the trigger is ignored, either with Ignore or because the unhandled trigger action
+// doesn't return an error, or when an exit action aborts the transition with ErrAbortTransition,
+// and true when it causes a transition, a reentry or an internal transition.
+//
+// handled is also false if an error occurs or if it is called from within an action in FiringQueued mode,
+// as the trigger is only enqueued.
+func (sm *StateMachine) FireCtxHandled(ctx context.Context, trigger Trigger, args ...any) (handled bool, err error) {
+	result := new(bool)
+	if err = sm.internalFire(context.WithValue(ctx, fireHandledKey{}, result), trigger, args...); err != nil {
+		return false, err
+	}
+	return *result, nil
+}
+
+// TriggerArg pairs a trigger with the arguments it is fired with.
+type TriggerArg struct {
+	Trigger Trigger
+	Args    []any
+}
+
+// FireBatch fires the triggers in order, stopping at the first one that returns an error.
+// It returns the index of the failing trigger together with its error, or len(triggers) and nil
+// if all of them have been fired. The machine is left in the state reached by the triggers fired
+// before the failing one, as there is no rollback mechanism.
+//
+// In FiringQueued mode the whole batch is enqueued before processing any of the triggers,
+// so the triggers fired from the actions are processed after the batch, preserving run-to-completion
+// semantics across it. If a trigger fails, the remaining triggers of the batch are discarded.
+// The errors of those later triggers are not reported once every trigger of the batch has been fired.
+// When called from within an action in FiringQueued mode the batch is only enqueued
+// and the returned index is len(triggers).
+func (sm *StateMachine) FireBatch(ctx context.Context, triggers []TriggerArg) (int, error) {
+	ctx, unlock := sm.lockSynchronous(ctx)
+	defer unlock()
+	if err := sm.checkActivated(); err != nil {
+		return 0, err
+	}
+	if f, ok := sm.mode.(*fireModeQueued); ok {
+		return f.FireBatch(ctx, triggers)
+	}
+	for i, ta := range triggers {
+		if err := sm.internalFire(ctx, ta.Trigger, ta.Args...); err != nil {
+			return i, err
+		}
+	}
+	return len(triggers), nil
+}
+
+// FireDetailed see FireDetailedCtx.
+func (sm *StateMachine) FireDetailed(trigger Trigger, args ...any) (FireResult, error) {
+	return sm.FireDetailedCtx(context.Background(), trigger, args...)
+}
+
+// FireDetailedCtx behaves like FireCtx but also reports all the transitions performed
+// as a result of firing the trigger, in the order they happened, together with the final state.
+// This gives visibility into run-to-completion chains, where a single external trigger
+// leads to several transitions.
+//
+// If an error occurs, the returned result contains the transitions performed before the error.
+// When called from within an action in FiringQueued mode the trigger is only enqueued,
+// so the returned result will not contain any transition.
+func (sm *StateMachine) FireDetailedCtx(ctx context.Context, trigger Trigger, args ...any) (FireResult, error) {
+	chain := new(fireChain)
+	err := sm.internalFire(context.WithValue(ctx, fireChainKey{}, chain), trigger, args...)
+	result := FireResult{Transitions: chain.Transitions()}
+	if err != nil {
+		return result, err
+	}
+	result.State, err = sm.State(ctx)
+	return result, err
+}
+
+// DefaultOnEntry specify an action that will execute when transitioning into any state,
+// before the entry actions configured for that state.
+// When several states are entered, e.g. a superstate and its substate, the action is executed
+// once for each of them, following the usual order: the superstate is entered before the substate.
+// Unlike OnTransitioned callbacks, an error returned by the action aborts the transition.
+func (sm *StateMachine) DefaultOnEntry(action ActionFunc) {
+	sm.defaults.EntryActions = append(sm.defaults.EntryActions, actionBehaviour{
+		Action:      action,
+		Description: newinvocationInfo(action),
+	})
+}
+
+// PermitFromAll accept the specified trigger in every state, current and future, and transition to the destination state
+// if the guard conditions are met (if any), except when the current state is the destination itself.
+// It is a machine-level default: the transition is only considered when neither the current state nor its superstates
+// have any behaviour configured for the trigger, so a state-specific Permit, or any other behaviour, overrides it.
+// It is taken into account by Fire, CanFire and PermittedTriggers, but it is not rendered in the graphs.
+func (sm *StateMachine) PermitFromAll(trigger Trigger, destinationState State, guards ...GuardFunc) {
+	if sm.defaults.Transitions == nil {
+		sm.defaults.Transitions = make(map[Trigger][]triggerBehaviour)
+	}
+	sm.defaults.Transitions[trigger] = append(sm.defaults.Transitions[trigger], &transitioningTriggerBehaviour{
+		baseTriggerBehaviour: baseTriggerBehaviour{Trigger: trigger, Guard: sm.newGuard(trigger, guards...)},
+		Destination:          destinationState,
+	})
+}
+
+// DefaultOnExit specify an action that will execute when transitioning from any state,
+// before the exit actions configured for that state.
+// When several states are exited, e.g. a substate and its superstate, the action is executed
+// once for each of them, following the usual order: the substate is exited before the superstate.
+// Unlike OnTransitioning callbacks, an error returned by the action aborts the transition.
+func (sm *StateMachine) DefaultOnExit(action ActionFunc) {
+	sm.defaults.ExitActions = append(sm.defaults.ExitActions, actionBehaviour{
+		Action:      action,
+		Description: newinvocationInfo(action),
+	})
+}
+
 // OnTransitioned registers a callback that will be invoked every time the state machine
 // successfully finishes a transitions from one state into another.
+// Internal transitions are only reported if enabled with SetReportInternalTransitions.
 func (sm *StateMachine) OnTransitioned(fn ...TransitionFunc) {
 	sm.onTransitionedEvents = append(sm.onTransitionedEvents, fn...)
 }
 
+// OnTransitionFailed registers a callback that will be invoked every time a transition fails
+// because an exit or entry action, a deactivation action or the state mutator returns an error,
+// with the attempted transition and the error, which is also returned by Fire.
+// As there is no rollback mechanism, the state may have already changed when the callback is invoked.
+func (sm *StateMachine) OnTransitionFailed(fn ...TransitionFailedFunc) {
+	sm.onTransitionFailed = append(sm.onTransitionFailed, fn...)
+}
+
+// OnStateChanged registers a callback that will be invoked every time the current state
+// of the state machine changes, once the new state has been stored.
+// Unlike OnTransitioned, it is not invoked for reentry, internal or ignored transitions,
+// nor when the trigger leads back to the state it was fired from.
+// The event time is taken from the clock configured with SetClock.
+func (sm *StateMachine) OnStateChanged(fn ...StateChangedFunc) {
+	sm.onStateChangedEvents = append(sm.onStateChangedEvents, fn...)
+}
+
 // OnTransitioning registers a callback that will be invoked every time the state machine
 // starts a transitions from one state into another.
+// Internal transitions are only reported if enabled with SetReportInternalTransitions.
 func (sm *StateMachine) OnTransitioning(fn ...TransitionFunc) {
 	sm.onTransitioningEvents = append(sm.onTransitioningEvents, fn...)
 }
 
 // OnUnhandledTrigger override the default behaviour of returning an error when an unhandled trigger.
+// Handlers configured for a specific state using StateConfiguration.OnUnhandledTrigger take precedence.
 func (sm *StateMachine) OnUnhandledTrigger(fn UnhandledTriggerActionFunc) {
 	sm.unhandledTriggerAction = fn
 }
 
 // Configure begin configuration of the entry/exit actions and allowed transitions
 // when the state machine is in a particular state.
+//
+// The transitions, initial transitions, blocked triggers and superstates can be configured from multiple goroutines,
+// also while the permitted triggers are being queried. The guards are evaluated without holding any lock.
 func (sm *StateMachine) Configure(state State) *StateConfiguration {
-	return &StateConfiguration{sm: sm, sr: sm.stateRepresentation(state), lookup: sm.stateRepresentation}
+	sr := sm.stateRepresentation(state)
+	sm.stateMutex.Lock()
+	sr.Configured = true
+	sm.stateMutex.Unlock()
+	return &StateConfiguration{sm: sm, sr: sr, lookup: sm.stateRepresentation}
+}
+
+// ClearEntryActions removes all the entry actions configured for the state,
+// including the ones configured with OnEntryFrom.
+// It is intended for testing, e.g. to stub out actions with side effects.
+// It panics if called while the state machine is firing a trigger.
+func (sm *StateMachine) ClearEntryActions(state State) {
+	sm.OverrideEntryActions(state)
+}
+
+// OverrideEntryActions replaces all the entry actions configured for the state with the given actions,
+// including the ones configured with OnEntryFrom.
+// It is intended for testing, e.g. to replace actions with side effects with fakes.
+// It panics if called while the state machine is firing a trigger.
+func (sm *StateMachine) OverrideEntryActions(state State, actions ...ActionFunc) {
+	if sm.Firing() {
+		panic(fmt.Sprintf("stateless: Entry actions of state '%v' cannot be modified while the state machine is firing.", state))
+	}
+	sr := sm.stateRepresentation(state)
+	sr.EntryActions = make([]actionBehaviour, len(actions))
+	for i, action := range actions {
+		sr.EntryActions[i] = actionBehaviour{
+			Action:      action,
+			Description: newinvocationInfo(action),
+		}
+	}
+}
+
+// OverrideGuard forces the guards of the behaviours configured for the trigger in the state
+// to evaluate to result, and returns a function that restores the original guards.
+// If there are several behaviours for the trigger and result is true, only the guards of the first one,
+// i.e. the one with the highest priority or the first configured, are forced to be met, and the guards
+// of the others are forced not to be met, so the trigger is not ambiguous.
+// The guard descriptions are kept, so unmet guards are still reported as configured.
+// It is intended for testing the guarded branches without crafting the arguments that satisfy the guards.
+// It panics if the state is not configured or if called while the state machine is firing a trigger.
+func (sm *StateMachine) OverrideGuard(state State, trigger Trigger, result bool) (restore func()) {
+	if sm.Firing() {
+		panic(fmt.Sprintf("stateless: Guards of trigger '%v' in state '%v' cannot be overridden while the state machine is firing.", trigger, state))
+	}
+	sm.stateMutex.RLock()
+	sr, ok := sm.stateConfig[state]
+	sm.stateMutex.RUnlock()
+	if !ok {
+		panic(fmt.Sprintf("stateless: Guards of trigger '%v' cannot be overridden in state '%v', which is not configured.", trigger, state))
+	}
+	sr.configMu.Lock()
+	defer sr.configMu.Unlock()
+	// The behaviours are replaced by overridden copies, as the snapshots returned by behaviours may be in use.
+	originals := sr.TriggerBehaviours[trigger]
+	overrides := make([]triggerBehaviour, len(originals))
+	for i, behaviour := range originals {
+		met := result && i == 0
+		forced := func(_ context.Context, _ ...any) bool { return met }
+		overrides[i] = sm.cloneTriggerBehaviour(behaviour)
+		base := overrides[i].base()
+		guard := transitionGuard{Guards: make([]guardCondition, len(base.Guard.Guards))}
+		for j, cond := range base.Guard.Guards {
+			guard.Guards[j] = guardCondition{Guard: forced, Description: cond.Description}
+		}
+		if len(guard.Guards) == 0 {
+			guard.Guards = append(guard.Guards, guardCondition{Guard: forced, Description: invocationInfo{Method: "OverrideGuard"}})
+		}
+		base.Guard = guard
+	}
+	sr.TriggerBehaviours[trigger] = overrides
+	return func() {
+		sr.configMu.Lock()
+		defer sr.configMu.Unlock()
+		current := sr.TriggerBehaviours[trigger]
+		restored := make([]triggerBehaviour, len(current))
+		for i, behaviour := range current {
+			restored[i] = behaviour
+			for j, override := range overrides {
+				if behaviour == override {
+					restored[i] = originals[j]
+				}
+			}
+		}
+		sr.TriggerBehaviours[trigger] = restored
+	}
+}
+
+// RegisterStates configures all the given states, typically all the values of an enumeration-like state type,
+// so they are known by the state machine even if they have no behaviour configured.
+// It is equivalent to calling Configure for each state.
+func RegisterStates[S comparable](sm *StateMachine, states ...S) {
+	for _, state := range states {
+		sm.Configure(state)
+	}
+}
+
+// RenameState renames a configured state, rewriting all the references to it:
+// the state configuration, the destinations of the transitions, including the ones configured
+// with PermitFromAll and the possible destinations declared with PermitDynamicDest,
+// the superstate/substate relationships, the initial transition targets and the recorded history.
+// An error is returned if oldState is not configured or if newState is already configured.
+// The current state of the machine is not modified.
+func (sm *StateMachine) RenameState(oldState, newState State) error {
+	sm.stateMutex.Lock()
+	defer sm.stateMutex.Unlock()
+	sr, ok := sm.stateConfig[oldState]
+	if !ok {
+		return fmt.Errorf("stateless: State '%v' is not configured", oldState)
+	}
+	if _, ok := sm.stateConfig[newState]; ok {
+		return fmt.Errorf("stateless: State '%v' is already configured", newState)
+	}
+	delete(sm.stateConfig, oldState)
+	sr.configMu.Lock()
+	sr.State = newState
+	sr.configMu.Unlock()
+	sm.stateConfig[newState] = sr
+	for _, sr := range sm.stateConfig {
+		sr.renameReferences(oldState, newState)
+	}
+	for trigger, behaviours := range sm.defaults.Transitions {
+		if renamed := renameDestinations(behaviours, oldState, newState); renamed != nil {
+			sm.defaults.Transitions[trigger] = renamed
+		}
+	}
+	return nil
+}
+
+// RemoveSubstate detaches a configured state from its superstate, undoing SubstateOf.
+// After that the state no longer inherits the behaviours, entry and exit actions of its former superstate.
+// An error is returned if state is not configured or if it has no superstate.
+func (sm *StateMachine) RemoveSubstate(state State) error {
+	sm.hierarchyMu.Lock()
+	defer sm.hierarchyMu.Unlock()
+	sm.stateMutex.RLock()
+	sr, ok := sm.stateConfig[state]
+	sm.stateMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("stateless: State '%v' is not configured", state)
+	}
+	if sr.superstate() == nil {
+		return fmt.Errorf("stateless: State '%v' has no superstate", state)
+	}
+	sr.setSuperstate(nil)
+	return nil
+}
+
+// Reset sets the current state of the state machine to the given state, without executing any action,
+// and clears its runtime bookkeeping: the queued triggers, the activated states, the timers armed
+// by PermitAfter and the recorded history, so a subsequent Activate runs the activation actions again.
+// The configuration is not modified.
+//
+// It returns an error if called while the state machine is firing a trigger, or if the state mutator fails.
+func (sm *StateMachine) Reset(ctx context.Context, state State) error {
+	if sm.Firing() {
+		return fmt.Errorf("stateless: The state machine cannot be reset to state '%v' while it is firing", state)
+	}
+	sm.mode.Reset()
+	sm.activated.clear()
+	sm.stateMutex.RLock()
+	for _, sr := range sm.stateConfig {
+		sr.cancelTimers()
+		sr.clearHistory()
+	}
+	sm.stateMutex.RUnlock()
+	return sm.setState(ctx, state)
 }
 
 // Firing returns true when the state machine is processing a trigger.
@@ -295,7 +1472,10 @@ func (sm *StateMachine) Firing() bool {
 }
 
 // String returns a human-readable representation of the state machine.
-// It is not guaranteed that the order of the PermittedTriggers is the same in consecutive executions.
+// The PermittedTriggers are sorted by their string representation.
+//
+// Rendering the permitted triggers evaluates the guards of every trigger configured
+// for the current state and its superstates. Use StateString when only the current state is needed.
 func (sm *StateMachine) String() string {
 	state, err := sm.State(context.Background())
 	if err != nil {
@@ -304,9 +1484,24 @@ func (sm *StateMachine) String() string {
 
 	// PermittedTriggers only returns an error if state accessor returns one, and it has already been checked.
 	triggers, _ := sm.PermittedTriggers()
+	sort.SliceStable(triggers, func(i, j int) bool {
+		return lessByString(triggers[i], triggers[j])
+	})
 	return fmt.Sprintf("StateMachine {{ State = %v, PermittedTriggers = %v }}", state, triggers)
 }
 
+// StateString returns a human-readable representation of the current state.
+// Unlike String, it only reads the current state once and does not evaluate any guard,
+// which makes it suitable for hot-path logging.
+// It returns an empty string if the state accessor returns an error.
+func (sm *StateMachine) StateString() string {
+	state, err := sm.State(context.Background())
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(state)
+}
+
 func (sm *StateMachine) setState(ctx context.Context, state State, args ...any) error {
 	return sm.stateMutator(ctx, state, args...)
 }
@@ -329,6 +1524,8 @@ func (sm *StateMachine) stateRepresentation(state State) *stateRepresentation {
 		// Check again, since another goroutine may have added it while we were waiting for the lock.
 		if sr, ok = sm.stateConfig[state]; !ok {
 			sr = newstateRepresentation(state)
+			sr.Defaults = &sm.defaults
+			sr.Resolution = &sm.guardResolution
 			sm.stateConfig[state] = sr
 		}
 	}
@@ -336,103 +1533,470 @@ func (sm *StateMachine) stateRepresentation(state State) *stateRepresentation {
 }
 
 func (sm *StateMachine) internalFire(ctx context.Context, trigger Trigger, args ...any) error {
+	ctx, unlock := sm.lockSynchronous(ctx)
+	defer unlock()
+	if err := sm.checkActivated(); err != nil {
+		return err
+	}
+	ctx, args = sm.fireContext(ctx, trigger, args)
 	return sm.mode.Fire(ctx, trigger, args...)
 }
 
+// withFireArgs stores the arguments of the trigger being fired in ctx,
+// if the state accessor has been configured to receive them.
+func (sm *StateMachine) withFireArgs(ctx context.Context, args []any) context.Context {
+	if !sm.fireArgsInContext {
+		return ctx
+	}
+	return context.WithValue(ctx, fireArgsKey{}, args)
+}
+
+// fireContext returns the context and the arguments used to fire trigger.
+func (sm *StateMachine) fireContext(ctx context.Context, trigger Trigger, args []any) (context.Context, []any) {
+	args = sm.argsOrDefault(trigger, args)
+	ctx = withRootTrigger(ctx, trigger)
+	ctx = sm.withFireArgs(ctx, args)
+	if sm.maxChainLength > 0 {
+		if _, ok := ctx.Value(fireChainKey{}).(*fireChain); !ok {
+			ctx = context.WithValue(ctx, fireChainKey{}, new(fireChain))
+		}
+	}
+	return ctx, args
+}
+
+// timedFireArmed reports whether the timer that fired the timed trigger in ctx, if any, is still armed,
+// disarming it. A timer is no longer armed once the state that armed it has been exited.
+// As triggers are processed one at a time, the state can't be exited between the check and the fire.
+func timedFireArmed(ctx context.Context) (context.Context, bool) {
+	timed, ok := ctx.Value(timedFireKey{}).(timedFire)
+	if !ok {
+		return ctx, true
+	}
+	// The triggers fired by the actions don't come from the timer.
+	return context.WithValue(ctx, timedFireKey{}, nil), timed.sr.disarm(timed.armed)
+}
+
 func (sm *StateMachine) internalFireOne(ctx context.Context, trigger Trigger, args ...any) error {
-	var (
-		config triggerWithParameters
-		ok     bool
-	)
-	if config, ok = sm.triggerConfig[trigger]; ok {
+	ctx, armed := timedFireArmed(ctx)
+	if !armed {
+		return nil
+	}
+	if err := sm.checkChainLength(ctx); err != nil {
+		return err
+	}
+	sm.noticeDeprecated(ctx, trigger)
+	source, representativeState, err := sm.fireSource(ctx, trigger, args)
+	if err != nil {
+		return err
+	}
+	if handled, err := sm.fireRegions(ctx, representativeState, trigger, args...); handled || err != nil {
+		if err == nil {
+			reportRegionFire(ctx, source, trigger)
+		}
+		return err
+	}
+	result, ok, err := sm.resolveHandler(ctx, representativeState, trigger, args)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return sm.fireUnhandled(ctx, representativeState, trigger, result.UnmetGuardConditions)
+	}
+	return sm.fireHandler(ctx, source, representativeState, trigger, result.Handler, args...)
+}
+
+// fireSource validates the arguments of the trigger and returns the current state.
+func (sm *StateMachine) fireSource(ctx context.Context, trigger Trigger, args []any) (State, *stateRepresentation, error) {
+	if config, ok := sm.triggerParameters(trigger); ok {
 		config.validateParameters(args...)
 	}
 	source, err := sm.State(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	return source, sm.stateRepresentation(source), nil
+}
+
+// resolveHandler looks for the handler of the trigger in sr and its superstates,
+// returning the error of the first guard that fails, if any.
+func (sm *StateMachine) resolveHandler(ctx context.Context, sr *stateRepresentation, trigger Trigger, args []any) (triggerBehaviourResult, bool, error) {
+	guardCtx, guardErr := withGuardError(ctx)
+	result, ok := sr.FindHandler(guardCtx, trigger, args...)
+	if guardErr.err != nil {
+		return result, false, guardErr.err
+	}
+	return result, ok, nil
+}
+
+// noticeDeprecated reports the trigger the first time it is fired if it has been deprecated.
+func (sm *StateMachine) noticeDeprecated(ctx context.Context, trigger Trigger) {
+	sm.triggerConfigMu.RLock()
+	deprecated, ok := sm.deprecatedTriggers[trigger]
+	sm.triggerConfigMu.RUnlock()
+	if ok {
+		deprecated.once.Do(func() {
+			sm.warnDeprecatedTrigger(ctx, trigger, deprecated.Message)
+		})
+	}
+}
+
+// fireUnhandled executes the unhandled trigger action, dropping its error if the trigger was queued
+// and SetDropUnhandledQueued is enabled.
+func (sm *StateMachine) fireUnhandled(ctx context.Context, sr *stateRepresentation, trigger Trigger, unmetGuards []string) error {
+	err := sm.handleUnhandledTrigger(ctx, sr, trigger, unmetGuards)
+	if err != nil && ctx.Value(droppableTriggerKey{}) != nil {
+		log.Printf("stateless: Dropping queued trigger '%v': %v", trigger, err)
+		return nil
+	}
+	return err
+}
+
+// checkChainLength returns an error if the chain of transitions in ctx is longer than the configured limit.
+func (sm *StateMachine) checkChainLength(ctx context.Context) error {
+	if sm.maxChainLength > 0 {
+		// The first transition of the chain is the one caused by the external trigger.
+		if chain, ok := ctx.Value(fireChainKey{}).(*fireChain); ok && chain.Len() > sm.maxChainLength {
+			return fmt.Errorf("%w: %s", ErrChainTooLong, chain.path())
+		}
+	}
+	return nil
+}
+
+// fireHandler executes the handler resolved for the trigger in the source state.
+func (sm *StateMachine) fireHandler(ctx context.Context, source State, representativeState *stateRepresentation, trigger Trigger, handler triggerBehaviour, args ...any) (err error) {
+	result, _ := ctx.Value(fireResultKey{}).(*Transition)
+	if result != nil {
+		// Triggers fired from the actions must not overwrite the result of this one.
+		ctx = context.WithValue(ctx, fireResultKey{}, (*Transition)(nil))
 	}
-	representativeState := sm.stateRepresentation(source)
-	var result triggerBehaviourResult
-	if result, ok = representativeState.FindHandler(ctx, trigger, args...); !ok {
-		return sm.unhandledTriggerAction(ctx, representativeState.State, trigger, result.UnmetGuardConditions)
+	handled, _ := ctx.Value(fireHandledKey{}).(*bool)
+	if handled != nil {
+		ctx = context.WithValue(ctx, fireHandledKey{}, (*bool)(nil))
 	}
-	switch t := result.Handler.(type) {
+	var (
+		rep        *stateRepresentation
+		transition Transition
+		internal   bool
+	)
+	switch t := handler.(type) {
 	case *ignoredTriggerBehaviour:
 		// ignored
 	case *reentryTriggerBehaviour:
-		transition := Transition{Source: source, Destination: t.Destination, Trigger: trigger}
-		err = sm.handleReentryTrigger(ctx, representativeState, transition, args...)
+		transition = Transition{Source: source, Destination: t.Destination, Trigger: trigger}
+		rep, err = sm.handleReentryTrigger(ctx, representativeState, transition, args...)
 	case *dynamicTriggerBehaviour:
 		var destination any
 		destination, err = t.Destination(ctx, args...)
+		if err == nil && !t.allows(destination) {
+			err = fmt.Errorf("%w: trigger '%v' in state '%v' selected '%v', which is not one of %v", ErrUndeclaredDestination, trigger, source, destination, t.PossibleDestinations)
+		}
 		if err == nil {
-			transition := Transition{Source: source, Destination: destination, Trigger: trigger}
-			err = sm.handleTransitioningTrigger(ctx, representativeState, transition, args...)
+			transition = Transition{Source: source, Destination: destination, Trigger: trigger}
+			rep, err = sm.handleTransitioningTrigger(ctx, representativeState, transition, nil, args...)
 		}
 	case *transitioningTriggerBehaviour:
 		if source == t.Destination {
 			// If a trigger was found on a superstate that would cause unintended reentry, don't trigger.
 			break
 		}
-		transition := Transition{Source: source, Destination: t.Destination, Trigger: trigger}
-		err = sm.handleTransitioningTrigger(ctx, representativeState, transition, args...)
+		transition = Transition{Source: source, Destination: t.Destination, Trigger: trigger}
+		rep, err = sm.handleTransitioningTrigger(ctx, representativeState, transition, t.Action, args...)
+	case *internalDynamicTriggerBehaviour:
+		transition = Transition{Source: source, Destination: source, Trigger: trigger, isInternal: true}
+		var (
+			destination State
+			escalate    bool
+		)
+		destination, escalate, err = t.Execute(ctx, transition, args...)
+		if err == nil && escalate {
+			transition = Transition{Source: source, Destination: destination, Trigger: trigger}
+			rep, err = sm.handleTransitioningTrigger(ctx, representativeState, transition, nil, args...)
+		} else if err == nil {
+			internal = true
+			if sm.reportInternal {
+				sm.transitioning(ctx, transition, args...)
+				sm.transitioned(ctx, transition, args...)
+			}
+		}
 	case *internalTriggerBehaviour:
+		internal = true
 		var sr *stateRepresentation
 		sr, err = sm.currentState(ctx)
 		if err == nil {
-			transition := Transition{Source: source, Destination: source, Trigger: trigger}
-			err = sr.InternalAction(ctx, transition, args...)
+			transition = Transition{Source: source, Destination: source, Trigger: trigger, isInternal: true}
+			if sm.reportInternal {
+				sm.transitioning(ctx, transition, args...)
+			}
+			if _, broadcast := sm.broadcastTriggers[trigger]; broadcast {
+				err = sr.BroadcastInternalAction(ctx, transition, args...)
+			} else {
+				err = sr.InternalAction(ctx, transition, args...)
+			}
+			if err == nil && sm.reportInternal {
+				sm.transitioned(ctx, transition, args...)
+			}
+		}
+	}
+	// An exit action has vetoed the transition, so the trigger is not handled and the state is unchanged.
+	aborted := err == errTransitionAborted
+	if aborted {
+		err = nil
+	}
+	if err == nil && result != nil {
+		*result = Transition{Source: source, Destination: source, Trigger: trigger}
+		if rep != nil {
+			result.Destination = rep.State
 		}
+		result.isInternal = internal
+	}
+	if err == nil && handled != nil {
+		_, ignored := handler.(*ignoredTriggerBehaviour)
+		*handled = !ignored && !aborted
+	}
+	if err == nil && rep != nil && rep.State != source {
+		sm.stateChanged(ctx, StateChangedEvent{From: source, To: rep.State, Trigger: trigger, Args: args})
+	}
+	// Record the fire once handled, as the guards are evaluated again while handling it.
+	if err == nil && !aborted {
+		sm.recordFire(representativeState, trigger)
+	}
+	if err == nil && rep != nil {
+		err = sm.fireCompletion(ctx, transition, rep, args...)
 	}
 	return err
 }
 
-func (sm *StateMachine) handleReentryTrigger(ctx context.Context, sr *stateRepresentation, transition Transition, args ...any) error {
-	if err := sr.Exit(ctx, transition, args...); err != nil {
-		return err
+// handleUnhandledTrigger calls the unhandled trigger action of the closest state in the hierarchy
+// that has one configured, falling back to the machine one.
+func (sm *StateMachine) handleUnhandledTrigger(ctx context.Context, sr *stateRepresentation, trigger Trigger, unmetGuards []string) error {
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		if rep.UnhandledTriggerAction != nil {
+			return rep.UnhandledTriggerAction(ctx, sr.State, trigger, unmetGuards)
+		}
+	}
+	return sm.unhandledTriggerAction(ctx, sr.State, trigger, unmetGuards)
+}
+
+// fireTimed fires a trigger configured with StateConfiguration.OnEntryFireAfter once its timer expires.
+// ctx identifies the timer, see timedFireArmed.
+func (sm *StateMachine) fireTimed(ctx context.Context, trigger Trigger) {
+	if err := sm.FireCtx(ctx, trigger); err != nil {
+		log.Printf("stateless: Firing timed trigger '%v': %v", trigger, err)
+	}
+}
+
+func (sm *StateMachine) warnDeprecatedTrigger(ctx context.Context, trigger Trigger, message string) {
+	sm.triggerConfigMu.RLock()
+	fn := sm.onDeprecatedTrigger
+	sm.triggerConfigMu.RUnlock()
+	if fn != nil {
+		fn(ctx, trigger, message)
+		return
+	}
+	log.Printf("stateless: Trigger '%v' is deprecated: %s", trigger, message)
+}
+
+// fireCompletion fires the CompletionTrigger if the entered state, or any of its superstates
+// entered by the same transition, has a completion transition whose guards are met.
+func (sm *StateMachine) fireCompletion(ctx context.Context, transition Transition, sr *stateRepresentation, args ...any) error {
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		// The destination and its substates are always entered, even when reentering,
+		// whereas its superstates are only entered if they don't include the source.
+		if !rep.IsIncludedInState(transition.Destination) && rep.IncludeState(transition.Source) {
+			break
+		}
+		if _, ok := rep.findHandler(ctx, CompletionTrigger, args...); ok {
+			return sm.internalFire(ctx, CompletionTrigger, args...)
+		}
+	}
+	return nil
+}
+
+func (sm *StateMachine) handleReentryTrigger(ctx context.Context, sr *stateRepresentation, transition Transition, args ...any) (*stateRepresentation, error) {
+	rep, err := sm.reenter(ctx, sr, transition, args...)
+	if err != nil && err != errTransitionAborted {
+		sm.transitionFailed(ctx, transition, err, args...)
+	}
+	return rep, err
+}
+
+func (sm *StateMachine) reenter(ctx context.Context, sr *stateRepresentation, transition Transition, args ...any) (*stateRepresentation, error) {
+	ctx, cancel := sm.withTransitionTimeout(ctx)
+	defer cancel()
+	exitCtx, exited := withExitLog(ctx)
+	if err := sr.Exit(exitCtx, transition, args...); err != nil {
+		return nil, abortedErr(err)
 	}
 	newSr := sm.stateRepresentation(transition.Destination)
+	leaving := transition
 	if !transition.IsReentry() {
 		transition = Transition{Source: transition.Destination, Destination: transition.Destination, Trigger: transition.Trigger}
-		if err := newSr.Exit(ctx, transition, args...); err != nil {
-			return err
+		if err := newSr.Exit(exitCtx, transition, args...); err != nil {
+			return nil, abortedErr(err)
 		}
 	}
-	callEvents(sm.onTransitioningEvents, ctx, transition)
+	exited.commit()
+	if err := sm.deactivateLeftStates(ctx, sr, leaving.Destination); err != nil {
+		return nil, err
+	}
+	if err := transitionTimeoutErr(ctx); err != nil {
+		return nil, err
+	}
+	sm.transitioning(ctx, transition, args...)
 	rep, err := sm.enterState(ctx, newSr, transition, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := sm.setState(ctx, rep.State, args...); err != nil {
-		return err
+		return nil, err
 	}
-	callEvents(sm.onTransitionedEvents, ctx, transition)
-	return nil
+	rep.recordHistory()
+	sm.transitioned(ctx, transition, args...)
+	return rep, nil
 }
 
-func (sm *StateMachine) handleTransitioningTrigger(ctx context.Context, sr *stateRepresentation, transition Transition, args ...any) error {
-	if err := sr.Exit(ctx, transition, args...); err != nil {
-		return err
+// handleTransitioningTrigger transitions from sr to the destination, executing the action of the transition, if any,
+// before changing the state.
+func (sm *StateMachine) handleTransitioningTrigger(ctx context.Context, sr *stateRepresentation, transition Transition, action ActionFunc, args ...any) (*stateRepresentation, error) {
+	rep, err := sm.transition(ctx, sr, transition, action, args...)
+	if err != nil && err != errTransitionAborted {
+		sm.transitionFailed(ctx, transition, err, args...)
+	}
+	return rep, err
+}
+
+func (sm *StateMachine) transition(ctx context.Context, sr *stateRepresentation, transition Transition, action ActionFunc, args ...any) (*stateRepresentation, error) {
+	ctx, cancel := sm.withTransitionTimeout(ctx)
+	defer cancel()
+	exitCtx, exited := withExitLog(ctx)
+	if err := sr.Exit(exitCtx, transition, args...); err != nil {
+		return nil, abortedErr(err)
+	}
+	exited.commit()
+	if err := sm.deactivateLeftStates(ctx, sr, transition.Destination); err != nil {
+		return nil, err
+	}
+	if err := transitionTimeoutErr(ctx); err != nil {
+		return nil, err
+	}
+	sm.transitioning(ctx, transition, args...)
+	if action != nil {
+		if err := action(withTransition(ctx, transition), args...); err != nil {
+			return nil, actionErr(transition, err)
+		}
 	}
-	callEvents(sm.onTransitioningEvents, ctx, transition)
 	if err := sm.setState(ctx, transition.Destination, args...); err != nil {
-		return err
+		return nil, err
 	}
 	newSr := sm.stateRepresentation(transition.Destination)
 	rep, err := sm.enterState(ctx, newSr, transition, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Check if state has changed by entering new state (by firing triggers in OnEntry or such)
 	if rep.State != newSr.State {
 		if err := sm.setState(ctx, rep.State, args...); err != nil {
+			return nil, err
+		}
+	}
+	rep.recordHistory()
+	sm.transitioned(ctx, Transition{Source: transition.Source, Destination: rep.State, Trigger: transition.Trigger}, args...)
+	return rep, nil
+}
+
+// errTransitionAborted is returned internally when an exit action cancels the transition with ErrAbortTransition.
+// It is not reported as an error by Fire.
+var errTransitionAborted = errors.New("stateless: transition aborted by an exit action")
+
+// abortedErr returns errTransitionAborted if an exit action has cancelled the transition with ErrAbortTransition,
+// so it is not handled as a failure and the state is left unchanged.
+func abortedErr(err error) error {
+	if errors.Is(err, ErrAbortTransition) {
+		return errTransitionAborted
+	}
+	return err
+}
+
+// withEventArgs returns a copy of ctx containing the arguments to be reported in the emitted events,
+// transformed with the configured redactor.
+func (sm *StateMachine) withEventArgs(ctx context.Context, args []any) context.Context {
+	var eventArgs any = args
+	if sm.argRedactor != nil {
+		eventArgs = sm.argRedactor(args)
+	}
+	return context.WithValue(ctx, eventArgsKey{}, eventArgs)
+}
+
+// withTransitionTimeout returns a copy of ctx whose deadline is the configured transition timeout.
+// The original context is stored so triggers fired from actions in FiringQueued mode,
+// which are processed once the current transition has finished, are not affected by it.
+func (sm *StateMachine) withTransitionTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if sm.transitionTimeout <= 0 {
+		return ctx, func() {}
+	}
+	tctx, cancel := context.WithTimeout(ctx, sm.transitionTimeout)
+	return context.WithValue(tctx, transitionTimeoutKey{}, ctx), cancel
+}
+
+// deactivateLeftStates deactivates, from the deepest one, the activated states
+// in the hierarchy of sr that are not part of the destination hierarchy.
+func (sm *StateMachine) deactivateLeftStates(ctx context.Context, sr *stateRepresentation, destination State) error {
+	if !sm.autoDeactivate {
+		return nil
+	}
+	dest := sm.stateRepresentation(destination)
+	for rep := sr; rep != nil && !dest.IsIncludedInState(rep.State); rep = rep.superstate() {
+		if !sm.activated.contains(rep.State) {
+			continue
+		}
+		if err := rep.executeDeactivationActions(ctx); err != nil {
 			return err
 		}
+		sm.activated.remove(rep.State)
 	}
-	callEvents(sm.onTransitionedEvents, ctx, Transition{transition.Source, rep.State, transition.Trigger, false})
 	return nil
 }
 
+// transitioning notifies that a transition has started.
+func (sm *StateMachine) transitioning(ctx context.Context, transition Transition, args ...any) {
+	if len(sm.onTransitioningEvents) != 0 {
+		callEvents(sm.onTransitioningEvents, sm.withEventArgs(ctx, args), transition)
+	}
+}
+
+// transitionFailed notifies that a transition has failed.
+func (sm *StateMachine) transitionFailed(ctx context.Context, transition Transition, err error, args ...any) {
+	if len(sm.onTransitionFailed) == 0 {
+		return
+	}
+	ctx = sm.withEventArgs(ctx, args)
+	for _, fn := range sm.onTransitionFailed {
+		fn(ctx, transition, err)
+	}
+}
+
+// transitioned notifies that a transition has been successfully completed.
+func (sm *StateMachine) transitioned(ctx context.Context, transition Transition, args ...any) {
+	if len(sm.onTransitionedEvents) != 0 {
+		callEvents(sm.onTransitionedEvents, sm.withEventArgs(ctx, args), transition)
+	}
+	sm.subscriptions.publish(ctx, transition)
+	if chain, ok := ctx.Value(fireChainKey{}).(*fireChain); ok {
+		chain.add(transition)
+	}
+}
+
+// stateChanged notifies that the current state has changed.
+func (sm *StateMachine) stateChanged(ctx context.Context, event StateChangedEvent) {
+	if len(sm.onStateChangedEvents) == 0 {
+		return
+	}
+	event.At = sm.now()
+	for _, fn := range sm.onStateChangedEvents {
+		fn(ctx, event)
+	}
+}
+
 func (sm *StateMachine) enterState(ctx context.Context, sr *stateRepresentation, transition Transition, args ...any) (*stateRepresentation, error) {
 	// Enter the new state
 	err := sr.Enter(ctx, transition, args...)
@@ -441,22 +2005,38 @@ func (sm *StateMachine) enterState(ctx context.Context, sr *stateRepresentation,
 	}
 	// Recursively enter substates that have an initial transition
 	if sr.HasInitialState {
+		target := sr.initialTarget()
 		isValidForInitialState := false
-		for _, substate := range sr.Substates {
+		for _, substate := range sr.substates() {
 			// Verify that the target state is a substate
 			// Check if state has substate(s), and if an initial transition(s) has been set up.
-			if substate.State == sr.InitialTransitionTarget {
+			if substate.State == target {
 				isValidForInitialState = true
 				break
 			}
 		}
 		if !isValidForInitialState {
-			panic(fmt.Sprintf("stateless: The target (%v) for the initial transition is not a substate.", sr.InitialTransitionTarget))
+			panic(fmt.Sprintf("stateless: The target (%v) for the initial transition is not a substate.", target))
+		}
+		path := []State{target}
+		if len(sr.InitialTransitionPath) > 0 && sr.InitialTransitionPath[0] == target {
+			path = sr.InitialTransitionPath
+		}
+		source := transition.Destination
+		for i, target := range path {
+			initialTranslation := Transition{Source: transition.Source, Destination: target, Trigger: transition.Trigger, isInitial: true}
+			sr = sm.stateRepresentation(target)
+			sm.transitioning(ctx, Transition{Source: source, Destination: initialTranslation.Destination, Trigger: transition.Trigger}, args...)
+			if i == len(path)-1 {
+				sr, err = sm.enterState(ctx, sr, initialTranslation, args...)
+				break
+			}
+			// The intermediate states of the path don't follow their own initial transitions.
+			if err = sr.Enter(ctx, initialTranslation, args...); err != nil {
+				return nil, err
+			}
+			source = target
 		}
-		initialTranslation := Transition{Source: transition.Source, Destination: sr.InitialTransitionTarget, Trigger: transition.Trigger, isInitial: true}
-		sr = sm.stateRepresentation(sr.InitialTransitionTarget)
-		callEvents(sm.onTransitioningEvents, ctx, Transition{transition.Destination, initialTranslation.Destination, transition.Trigger, false})
-		sr, err = sm.enterState(ctx, sr, initialTranslation, args...)
 	}
 	return sr, err
 }
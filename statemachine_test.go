@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const (
@@ -26,8 +29,8 @@ func TestTransition_IsReentry(t *testing.T) {
 		t    *Transition
 		want bool
 	}{
-		{"TransitionIsNotChange", &Transition{"1", "1", "0", false}, true},
-		{"TransitionIsChange", &Transition{"1", "2", "0", false}, false},
+		{"TransitionIsNotChange", &Transition{Source: "1", Destination: "1", Trigger: "0"}, true},
+		{"TransitionIsChange", &Transition{Source: "1", Destination: "2", Trigger: "0"}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -38,6 +41,47 @@ func TestTransition_IsReentry(t *testing.T) {
 	}
 }
 
+func TestTransition_IsInitial(t *testing.T) {
+	tests := []struct {
+		name string
+		t    *Transition
+		want bool
+	}{
+		{"TransitionIsInitial", &Transition{Source: "1", Destination: "2", Trigger: "0", isInitial: true}, true},
+		{"TransitionIsNotInitial", &Transition{Source: "1", Destination: "2", Trigger: "0"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.t.IsInitial(); got != tt.want {
+				t.Errorf("Transition.IsInitial() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransition_Equal(t *testing.T) {
+	tests := []struct {
+		name  string
+		t     *Transition
+		other Transition
+		want  bool
+	}{
+		{"Same", &Transition{Source: "1", Destination: "2", Trigger: "0"}, Transition{Source: "1", Destination: "2", Trigger: "0"}, true},
+		{"IgnoresInitial", &Transition{Source: "1", Destination: "2", Trigger: "0", isInitial: true}, Transition{Source: "1", Destination: "2", Trigger: "0"}, true},
+		{"Zero", &Transition{}, Transition{}, true},
+		{"DifferentSource", &Transition{Source: "1", Destination: "2", Trigger: "0"}, Transition{Source: "3", Destination: "2", Trigger: "0"}, false},
+		{"DifferentDestination", &Transition{Source: "1", Destination: "2", Trigger: "0"}, Transition{Source: "1", Destination: "3", Trigger: "0"}, false},
+		{"DifferentTrigger", &Transition{Source: "1", Destination: "2", Trigger: "0"}, Transition{Source: "1", Destination: "2", Trigger: "3"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.t.Equal(tt.other); got != tt.want {
+				t.Errorf("Transition.Equal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestStateMachine_NewStateMachine(t *testing.T) {
 	sm := NewStateMachine(stateA)
 	if got := sm.MustState(); got != stateA {
@@ -106,6 +150,34 @@ func TestStateMachine_NewStateMachineWithExternalStorageAndArgs(t *testing.T) {
 	}
 }
 
+func TestStateMachine_NewStateMachineWithExternalStorageArgs(t *testing.T) {
+	shards := map[string]State{"s1": stateA, "s2": stateB}
+	shard := func(args []any) string {
+		if len(args) == 0 {
+			return "s1"
+		}
+		return args[0].(string)
+	}
+	sm := NewStateMachineWithExternalStorageArgs(func(_ context.Context, args ...any) (State, error) {
+		return shards[shard(args)], nil
+	}, func(_ context.Context, s State, args ...any) error {
+		shards[shard(args)] = s
+		return nil
+	}, FiringImmediate)
+	sm.Configure(stateA).Permit(triggerX, stateC)
+	sm.Configure(stateB).Permit(triggerX, stateD)
+
+	if err := sm.Fire(triggerX, "s2"); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]State{"s1": stateA, "s2": stateD}; !reflect.DeepEqual(shards, want) {
+		t.Errorf("shards = %v, want %v", shards, want)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("MustState() = %v, want %v", got, stateA)
+	}
+}
+
 func TestStateMachine_Configure_SubstateIsIncludedInCurrentState(t *testing.T) {
 	sm := NewStateMachine(stateB)
 	sm.Configure(stateB).SubstateOf(stateC)
@@ -140,6 +212,45 @@ func TestStateMachine_CanFire(t *testing.T) {
 	}
 }
 
+func TestStateMachine_UnmetGuards(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var entered bool
+	sm.Configure(stateB).
+		PermitWith(triggerX, stateA,
+			GuardDescription{Guard: func(_ context.Context, args ...any) bool { return args[0].(bool) }, Description: "is premium"},
+			GuardDescription{Guard: func(_ context.Context, _ ...any) bool { return false }, Description: "has credit"},
+		).
+		Permit(triggerY, stateA)
+	sm.Configure(stateA).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			entered = true
+			return nil
+		})
+
+	got, err := sm.UnmetGuards(triggerX, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"is premium", "has credit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmetGuards() = %v, want %v", got, want)
+	}
+	got, err = sm.UnmetGuards(triggerX, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"has credit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmetGuards() = %v, want %v", got, want)
+	}
+	for _, trigger := range []Trigger{triggerY, triggerZ} {
+		if got, err := sm.UnmetGuards(trigger); err != nil || len(got) != 0 {
+			t.Errorf("UnmetGuards(%v) = %v, %v, want [], nil", trigger, got, err)
+		}
+	}
+	if entered || sm.MustState() != stateB {
+		t.Error("expected no action to be executed")
+	}
+}
+
 func TestStateMachine_CanFire_StatusError(t *testing.T) {
 	sm := NewStateMachineWithExternalStorage(func(_ context.Context) (State, error) {
 		return nil, errors.New("status error")
@@ -305,6 +416,49 @@ func TestStateMachine_Fire_DiscriminatedByGuard_ChoosesPermitedTransition(t *tes
 	}
 }
 
+func TestStateMachine_Fire_PermitWithPriority(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateB).
+		PermitWithPriority(triggerX, stateA, 1).
+		PermitWithPriority(triggerX, stateD, 10, func(_ context.Context, _ ...any) bool {
+			return true
+		}).
+		Permit(triggerX, stateC)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("MustState() = %v, want %v", got, stateD)
+	}
+}
+
+func TestStateMachine_Fire_PermitWithPriority_GuardNotMet(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateB).
+		Permit(triggerX, stateC).
+		PermitWithPriority(triggerX, stateD, 10, func(_ context.Context, _ ...any) bool {
+			return false
+		}).
+		PermitWithPriority(triggerX, stateA, 5)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("MustState() = %v, want %v", got, stateA)
+	}
+}
+
+func TestStateMachine_Fire_PermitWithPriority_SamePriority(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateB).
+		PermitWithPriority(triggerX, stateA, 1).
+		PermitWithPriority(triggerX, stateC, 1)
+
+	assertPanic(t, func() { sm.Fire(triggerX) })
+}
+
 func TestStateMachine_Fire_SaveError(t *testing.T) {
 	sm := NewStateMachineWithExternalStorage(func(_ context.Context) (State, error) {
 		return stateB, nil
@@ -405,6 +559,17 @@ func TestStateMachine_Fire_ParametersSuppliedToFireArePassedToEntryAction(t *tes
 	}
 }
 
+func TestStateMachine_Fire_NilParameter(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf((*int)(nil)), reflect.TypeOf(""))
+	sm.Configure(stateB).PermitReentry(triggerX)
+
+	if err := sm.Fire(triggerX, nil, "something"); err != nil {
+		t.Fatal(err)
+	}
+	assertPanic(t, func() { sm.Fire(triggerX, nil, nil) })
+}
+
 func TestStateMachine_Fire_ParametersSuppliedToFireArePassedToExitAction(t *testing.T) {
 	sm := NewStateMachine(stateB)
 	sm.SetTriggerParameters(triggerX, reflect.TypeOf(""), reflect.TypeOf(0))
@@ -452,6 +617,114 @@ func TestStateMachine_OnUnhandledTrigger_TheProvidedHandlerIsCalledWithStateAndT
 	}
 }
 
+func TestStateMachine_PermitAny(t *testing.T) {
+	premium := func(_ context.Context, args ...any) bool { return args[0].(bool) }
+	hasCredit := func(_ context.Context, args ...any) bool { return args[1].(bool) }
+	tests := []struct {
+		premium, hasCredit bool
+		want               State
+		unmet              int
+	}{
+		{false, false, stateA, 2},
+		{true, false, stateB, 0},
+		{false, true, stateB, 0},
+		{true, true, stateB, 0},
+	}
+	for _, tt := range tests {
+		sm := NewStateMachine(stateA)
+		sm.Configure(stateA).PermitAny(triggerX, stateB, premium, hasCredit)
+		var unmet []string
+		sm.OnUnhandledTrigger(func(_ context.Context, _ State, _ Trigger, unmetGuards []string) error {
+			unmet = unmetGuards
+			return nil
+		})
+
+		if err := sm.Fire(triggerX, tt.premium, tt.hasCredit); err != nil {
+			t.Fatal(err)
+		}
+		if got := sm.MustState(); got != tt.want {
+			t.Errorf("PermitAny(%v, %v) state = %v, want %v", tt.premium, tt.hasCredit, got, tt.want)
+		}
+		if len(unmet) != tt.unmet {
+			t.Errorf("PermitAny(%v, %v) unmet guards = %v, want %d", tt.premium, tt.hasCredit, unmet, tt.unmet)
+		}
+	}
+}
+
+func TestStateMachine_OnUnhandled_StateHandlerTakesPrecedence(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var machineCalls, stateCalls int
+	sm.OnUnhandledTrigger(func(_ context.Context, _ State, _ Trigger, _ []string) error {
+		machineCalls++
+		return errors.New("machine")
+	})
+	sm.Configure(stateA).Permit(triggerY, stateB)
+	sm.Configure(stateB).
+		SubstateOf(stateC).
+		Permit(triggerY, stateA)
+	sm.Configure(stateC).OnUnhandled(func(_ context.Context, state State, trigger Trigger, _ []string) error {
+		stateCalls++
+		if state != stateB {
+			t.Errorf("state = %v, want %v", state, stateB)
+		}
+		if trigger != triggerX {
+			t.Errorf("trigger = %v, want %v", trigger, triggerX)
+		}
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Errorf("Fire() = %v, want nil", err)
+	}
+	if stateCalls != 1 || machineCalls != 0 {
+		t.Errorf("stateCalls = %d, machineCalls = %d, want 1 and 0", stateCalls, machineCalls)
+	}
+
+	sm.Fire(triggerY)
+	if err := sm.Fire(triggerX); err == nil || err.Error() != "machine" {
+		t.Errorf("Fire() = %v, want %v", err, "machine")
+	}
+	if stateCalls != 1 || machineCalls != 1 {
+		t.Errorf("stateCalls = %d, machineCalls = %d, want 1 and 1", stateCalls, machineCalls)
+	}
+}
+
+func TestStateConfiguration_OnUnhandledTrigger(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var handled []State
+	handler := func(s State) UnhandledTriggerActionFunc {
+		return func(_ context.Context, _ State, _ Trigger, _ []string) error {
+			handled = append(handled, s)
+			return nil
+		}
+	}
+	sm.OnUnhandledTrigger(handler("machine"))
+	sm.Configure(stateA).OnUnhandledTrigger(handler(stateA))
+	sm.Configure(stateB).SubstateOf(stateA).OnUnhandledTrigger(handler(stateB)).Permit(triggerY, stateC)
+	sm.Configure(stateC).SubstateOf(stateA).Permit(triggerY, stateD)
+
+	for _, trigger := range []Trigger{triggerX, triggerY, triggerX, triggerY, triggerX} {
+		if err := sm.Fire(trigger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []State{stateB, stateA, "machine"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+}
+
+func TestStateMachine_OnUnhandled_OtherStatesUseDefault(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerY, stateB)
+	sm.Configure(stateB).OnUnhandled(func(_ context.Context, _ State, _ Trigger, _ []string) error {
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); err == nil {
+		t.Error("expected error from default unhandled trigger action")
+	}
+}
+
 func TestStateMachine_SetTriggerParameters_TriggerParametersAreImmutableOnceSet(t *testing.T) {
 	sm := NewStateMachine(stateB)
 
@@ -460,6 +733,66 @@ func TestStateMachine_SetTriggerParameters_TriggerParametersAreImmutableOnceSet(
 	assertPanic(t, func() { sm.SetTriggerParameters(triggerX, reflect.TypeOf(""), reflect.TypeOf(0)) })
 }
 
+func TestStateMachine_SetTriggerParametersVariadic(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).PermitReentry(triggerX)
+	sm.SetTriggerParametersVariadic(triggerX, reflect.TypeOf(""), reflect.TypeOf(0))
+
+	for _, args := range [][]any{{"a"}, {"a", 1}, {"a", 1, 2}} {
+		if err := sm.Fire(triggerX, args...); err != nil {
+			t.Errorf("Fire(%v) = %v, want nil", args, err)
+		}
+	}
+	assertPanic(t, func() { sm.Fire(triggerX) })
+	assertPanic(t, func() { sm.Fire(triggerX, "a", 1, "b") })
+	assertPanic(t, func() { sm.SetTriggerParametersVariadic(triggerX, reflect.TypeOf("")) })
+	assertPanic(t, func() { sm.SetTriggerParametersVariadic(triggerY) })
+}
+
+func TestStateMachine_TriggerParameters(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	want := []reflect.Type{reflect.TypeOf(""), reflect.TypeOf(0)}
+	sm.SetTriggerParameters(triggerX, want...)
+
+	got, ok := sm.TriggerParameters(triggerX)
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("TriggerParameters() = %v, %t, want %v, true", got, ok, want)
+	}
+	got[0] = reflect.TypeOf(0)
+	if got, _ := sm.TriggerParameters(triggerX); !reflect.DeepEqual(got, want) {
+		t.Errorf("TriggerParameters() = %v, want %v", got, want)
+	}
+	if got, ok := sm.TriggerParameters(triggerY); ok || got != nil {
+		t.Errorf("TriggerParameters() = %v, %t, want nil, false", got, ok)
+	}
+}
+
+func TestStateMachine_ResetTriggerParameters(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).PermitReentry(triggerX)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(""))
+
+	sm.ResetTriggerParameters(triggerX)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(0))
+
+	if err := sm.Fire(triggerX, 1); err != nil {
+		t.Error(err)
+	}
+	assertPanic(t, func() { sm.Fire(triggerX, "1") })
+}
+
+func TestStateMachine_ResetTriggerParameters_WhileFiring(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(""))
+	sm.Configure(stateA).Permit(triggerY, stateB)
+	sm.Configure(stateB).OnEntry(func(_ context.Context, _ ...any) error {
+		assertPanic(t, func() { sm.ResetTriggerParameters(triggerX) })
+		return nil
+	})
+
+	sm.Fire(triggerY)
+}
+
 func TestStateMachine_SetTriggerParameters_Interfaces(t *testing.T) {
 	sm := NewStateMachine(stateB)
 	sm.SetTriggerParameters(triggerX, reflect.TypeOf((*error)(nil)).Elem())
@@ -524,6 +857,48 @@ func TestStateMachine_OnTransitioned_EventFires(t *testing.T) {
 	}
 }
 
+func TestStateMachine_OnTransitionFailed(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	entryErr := errors.New("entry failed")
+	var (
+		failed    []Transition
+		errs      []error
+		succeeded int
+	)
+	sm.OnTransitioned(func(_ context.Context, _ Transition) {
+		succeeded++
+	})
+	sm.OnTransitionFailed(func(_ context.Context, tr Transition, err error) {
+		failed = append(failed, tr)
+		errs = append(errs, err)
+	})
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		PermitReentry(triggerY)
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			return entryErr
+		})
+
+	sm.Fire(triggerY)
+	if err := sm.Fire(triggerX); !errors.Is(err, entryErr) {
+		t.Fatalf("error = %v, want %v", err, entryErr)
+	}
+	if want := []Transition{{Source: stateA, Destination: stateB, Trigger: triggerX}}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed = %v, want %v", failed, want)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], entryErr) {
+		t.Errorf("errs = %v, want [%v]", errs, entryErr)
+	}
+	if succeeded != 1 {
+		t.Errorf("succeeded = %d, want 1", succeeded)
+	}
+	// There is no rollback.
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+}
+
 func TestStateMachine_OnTransitioned_EventFiresBeforeTheOnEntryEvent(t *testing.T) {
 	sm := NewStateMachine(stateB)
 	expectedOrdering := []string{"OnExit", "OnTransitioning", "OnEntry", "OnTransitioned"}
@@ -632,6 +1007,44 @@ func TestStateMachine_Fire_IgnoreVsPermitReentryFrom(t *testing.T) {
 	}
 }
 
+func TestStateMachine_Fire_PermitReentryFromSubstate_OnEntryFrom(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var actions []string
+	record := func(s string) ActionFunc {
+		return func(ctx context.Context, args ...any) error {
+			if trigger, ok := GetTrigger(ctx); !ok || trigger != triggerX {
+				t.Errorf("%s: GetTrigger() = %v, %v, want %v, true", s, trigger, ok, triggerX)
+			}
+			if len(args) != 1 || args[0] != "arg" {
+				t.Errorf("%s: args = %v, want [arg]", s, args)
+			}
+			actions = append(actions, s)
+			return nil
+		}
+	}
+	sm.Configure(stateA).
+		InitialTransition(stateB).
+		PermitReentry(triggerX).
+		Ignore(triggerY).
+		OnEntryFrom(triggerX, record("enterA")).
+		OnEntryFrom(triggerY, record("enterA from Y"))
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		OnEntryFrom(triggerX, record("enterB")).
+		OnEntryFrom(triggerY, record("enterB from Y"))
+
+	if err := sm.Fire(triggerX, "arg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire(triggerY, "arg"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"enterA", "enterB"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+}
+
 func TestStateMachine_Fire_IgnoreVsPermitReentryExitWith(t *testing.T) {
 	sm := NewStateMachine(stateA)
 	var calls int
@@ -792,6 +1205,42 @@ func TestStateMachine_Fire_PermitDyanmic_Error(t *testing.T) {
 	}
 }
 
+func TestStateMachine_Fire_PermitDynamicDest(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		PermitDynamicDest(triggerX, func(_ context.Context, args ...any) (State, error) {
+			return args[0], nil
+		}, []State{stateB, stateC})
+	sm.Configure(stateB).
+		Permit(triggerX, stateA)
+
+	transitions, err := sm.PermittedTransitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 1 || !reflect.DeepEqual(transitions[0].PossibleDestinations, []State{stateB, stateC}) {
+		t.Errorf("PermittedTransitions() = %v, want possible destinations [B C]", transitions)
+	}
+
+	if err := sm.Fire(triggerX, stateD); !errors.Is(err, ErrUndeclaredDestination) {
+		t.Errorf("error = %v, want %v", err, ErrUndeclaredDestination)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateA)
+	}
+	if err := sm.Fire(triggerX, stateB); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateB)
+	}
+	assertPanic(t, func() {
+		sm.Configure(stateB).PermitDynamicDest(triggerY, func(_ context.Context, _ ...any) (State, error) {
+			return stateA, nil
+		}, nil)
+	})
+}
+
 func TestStateMachine_Fire_PanicsWhenPermitDyanmicIfHasMultipleNonExclusiveGuards(t *testing.T) {
 	sm := NewStateMachine(stateA)
 	sm.SetTriggerParameters(triggerX, reflect.TypeOf(0))
@@ -812,6 +1261,40 @@ func TestStateMachine_Fire_PanicsWhenPermitDyanmicIfHasMultipleNonExclusiveGuard
 	assertPanic(t, func() { sm.Fire(triggerX, 2) })
 }
 
+func TestStateMachine_SetGuardResolution_FirstMatch(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetGuardResolution(FirstMatch)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(0))
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, func(_ context.Context, args ...any) bool { return args[0].(int)%2 == 0 }).
+		Permit(triggerX, stateC, func(_ context.Context, args ...any) bool { return args[0].(int) == 2 }).
+		PermitDynamic(triggerX, func(_ context.Context, _ ...any) (State, error) {
+			return stateD, nil
+		})
+
+	if err := sm.Fire(triggerX, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateB)
+	}
+
+	sm = NewStateMachine(stateA)
+	sm.SetGuardResolution(FirstMatch)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(0))
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, func(_ context.Context, args ...any) bool { return args[0].(int)%2 == 0 }).
+		PermitDynamic(triggerX, func(_ context.Context, _ ...any) (State, error) {
+			return stateD, nil
+		})
+	if err := sm.Fire(triggerX, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateD)
+	}
+}
+
 func TestStateMachine_Fire_TransitionWhenPermitIfHasMultipleExclusiveGuardsWithSuperStateTrue(t *testing.T) {
 	sm := NewStateMachine(stateB)
 	sm.SetTriggerParameters(triggerX, reflect.TypeOf(0))
@@ -1011,19 +1494,53 @@ func TestStateMachine_Activate_Idempotent(t *testing.T) {
 	}
 }
 
-func TestStateMachine_Deactivate(t *testing.T) {
-	sm := NewStateMachine(stateA)
-
-	expectedOrdering := []string{"DeactivatedA", "DeactivatedC"}
-	var actualOrdering []string
-
-	sm.Configure(stateA).
+func TestStateMachine_Activate_IdempotentPerMachine(t *testing.T) {
+	sm1 := NewStateMachine(stateA)
+	var activations int
+	sm1.Configure(stateA).
 		SubstateOf(stateC).
-		OnDeactivate(func(_ context.Context) error {
-			actualOrdering = append(actualOrdering, "DeactivatedA")
+		OnActive(func(_ context.Context) error {
+			activations++
 			return nil
 		})
-
+	sm1.Configure(stateC).
+		OnActive(func(_ context.Context) error {
+			activations++
+			return nil
+		})
+
+	sm2 := sm1.Clone(stateA)
+
+	sm1.Activate()
+	sm1.Activate()
+	if activations != 2 {
+		t.Errorf("expected 2, got %d", activations)
+	}
+	sm2.Activate()
+	if activations != 4 {
+		t.Errorf("expected 4, got %d", activations)
+	}
+	sm1.Deactivate()
+	sm1.Activate()
+	sm2.Activate()
+	if activations != 6 {
+		t.Errorf("expected 6, got %d", activations)
+	}
+}
+
+func TestStateMachine_Deactivate(t *testing.T) {
+	sm := NewStateMachine(stateA)
+
+	expectedOrdering := []string{"DeactivatedA", "DeactivatedC"}
+	var actualOrdering []string
+
+	sm.Configure(stateA).
+		SubstateOf(stateC).
+		OnDeactivate(func(_ context.Context) error {
+			actualOrdering = append(actualOrdering, "DeactivatedA")
+			return nil
+		})
+
 	sm.Configure(stateC).
 		OnDeactivate(func(_ context.Context) error {
 			actualOrdering = append(actualOrdering, "DeactivatedC")
@@ -1221,6 +1738,71 @@ func TestStateMachine_Fire_ImmediateEntryAProcessedBeforeEnterB(t *testing.T) {
 	}
 }
 
+func TestStateMachine_SetRequireActivation(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetRequireActivation(true)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).Permit(triggerX, stateA)
+
+	if sm.IsActivated() {
+		t.Error("IsActivated() = true, want false")
+	}
+	if err := sm.Fire(triggerX); !errors.Is(err, ErrNotActivated) {
+		t.Errorf("error = %v, want %v", err, ErrNotActivated)
+	}
+	if _, err := sm.FireBatch(context.Background(), []TriggerArg{{Trigger: triggerX}}); !errors.Is(err, ErrNotActivated) {
+		t.Errorf("error = %v, want %v", err, ErrNotActivated)
+	}
+	if err := sm.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if !sm.IsActivated() {
+		t.Error("IsActivated() = false, want true")
+	}
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Deactivate(); err != nil {
+		t.Fatal(err)
+	}
+	if sm.IsActivated() {
+		t.Error("IsActivated() = true, want false")
+	}
+	if err := sm.Fire(triggerX); !errors.Is(err, ErrNotActivated) {
+		t.Errorf("error = %v, want %v", err, ErrNotActivated)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+}
+
+func TestStateMachine_Fire_ImmediateReentrantRejected(t *testing.T) {
+	sm := NewStateMachineWithMode(stateA, FiringImmediate)
+	sm.SetAllowReentrantFire(false)
+
+	var reentrantErr error
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			reentrantErr = sm.FireCtx(ctx, triggerY)
+			return nil
+		}).
+		Permit(triggerY, stateC).
+		PermitCompletion(stateD)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(reentrantErr, ErrReentrantFire) {
+		t.Errorf("error = %v, want %v", reentrantErr, ErrReentrantFire)
+	}
+	// The completion transition is not rejected.
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("state = %v, want %v", got, stateD)
+	}
+}
+
 func TestStateMachine_Fire_QueuedEntryAProcessedBeforeEnterB(t *testing.T) {
 	sm := NewStateMachineWithMode(stateA, FiringQueued)
 
@@ -1429,6 +2011,199 @@ func TestStateMachine_InternalTransition_HandledOnlyOnceInSub(t *testing.T) {
 	}
 }
 
+func TestStateMachine_ConfigureConcurrently(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateB).SubstateOf(stateA)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sm.Configure(stateA).Permit(i, stateC)
+			sm.Configure(stateB).Block(triggerY).Ignore(i)
+			sm.Configure(i).SubstateOf(stateD)
+			sm.SetTriggerParameters(fmt.Sprint("param", i), reflect.TypeOf(i))
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := sm.PermittedTriggers(); err != nil {
+				t.Error(err)
+			}
+			sm.CanFire(i)
+			sm.CommonAncestor(stateB, i)
+			sm.TriggerParameters(fmt.Sprint("param", i))
+		}()
+	}
+	wg.Wait()
+
+	triggers, err := sm.PermittedTriggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 10 {
+		t.Errorf("expected 10 permitted triggers, got %v", triggers)
+	}
+	if n := len(sm.stateConfig[stateD].substates()); n != 10 {
+		t.Errorf("substates of %v = %d, want 10", stateD, n)
+	}
+}
+
+func TestStateConfiguration_SubstateOf_Again(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateB).SubstateOf(stateA).SubstateOf(stateA)
+	if n := len(sm.stateConfig[stateA].substates()); n != 1 {
+		t.Errorf("substates of %v = %d, want 1", stateA, n)
+	}
+	sm.Configure(stateB).SubstateOf(stateC)
+	if n := len(sm.stateConfig[stateA].substates()); n != 0 {
+		t.Errorf("substates of %v = %d, want 0", stateA, n)
+	}
+	if got, ok := sm.Superstate(stateB); !ok || got != stateC {
+		t.Errorf("Superstate(%v) = %v, %v, want %v, true", stateB, got, ok, stateC)
+	}
+}
+
+func TestStateMachine_InternalTransitionDynamic_StaysInternal(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var exited, reported bool
+	sm.Configure(stateA).
+		InternalTransitionDynamic(triggerX, func(_ context.Context, args ...any) (State, bool, error) {
+			return stateC, args[0].(bool), nil
+		})
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			exited = true
+			return nil
+		})
+	sm.OnTransitioned(func(_ context.Context, _ Transition) {
+		reported = true
+	})
+
+	if err := sm.Fire(triggerX, false); err != nil {
+		t.Fatalf("Fire() error = %v", err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("expected %v, got %v", stateB, got)
+	}
+	if exited {
+		t.Error("expected the internal transition not to exit the state")
+	}
+	if reported {
+		t.Error("expected the internal transition not to be reported")
+	}
+}
+
+func TestStateMachine_InternalTransitionDynamic_Escalates(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var exited bool
+	var transition Transition
+	sm.Configure(stateA).
+		InternalTransitionDynamic(triggerX, func(_ context.Context, args ...any) (State, bool, error) {
+			return stateC, args[0].(bool), nil
+		})
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			exited = true
+			return nil
+		})
+	sm.OnTransitioned(func(_ context.Context, tr Transition) {
+		transition = tr
+	})
+
+	if err := sm.Fire(triggerX, true); err != nil {
+		t.Fatalf("Fire() error = %v", err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("expected %v, got %v", stateC, got)
+	}
+	if !exited {
+		t.Error("expected the escalated transition to exit the state")
+	}
+	want := Transition{Source: stateB, Destination: stateC, Trigger: triggerX}
+	if transition != want {
+		t.Errorf("expected %v, got %v", want, transition)
+	}
+}
+
+func TestStateMachine_InternalTransitionDynamic_Error(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	wantErr := errors.New("some error")
+	sm.Configure(stateA).
+		InternalTransitionDynamic(triggerX, func(_ context.Context, _ ...any) (State, bool, error) {
+			return stateB, true, wantErr
+		})
+
+	if err := sm.Fire(triggerX); !errors.Is(err, wantErr) {
+		t.Errorf("expected %v, got %v", wantErr, err)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("expected %v, got %v", stateA, got)
+	}
+}
+
+func TestStateMachine_InitialTransitionPath(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var actions []string
+	record := func(s string) ActionFunc {
+		return func(_ context.Context, _ ...any) error {
+			actions = append(actions, s)
+			return nil
+		}
+	}
+	var transitions []Transition
+	sm.OnTransitioning(func(_ context.Context, tr Transition) {
+		transitions = append(transitions, tr)
+	})
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateC).
+		SubstateOf(stateB).
+		OnEntry(record("enterC"))
+	sm.Configure(stateD).
+		SubstateOf(stateC).
+		OnEntry(record("enterD"))
+	sm.Configure("W").
+		SubstateOf(stateC).
+		OnEntry(record("enterW"))
+	// The initial transition of an intermediate state is not followed.
+	sm.Configure(stateC).
+		InitialTransition("W")
+	sm.Configure(stateB).
+		InitialTransitionPath(stateC, stateD).
+		OnEntry(record("enterB"))
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("MustState() = %v, want %v", got, stateD)
+	}
+	if want := []string{"enterB", "enterC", "enterD"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+	want := []Transition{
+		{Source: stateA, Destination: stateB, Trigger: triggerX},
+		{Source: stateB, Destination: stateC, Trigger: triggerX},
+		{Source: stateC, Destination: stateD, Trigger: triggerX},
+	}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestStateMachine_InitialTransitionPath_Invalid(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateC).SubstateOf(stateB)
+	sm.Configure(stateD).SubstateOf(stateB)
+	assertPanic(t, func() { sm.Configure(stateB).InitialTransitionPath() })
+	assertPanic(t, func() { sm.Configure(stateB).InitialTransitionPath(stateC, stateD) })
+	assertPanic(t, func() { sm.Configure(stateB).InitialTransitionPath("W") })
+	assertPanic(t, func() { sm.Configure(stateA).InitialTransitionPath(stateC) })
+}
+
 func TestStateMachine_InitialTransition_EntersSubState(t *testing.T) {
 	sm := NewStateMachine(stateA)
 
@@ -1576,6 +2351,41 @@ func TestStateMachine_InitialTransition_DoNotAllowMoreThanOneInitialTransition(t
 	assertPanic(t, func() { sm.Configure(stateB).InitialTransition(stateA) })
 }
 
+func TestStateMachine_OnEntryInitial(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var entries []Transition
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		Permit(triggerY, stateC)
+	sm.Configure(stateB).
+		InitialTransition(stateC)
+	sm.Configure(stateC).
+		SubstateOf(stateB).
+		OnEntryInitial(func(ctx context.Context, _ ...any) error {
+			entries = append(entries, GetTransition(ctx))
+			return nil
+		}).
+		Permit(triggerZ, stateA)
+
+	sm.Fire(triggerX)
+	if len(entries) != 1 {
+		t.Fatalf("entries = %d, want 1", len(entries))
+	}
+	if want := (Transition{Source: stateA, Destination: stateC, Trigger: triggerX, isInitial: true}); entries[0] != want {
+		t.Errorf("transition = %v, want %v", entries[0], want)
+	}
+	if !entries[0].IsInitial() {
+		t.Error("IsInitial() = false, want true")
+	}
+
+	// Entering the substate directly doesn't execute the action.
+	sm.Fire(triggerZ)
+	sm.Fire(triggerY)
+	if len(entries) != 1 {
+		t.Errorf("entries = %d, want 1", len(entries))
+	}
+}
+
 func TestStateMachine_String(t *testing.T) {
 	tests := []struct {
 		name string
@@ -1588,6 +2398,9 @@ func TestStateMachine_String(t *testing.T) {
 		}, func(_ context.Context, s State) error { return nil }, FiringImmediate), ""},
 		{"triggers", NewStateMachine(stateB).Configure(stateB).Permit(triggerX, stateA).Machine(),
 			"StateMachine {{ State = B, PermittedTriggers = [X] }}"},
+		{"sorted triggers", NewStateMachine(stateB).Configure(stateB).
+			Permit(triggerZ, stateA).Permit(triggerX, stateA).Permit(triggerY, stateA).Machine(),
+			"StateMachine {{ State = B, PermittedTriggers = [X Y Z] }}"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1613,6 +2426,35 @@ func TestStateMachine_String_Concurrent(t *testing.T) {
 	wg.Wait()
 }
 
+func TestStateMachine_StateString(t *testing.T) {
+	var calls int
+	sm := NewStateMachineWithExternalStorage(func(_ context.Context) (State, error) {
+		calls++
+		return stateB, nil
+	}, func(_ context.Context, s State) error { return nil }, FiringImmediate)
+	sm.Configure(stateB).Permit(triggerX, stateA, func(_ context.Context, _ ...any) bool {
+		t.Error("guard should not be evaluated")
+		return true
+	})
+
+	if got := sm.StateString(); got != stateB {
+		t.Errorf("StateMachine.StateString() = %v, want %v", got, stateB)
+	}
+	if calls != 1 {
+		t.Errorf("state accessor called %d times, want 1", calls)
+	}
+}
+
+func TestStateMachine_StateString_Error(t *testing.T) {
+	sm := NewStateMachineWithExternalStorage(func(_ context.Context) (State, error) {
+		return nil, errors.New("status error")
+	}, func(_ context.Context, s State) error { return nil }, FiringImmediate)
+
+	if got := sm.StateString(); got != "" {
+		t.Errorf("StateMachine.StateString() = %v, want empty", got)
+	}
+}
+
 func TestStateMachine_Firing_Queued(t *testing.T) {
 	sm := NewStateMachine(stateA)
 
@@ -1722,3 +2564,2364 @@ func TestStateMachineWhenInSubstate_TriggerSuperStateTwiceToSameSubstate_DoesNot
 		t.Errorf("expected 1, got %d", eCount)
 	}
 }
+
+func TestStateMachine_PermitCompletion(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).PermitCompletion(stateC)
+
+	var transitions []Transition
+	sm.OnTransitioned(func(_ context.Context, tr Transition) {
+		transitions = append(transitions, tr)
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("MustState() = %v, want %v", got, stateC)
+	}
+	want := []Transition{
+		{Source: stateA, Destination: stateB, Trigger: triggerX},
+		{Source: stateB, Destination: stateC, Trigger: CompletionTrigger},
+	}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestStateMachine_PermitCompletion_Guarded(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		done := false
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.Configure(stateA).Permit(triggerX, stateB)
+		sm.Configure(stateB).
+			PermitCompletion(stateC, func(_ context.Context, _ ...any) bool {
+				return done
+			}).
+			PermitReentry(triggerY)
+
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		if got := sm.MustState(); got != stateB {
+			t.Errorf("MustState() = %v, want %v", got, stateB)
+		}
+		done = true
+		if err := sm.Fire(triggerY); err != nil {
+			t.Fatal(err)
+		}
+		if got := sm.MustState(); got != stateC {
+			t.Errorf("MustState() = %v, want %v", got, stateC)
+		}
+	}
+}
+
+func TestStateMachine_PermitCompletion_AfterEntryActions(t *testing.T) {
+	var actualOrdering []string
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			actualOrdering = append(actualOrdering, "EnteredB")
+			return nil
+		}).
+		OnExit(func(_ context.Context, _ ...any) error {
+			actualOrdering = append(actualOrdering, "ExitedB")
+			return nil
+		}).
+		PermitCompletion(stateC)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"EnteredB", "ExitedB"}
+	if !reflect.DeepEqual(actualOrdering, want) {
+		t.Errorf("actualOrdering = %v, want %v", actualOrdering, want)
+	}
+}
+
+func TestStateMachine_PermitCompletion_Superstate(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateC)
+	sm.Configure(stateB).PermitCompletion(stateD)
+	sm.Configure(stateC).SubstateOf(stateB).Permit(triggerY, "C2")
+	sm.Configure("C2").SubstateOf(stateB)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("MustState() = %v, want %v", got, stateD)
+	}
+
+	// The superstate is not entered again when moving between its substates.
+	sm = sm.Clone(stateC)
+	if err := sm.Fire(triggerY); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != "C2" {
+		t.Errorf("MustState() = %v, want %v", got, "C2")
+	}
+}
+
+func TestStateMachine_FireDetailed(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.Configure(stateA).Permit(triggerX, stateB)
+		sm.Configure(stateB).PermitCompletion(stateC)
+		sm.Configure(stateC).OnEntry(func(ctx context.Context, _ ...any) error {
+			return sm.FireCtx(ctx, triggerY)
+		}).Permit(triggerY, stateD)
+
+		res, err := sm.FireDetailed(triggerX)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Transition{
+			{Source: stateA, Destination: stateB, Trigger: triggerX},
+			{Source: stateB, Destination: stateC, Trigger: CompletionTrigger},
+			{Source: stateC, Destination: stateD, Trigger: triggerY},
+		}
+		if mode == FiringImmediate {
+			// In immediate mode the transition fired from an entry action finishes before the one entering the state.
+			want = []Transition{want[0], want[2], want[1]}
+		}
+		if !reflect.DeepEqual(res.Transitions, want) {
+			t.Errorf("Transitions = %v, want %v", res.Transitions, want)
+		}
+		if res.State != stateD {
+			t.Errorf("State = %v, want %v", res.State, stateD)
+		}
+		if !res.Chained() {
+			t.Error("Chained() = false, want true")
+		}
+	}
+}
+
+func TestStateMachine_FireDetailed_Ignored(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Ignore(triggerX)
+
+	res, err := sm.FireDetailed(triggerX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Transitions) != 0 {
+		t.Errorf("Transitions = %v, want none", res.Transitions)
+	}
+	if res.Chained() {
+		t.Error("Chained() = true, want false")
+	}
+	if res.State != stateA {
+		t.Errorf("State = %v, want %v", res.State, stateA)
+	}
+}
+
+func TestStateMachine_RenameState(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).InitialTransition(stateC).Permit(triggerY, stateA)
+	sm.Configure(stateC).SubstateOf(stateB).PermitReentry(triggerZ)
+
+	if err := sm.RenameState(stateC, stateD); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("MustState() = %v, want %v", got, stateD)
+	}
+	if ok, _ := sm.IsInState(stateB); !ok {
+		t.Errorf("IsInState() = %v, want %v", ok, true)
+	}
+	if err := sm.Fire(triggerZ); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("MustState() = %v, want %v", got, stateD)
+	}
+	if err := sm.Fire(triggerY); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("MustState() = %v, want %v", got, stateA)
+	}
+}
+
+func TestStateMachine_RenameState_LaterReferences(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.PermitFromAll(triggerZ, stateD)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		PermitDynamicDest(triggerY, func(_ context.Context, _ ...any) (State, error) {
+			return "E", nil
+		}, []State{stateD})
+	sm.Configure(stateB).InitialTransitionWithHistory(stateC).Permit(triggerX, stateA)
+	sm.Configure(stateC).SubstateOf(stateB).Permit(triggerY, stateD)
+	sm.Configure(stateD).SubstateOf(stateB)
+
+	for _, trigger := range []Trigger{triggerX, triggerY, triggerX} {
+		if err := sm.Fire(trigger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sm.RenameState(stateD, "E"); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		trigger Trigger
+		want    State
+	}{
+		{triggerX, "E"}, // history
+		{triggerX, stateA},
+		{triggerZ, "E"}, // PermitFromAll
+		{triggerX, stateA},
+		{triggerY, "E"}, // PermitDynamicDest
+	} {
+		if err := sm.Fire(step.trigger); err != nil {
+			t.Fatal(err)
+		}
+		if got := sm.MustState(); got != step.want {
+			t.Errorf("Fire(%v): MustState() = %v, want %v", step.trigger, got, step.want)
+		}
+	}
+}
+
+func TestStateMachine_OverrideGuard_SeveralBehaviours(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	never := func(_ context.Context, _ ...any) bool { return false }
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, never).
+		Permit(triggerX, stateC, never)
+
+	restore := sm.OverrideGuard(stateA, triggerX, true)
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("MustState() = %v, want %v", got, stateB)
+	}
+	restore()
+	if len(sm.stateConfig[stateA].behaviours(triggerX)) != 2 {
+		t.Error("expected the behaviours to be restored")
+	}
+	if sm.stateConfig[stateA].CanHandle(context.Background(), triggerX) {
+		t.Error("expected the original guards to be restored")
+	}
+	assertPanic(t, func() { sm.OverrideGuard(stateD, triggerX, true) })
+	if _, ok := sm.stateConfig[stateD]; ok {
+		t.Errorf("expected %v not to be registered", stateD)
+	}
+}
+
+func TestStateMachine_RenameState_Error(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB)
+
+	if err := sm.RenameState(stateC, stateD); err == nil {
+		t.Error("expected error when renaming a non configured state")
+	}
+	if err := sm.RenameState(stateA, stateB); err == nil {
+		t.Error("expected error when renaming to an already configured state")
+	}
+}
+
+func TestStateMachine_DeprecateTrigger(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).PermitReentry(triggerX).PermitReentry(triggerY)
+	sm.DeprecateTrigger(triggerX, "use Y instead")
+
+	var notices []string
+	sm.OnDeprecatedTrigger(func(_ context.Context, trigger Trigger, message string) {
+		notices = append(notices, fmt.Sprintf("%v: %s", trigger, message))
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.Fire(triggerY); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"X: use Y instead"}
+	if !reflect.DeepEqual(notices, want) {
+		t.Errorf("notices = %v, want %v", notices, want)
+	}
+}
+
+func isAdmin(_ context.Context, _ ...any) bool { return true }
+
+func hasFunds(_ context.Context, _ ...any) bool { return false }
+
+func TestStateMachine_GuardsFor(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).
+		Permit(triggerX, stateD, isAdmin)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Permit(triggerX, stateC, isAdmin, hasFunds).
+		Ignore(triggerX, hasFunds).
+		Permit(triggerY, stateC)
+
+	got := sm.GuardsFor(stateB, triggerX)
+	want := []TransitionInfo{
+		{Trigger: triggerX, Source: stateB, Destination: stateC, GuardDescriptions: []string{"isAdmin", "hasFunds"}, Kind: KindTransitioning},
+		{Trigger: triggerX, Source: stateB, GuardDescriptions: []string{"hasFunds"}, Kind: KindIgnored},
+		{Trigger: triggerX, Source: stateA, Destination: stateD, GuardDescriptions: []string{"isAdmin"}, Kind: KindTransitioning},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GuardsFor() = %v, want %v", got, want)
+	}
+	if got := sm.GuardsFor(stateB, triggerY); len(got) != 1 || got[0].GuardDescriptions != nil {
+		t.Errorf("GuardsFor() = %v, want a single unguarded transition", got)
+	}
+	if got := sm.GuardsFor(stateD, triggerX); got != nil {
+		t.Errorf("GuardsFor() = %v, want nil", got)
+	}
+}
+
+func TestStateMachine_AllGuardDescriptions(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).
+		Permit(triggerX, stateD, isAdmin)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Permit(triggerX, stateC, isAdmin, hasFunds).
+		Ignore(triggerZ, hasFunds).
+		Permit(triggerY, stateC)
+
+	got := sm.AllGuardDescriptions()
+	want := map[Trigger][]string{
+		triggerX: {"hasFunds", "isAdmin"},
+		triggerZ: {"hasFunds"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AllGuardDescriptions() = %v, want %v", got, want)
+	}
+}
+
+func TestStateMachine_QueueLen(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var lens []int
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		Permit(triggerY, stateC).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			lens = append(lens, sm.QueueLen())
+			sm.FireCtx(ctx, triggerY)
+			sm.FireCtx(ctx, triggerZ)
+			lens = append(lens, sm.QueueLen())
+			return nil
+		})
+	sm.Configure(stateC).
+		Ignore(triggerZ)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(lens, want) {
+		t.Errorf("QueueLen() = %v, want %v", lens, want)
+	}
+	if got := sm.QueueLen(); got != 0 {
+		t.Errorf("QueueLen() = %d, want 0", got)
+	}
+	if got := NewStateMachineWithMode(stateA, FiringImmediate).QueueLen(); got != 0 {
+		t.Errorf("QueueLen() = %d, want 0", got)
+	}
+}
+
+func TestStateMachine_SetMaxQueueDepth(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetMaxQueueDepth(2)
+	sm.Configure(stateA).
+		PermitReentry(triggerX).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			// Each reentry fires two triggers, so the queue grows until it is full.
+			if err := sm.FireCtx(ctx, triggerX); err != nil {
+				return err
+			}
+			return sm.FireCtx(ctx, triggerX)
+		})
+
+	if err := sm.Fire(triggerX); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestStateMachine_SetMaxChainLength(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.SetMaxChainLength(3)
+		sm.Configure(stateA).
+			Permit(triggerX, stateB).
+			PermitCompletion(stateB)
+		sm.Configure(stateB).
+			PermitCompletion(stateA)
+
+		err := sm.Fire(triggerX)
+		if !errors.Is(err, ErrChainTooLong) {
+			t.Fatalf("mode %d: error = %v, want ErrChainTooLong", mode, err)
+		}
+		want := "stateless: transition chain too long: A -> B -> A -> B -> A"
+		if err.Error() != want {
+			t.Errorf("mode %d: error = %q, want %q", mode, err, want)
+		}
+	}
+}
+
+func TestStateMachine_SetMaxChainLength_WithinLimit(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetMaxChainLength(2)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).PermitCompletion(stateC)
+	sm.Configure(stateC).PermitCompletion(stateD)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateD)
+	}
+}
+
+func TestStateMachine_SetSynchronous(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.SetSynchronous(true)
+		var active, overlaps, entries atomic.Int32
+		action := func(ctx context.Context, _ ...any) error {
+			if active.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			defer active.Add(-1)
+			entries.Add(1)
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		sm.Configure(stateA).
+			OnEntry(action).
+			Permit(triggerX, stateB)
+		sm.Configure(stateB).
+			OnEntry(func(ctx context.Context, args ...any) error {
+				if err := action(ctx, args...); err != nil {
+					return err
+				}
+				// Firing with the received context doesn't deadlock.
+				return sm.FireCtx(ctx, triggerY)
+			}).
+			Permit(triggerY, stateA)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sm.Fire(triggerX)
+			}()
+		}
+		wg.Wait()
+		if n := overlaps.Load(); n != 0 {
+			t.Errorf("%v: overlapping actions = %d, want 0", mode, n)
+		}
+		if n := entries.Load(); n != 20 {
+			t.Errorf("%v: entries = %d, want 20", mode, n)
+		}
+	}
+}
+
+func TestStateMachine_SetTransitionTimeout(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTransitionTimeout(10 * time.Millisecond)
+	var hasDeadline, secondCalled bool
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			_, hasDeadline = ctx.Deadline()
+			<-ctx.Done()
+			return nil
+		}).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			secondCalled = true
+			return nil
+		})
+
+	err := sm.Fire(triggerX)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if !hasDeadline {
+		t.Error("expected the action context to have a deadline")
+	}
+	if secondCalled {
+		t.Error("expected the second entry action not to be called")
+	}
+}
+
+func TestStateMachine_SetTransitionTimeout_LastAction(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTransitionTimeout(10 * time.Millisecond)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).OnEntry(func(ctx context.Context, _ ...any) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestStateMachine_SetTransitionTimeout_Reentry(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTransitionTimeout(10 * time.Millisecond)
+	var entered, transitioning bool
+	sm.OnTransitioning(func(_ context.Context, _ Transition) {
+		transitioning = true
+	})
+	sm.Configure(stateA).
+		PermitReentry(triggerX).
+		OnExit(func(ctx context.Context, _ ...any) error {
+			<-ctx.Done()
+			return nil
+		}).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			entered = true
+			return nil
+		})
+
+	err := sm.Fire(triggerX)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if entered || transitioning {
+		t.Errorf("entered = %t, transitioning = %t, want false", entered, transitioning)
+	}
+}
+
+func TestStateMachine_FireTimeout(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+
+	if err := sm.FireTimeout(triggerX, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("expected %v, got %v", stateB, got)
+	}
+}
+
+func TestStateMachine_FireTimeout_Exceeded(t *testing.T) {
+	var mutated bool
+	sm := NewStateMachineWithExternalStorage(func(_ context.Context) (State, error) {
+		return stateA, nil
+	}, func(_ context.Context, _ State) error {
+		mutated = true
+		return nil
+	}, FiringImmediate)
+	var hasDeadline bool
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		OnExit(func(ctx context.Context, _ ...any) error {
+			_, hasDeadline = ctx.Deadline()
+			<-ctx.Done()
+			return nil
+		})
+
+	err := sm.FireTimeout(triggerX, 10*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if !hasDeadline {
+		t.Error("expected the action context to have a deadline")
+	}
+	if mutated {
+		t.Error("expected the state mutator not to be called")
+	}
+}
+
+func TestStateMachine_SetTransitionTimeout_Queued(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTransitionTimeout(time.Second)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		Permit(triggerY, stateC).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			return sm.FireCtx(ctx, triggerY)
+		})
+	var entered bool
+	sm.Configure(stateC).OnEntry(func(ctx context.Context, _ ...any) error {
+		entered = ctx.Err() == nil
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if !entered {
+		t.Error("expected the queued transition to run with a live context")
+	}
+}
+
+func TestStateMachine_OverrideEntryActions(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var calls []string
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			calls = append(calls, "real")
+			return nil
+		}).
+		OnEntryFrom(triggerX, func(_ context.Context, _ ...any) error {
+			calls = append(calls, "realFrom")
+			return nil
+		})
+
+	sm.OverrideEntryActions(stateB, func(_ context.Context, _ ...any) error {
+		calls = append(calls, "fake")
+		return nil
+	})
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fake"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestStateMachine_ClearEntryActions(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).OnEntry(func(_ context.Context, _ ...any) error {
+		return errors.New("network unreachable")
+	})
+
+	sm.ClearEntryActions(stateB)
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateB)
+	}
+}
+
+func TestStateMachine_OverrideEntryActions_Firing(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).OnEntry(func(_ context.Context, _ ...any) error {
+		assertPanic(t, func() { sm.ClearEntryActions(stateB) })
+		return nil
+	})
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateMachine_SetBroadcastTrigger(t *testing.T) {
+	sm := NewStateMachine(stateC)
+	sm.SetBroadcastTrigger(triggerX)
+	var handledIn []State
+	handler := func(state State) ActionFunc {
+		return func(_ context.Context, _ ...any) error {
+			handledIn = append(handledIn, state)
+			return nil
+		}
+	}
+	sm.Configure(stateA).
+		InternalTransition(triggerX, handler(stateA))
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		InternalTransition(triggerX, handler(stateB), func(_ context.Context, _ ...any) bool { return false })
+	sm.Configure(stateC).
+		SubstateOf(stateB).
+		InternalTransition(triggerX, handler(stateC))
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if want := []State{stateC, stateA}; !reflect.DeepEqual(handledIn, want) {
+		t.Errorf("handledIn = %v, want %v", handledIn, want)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateC)
+	}
+}
+
+func TestStateMachine_SetArgCloner(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetArgCloner(func(args []any) []any {
+		cloned := make([]any, len(args))
+		for i, arg := range args {
+			if s, ok := arg.([]int); ok {
+				arg = append([]int(nil), s...)
+			}
+			cloned[i] = arg
+		}
+		return cloned
+	})
+	var got []int
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		Permit(triggerY, stateC).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			ids := []int{1, 2}
+			if err := sm.FireCtx(ctx, triggerY, ids); err != nil {
+				return err
+			}
+			ids[0] = 3
+			return nil
+		})
+	sm.Configure(stateC).OnEntry(func(_ context.Context, args ...any) error {
+		got = args[0].([]int)
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStateMachine_SetReportInternalTransitions(t *testing.T) {
+	for _, report := range []bool{false, true} {
+		sm := NewStateMachine(stateA)
+		sm.SetReportInternalTransitions(report)
+		sm.Configure(stateA).InternalTransition(triggerX, func(_ context.Context, _ ...any) error {
+			return nil
+		})
+		var transitioning, transitioned []Transition
+		sm.OnTransitioning(func(_ context.Context, tr Transition) {
+			transitioning = append(transitioning, tr)
+		})
+		sm.OnTransitioned(func(_ context.Context, tr Transition) {
+			transitioned = append(transitioned, tr)
+		})
+
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		if !report {
+			if len(transitioning) != 0 || len(transitioned) != 0 {
+				t.Errorf("expected no reported transitions, got %v and %v", transitioning, transitioned)
+			}
+			continue
+		}
+		if len(transitioning) != 1 || len(transitioned) != 1 {
+			t.Fatalf("expected one reported transition, got %v and %v", transitioning, transitioned)
+		}
+		want := Transition{Source: stateA, Destination: stateA, Trigger: triggerX}
+		if tr := transitioned[0]; !tr.Equal(want) || !tr.IsInternal() {
+			t.Errorf("transition = %v, want internal %v", tr, want)
+		}
+	}
+}
+
+func TestStateMachine_Block(t *testing.T) {
+	sm := NewStateMachine(stateC)
+	sm.Configure(stateA).
+		Permit(triggerX, stateD).
+		Permit(triggerY, stateD)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Block(triggerX)
+	sm.Configure(stateC).
+		SubstateOf(stateB)
+
+	if ok, _ := sm.CanFire(triggerX); ok {
+		t.Error("expected the blocked trigger not to be permitted")
+	}
+	if ok, _ := sm.CanFire(triggerY); !ok {
+		t.Error("expected the non-blocked trigger to be permitted")
+	}
+	if got, _ := sm.PermittedTriggers(); !reflect.DeepEqual(got, []Trigger{triggerY}) {
+		t.Errorf("PermittedTriggers() = %v, want %v", got, []Trigger{triggerY})
+	}
+	if err := sm.Fire(triggerX); err == nil {
+		t.Error("expected the blocked trigger to be unhandled")
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateC)
+	}
+}
+
+func TestStateMachine_Block_OwnHandler(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).
+		Permit(triggerX, stateD)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Block(triggerX).
+		Permit(triggerX, stateC)
+
+	if got, _ := sm.PermittedTriggers(); !reflect.DeepEqual(got, []Trigger{triggerX}) {
+		t.Errorf("PermittedTriggers() = %v, want %v", got, []Trigger{triggerX})
+	}
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateC)
+	}
+}
+
+func TestStateMachine_SetArgRedactor(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetArgRedactor(func(args []any) any {
+		return []any{args[0], "***"}
+	})
+	var gotArgs []any
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).OnEntry(func(_ context.Context, args ...any) error {
+		gotArgs = args
+		return nil
+	})
+	var events []any
+	sm.OnTransitioning(func(ctx context.Context, _ Transition) {
+		events = append(events, GetEventArgs(ctx))
+	})
+	sm.OnTransitioned(func(ctx context.Context, _ Transition) {
+		events = append(events, GetEventArgs(ctx))
+	})
+
+	if err := sm.Fire(triggerX, "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	redacted := []any{"alice", "***"}
+	if want := []any{redacted, redacted}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if want := []any{"alice", "secret"}; !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("action args = %v, want %v", gotArgs, want)
+	}
+}
+
+func TestStateMachine_GetEventArgs_Default(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	var got any
+	sm.OnTransitioned(func(ctx context.Context, _ Transition) {
+		got = GetEventArgs(ctx)
+	})
+	if err := sm.Fire(triggerX, 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetEventArgs() = %v, want %v", got, want)
+	}
+}
+
+func isOne(_ context.Context, args ...any) bool { return args[0].(int) == 1 }
+
+func TestStateMachine_PermittedTriggersInfo(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).
+		Permit(triggerX, stateD).
+		InternalTransition(triggerZ, func(_ context.Context, _ ...any) error { return nil })
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Permit(triggerX, stateC, isOne).
+		PermitReentry(triggerY).
+		Ignore("W", func(_ context.Context, _ ...any) bool { return false })
+
+	got, err := sm.PermittedTriggersInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TransitionInfo{
+		{Trigger: triggerX, Source: stateB, Destination: stateC, GuardDescriptions: []string{"isOne"}, Kind: KindTransitioning},
+		{Trigger: triggerY, Source: stateB, Destination: stateB, Kind: KindReentry},
+		{Trigger: triggerZ, Source: stateA, Destination: stateA, Kind: KindInternal},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PermittedTriggersInfo() = %v, want %v", got, want)
+	}
+
+	got, _ = sm.PermittedTriggersInfo(2)
+	if len(got) != 3 || got[0].Source != stateA || got[0].Destination != stateD {
+		t.Errorf("PermittedTriggersInfo() = %v, want the superstate transition for %v", got, triggerX)
+	}
+}
+
+func TestStateMachine_PermittedTransitions(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).
+		Permit(triggerX, stateD).
+		Permit(triggerY, stateD)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Permit(triggerX, stateC, isOne).
+		PermitDynamic(triggerZ, func(_ context.Context, _ ...any) (State, error) { return stateC, nil }).
+		Block(triggerY)
+
+	got, err := sm.PermittedTransitionsCtx(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TransitionInfo{
+		{Trigger: triggerX, Source: stateB, Destination: stateC, GuardDescriptions: []string{"isOne"}, Kind: KindTransitioning},
+		{Trigger: triggerZ, Source: stateB, Kind: KindDynamic},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PermittedTransitions() = %v, want %v", got, want)
+	}
+}
+
+func TestStateMachine_PermitOverride(t *testing.T) {
+	sm := NewStateMachine(stateC)
+	sm.Configure(stateA).
+		PermitOverride(triggerX, stateD)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Permit(triggerX, stateA)
+	sm.Configure(stateC).
+		SubstateOf(stateB).
+		Permit(triggerX, stateB)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateD)
+	}
+}
+
+func TestStateMachine_PermitOverride_Guarded(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	admin := false
+	sm.Configure(stateA).
+		PermitOverride(triggerX, stateD, func(_ context.Context, _ ...any) bool { return admin })
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Permit(triggerX, stateC)
+	sm.Configure(stateC).
+		SubstateOf(stateA).
+		Permit(triggerX, stateB)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateC)
+	}
+	admin = true
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("sm.MustState() = %v, want %v", got, stateD)
+	}
+}
+
+func TestStateMachine_SetDropUnhandledQueued(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		sm := NewStateMachine(stateA)
+		sm.SetDropUnhandledQueued(drop)
+		sm.Configure(stateA).Permit(triggerX, stateB)
+		sm.Configure(stateB).
+			Permit(triggerZ, stateC).
+			OnEntry(func(ctx context.Context, _ ...any) error {
+				if err := sm.FireCtx(ctx, triggerY); err != nil {
+					return err
+				}
+				return sm.FireCtx(ctx, triggerZ)
+			})
+
+		err := sm.Fire(triggerX)
+		if drop {
+			if err != nil {
+				t.Errorf("expected the unhandled queued trigger to be dropped, got %v", err)
+			}
+			if got := sm.MustState(); got != stateC {
+				t.Errorf("sm.MustState() = %v, want %v", got, stateC)
+			}
+		} else {
+			if err == nil {
+				t.Error("expected the unhandled queued trigger to fail the chain")
+			}
+			if got := sm.MustState(); got != stateB {
+				t.Errorf("sm.MustState() = %v, want %v", got, stateB)
+			}
+		}
+	}
+}
+
+func TestStateMachine_SetDropUnhandledQueued_External(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetDropUnhandledQueued(true)
+	if err := sm.Fire(triggerX); err == nil {
+		t.Error("expected the unhandled external trigger to return an error")
+	}
+}
+
+func TestStateMachine_PermitReentry_CompositeReentersInitialSubstate(t *testing.T) {
+	for _, initial := range []State{stateB, stateD} {
+		sm := NewStateMachine(initial)
+		var actions []string
+		record := func(s string) ActionFunc {
+			return func(_ context.Context, _ ...any) error {
+				actions = append(actions, s)
+				return nil
+			}
+		}
+		sm.Configure(stateB).
+			InitialTransition(stateC).
+			PermitReentry(triggerX).
+			OnEntry(record("enterB")).
+			OnExit(record("exitB"))
+		sm.Configure(stateC).
+			SubstateOf(stateB).
+			OnEntry(record("enterC")).
+			OnExit(record("exitC"))
+		sm.Configure(stateD).
+			SubstateOf(stateB).
+			OnEntry(record("enterD")).
+			OnExit(record("exitD"))
+
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		if got := sm.MustState(); got != stateC {
+			t.Errorf("initial %v: sm.MustState() = %v, want %v", initial, got, stateC)
+		}
+		want := []string{"exitB", "enterB", "enterC"}
+		if initial == stateD {
+			want = append([]string{"exitD"}, want...)
+		}
+		if !reflect.DeepEqual(actions, want) {
+			t.Errorf("initial %v: actions = %v, want %v", initial, actions, want)
+		}
+	}
+}
+
+func TestStateMachine_HasAnyPermittedTrigger(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	allowed := false
+	sm.Configure(stateA).
+		Permit(triggerX, stateC, func(_ context.Context, _ ...any) bool { return allowed }).
+		Permit(triggerY, stateC)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		Block(triggerY).
+		Ignore(triggerZ, func(_ context.Context, _ ...any) bool { return false })
+
+	if ok, err := sm.HasAnyPermittedTrigger(); err != nil || ok {
+		t.Errorf("HasAnyPermittedTrigger() = %v, %v, want false", ok, err)
+	}
+	allowed = true
+	if ok, err := sm.HasAnyPermittedTrigger(); err != nil || !ok {
+		t.Errorf("HasAnyPermittedTrigger() = %v, %v, want true", ok, err)
+	}
+}
+
+func newManyGuardedTriggers() *StateMachine {
+	sm := NewStateMachine(stateA)
+	sc := sm.Configure(stateA)
+	for i := 0; i < 100; i++ {
+		sc.Permit(i, stateB, func(_ context.Context, _ ...any) bool { return true })
+	}
+	return sm
+}
+
+func BenchmarkStateMachine_HasAnyPermittedTrigger(b *testing.B) {
+	sm := newManyGuardedTriggers()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = sm.HasAnyPermittedTrigger()
+	}
+}
+
+func BenchmarkStateMachine_PermittedTriggers_Len(b *testing.B) {
+	sm := newManyGuardedTriggers()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		triggers, _ := sm.PermittedTriggers()
+		_ = len(triggers) > 0
+	}
+}
+
+func TestStateMachine_FilterFireable(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	even := func(_ context.Context, args ...any) bool { return args[0].(int)%2 == 0 }
+	large := func(_ context.Context, args ...any) bool { return args[0].(int) > 4 }
+	sm.Configure(stateA).Permit(triggerX, stateC, large)
+	sm.Configure(stateB).SubstateOf(stateA).Permit(triggerX, stateD, even)
+
+	got, err := sm.FilterFireable(context.Background(), triggerX, []any{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{2, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterFireable() = %v, want %v", got, want)
+	}
+	for _, arg := range []any{1, 2, 3, 4, 5, 6} {
+		ok, _ := sm.CanFire(triggerX, arg)
+		accepted := false
+		for _, a := range got {
+			accepted = accepted || a == arg
+		}
+		if ok != accepted {
+			t.Errorf("CanFire(%v) = %v, but FilterFireable accepted = %v", arg, ok, accepted)
+		}
+	}
+}
+
+func newFilterFireable() (*StateMachine, []any) {
+	sm := NewStateMachine(stateD)
+	sm.Configure(stateA).Permit(triggerX, stateB, func(_ context.Context, args ...any) bool { return args[0].(int)%2 == 0 })
+	sm.Configure(stateC).SubstateOf(stateA)
+	sm.Configure(stateD).SubstateOf(stateC)
+	candidates := make([]any, 100)
+	for i := range candidates {
+		candidates[i] = i
+	}
+	return sm, candidates
+}
+
+func BenchmarkStateMachine_FilterFireable(b *testing.B) {
+	sm, candidates := newFilterFireable()
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = sm.FilterFireable(ctx, triggerX, candidates)
+	}
+}
+
+func BenchmarkStateMachine_CanFire_Candidates(b *testing.B) {
+	sm, candidates := newFilterFireable()
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var accepted []any
+		for _, arg := range candidates {
+			if ok, _ := sm.CanFireCtx(ctx, triggerX, arg); ok {
+				accepted = append(accepted, arg)
+			}
+		}
+		_ = accepted
+	}
+}
+
+func TestStateMachine_SetAutoDeactivateOnExit(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		sm := NewStateMachine(stateC)
+		sm.SetAutoDeactivateOnExit(enabled)
+		var actions []string
+		deactivate := func(s string) func(context.Context) error {
+			return func(_ context.Context) error {
+				actions = append(actions, "deactivate"+s)
+				return nil
+			}
+		}
+		sm.Configure(stateA).
+			OnDeactivate(deactivate("A")).
+			OnExit(func(_ context.Context, _ ...any) error {
+				actions = append(actions, "exitA")
+				return nil
+			})
+		sm.Configure(stateB).
+			SubstateOf(stateA).
+			OnDeactivate(deactivate("B")).
+			Permit(triggerX, stateD)
+		sm.Configure(stateC).
+			SubstateOf(stateB).
+			OnDeactivate(deactivate("C"))
+		sm.Configure(stateD).
+			SubstateOf("E")
+
+		if err := sm.Activate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"exitA"}
+		if enabled {
+			want = append(want, "deactivateC", "deactivateB", "deactivateA")
+		}
+		if !reflect.DeepEqual(actions, want) {
+			t.Errorf("enabled %v: actions = %v, want %v", enabled, actions, want)
+		}
+	}
+}
+
+func TestStateMachine_SetAutoDeactivateOnExit_KeepsCommonSuperstate(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.SetAutoDeactivateOnExit(true)
+	var actions []string
+	sm.Configure(stateA).
+		OnDeactivate(func(_ context.Context) error {
+			actions = append(actions, "deactivateA")
+			return nil
+		})
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		OnDeactivate(func(_ context.Context) error {
+			actions = append(actions, "deactivateB")
+			return nil
+		}).
+		Permit(triggerX, stateC)
+	sm.Configure(stateC).
+		SubstateOf(stateA)
+
+	sm.Activate()
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"deactivateB"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+}
+
+func TestGetTrigger(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var triggers []Trigger
+	record := func(ctx context.Context, _ ...any) error {
+		if trigger, ok := GetTrigger(ctx); ok {
+			triggers = append(triggers, trigger)
+		}
+		return nil
+	}
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		OnExit(record).
+		OnActive(func(ctx context.Context) error {
+			if _, ok := GetTrigger(ctx); ok {
+				t.Error("expected no trigger in activation actions")
+			}
+			return nil
+		})
+	sm.Configure(stateB).
+		Permit(triggerY, stateA).
+		InternalTransition(triggerZ, record).
+		OnEntry(record)
+	sm.Configure(stateA).OnEntry(record)
+
+	sm.Activate()
+	sm.Fire(triggerX)
+	sm.Fire(triggerZ)
+	sm.Fire(triggerY)
+	want := []Trigger{triggerX, triggerX, triggerZ, triggerY}
+	if !reflect.DeepEqual(triggers, want) {
+		t.Errorf("triggers = %v, want %v", triggers, want)
+	}
+}
+
+func TestGetRootTrigger(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		var roots []Trigger
+		record := func(ctx context.Context, _ ...any) error {
+			if root, ok := GetRootTrigger(ctx); ok {
+				roots = append(roots, root)
+			}
+			return nil
+		}
+		sm.Configure(stateA).Permit(triggerX, stateB)
+		sm.Configure(stateB).
+			Permit(triggerY, stateC).
+			OnEntry(record).
+			OnEntry(func(ctx context.Context, _ ...any) error {
+				return sm.FireCtx(ctx, triggerY)
+			})
+		sm.Configure(stateC).
+			PermitCompletion(stateD).
+			OnEntry(record)
+		sm.Configure(stateD).
+			OnEntry(record)
+
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		if want := []Trigger{triggerX, triggerX, triggerX}; !reflect.DeepEqual(roots, want) {
+			t.Errorf("mode %d: roots = %v, want %v", mode, roots, want)
+		}
+		if _, ok := GetRootTrigger(context.Background()); ok {
+			t.Error("expected no root trigger")
+		}
+	}
+}
+
+func TestStateMachine_OnReentry(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var actions []string
+	record := func(s string) ActionFunc {
+		return func(_ context.Context, _ ...any) error {
+			actions = append(actions, s)
+			return nil
+		}
+	}
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		PermitReentry(triggerY).
+		OnEntry(record("entry")).
+		OnEntryExceptReentry(record("init")).
+		OnReentry(record("reentry"))
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"entry", "init"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("first entry actions = %v, want %v", actions, want)
+	}
+	actions = nil
+	if err := sm.Fire(triggerY); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"entry", "reentry"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("reentry actions = %v, want %v", actions, want)
+	}
+}
+
+func TestStateMachine_OnGuardPanic(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, func(_ context.Context, _ ...any) bool {
+			panic("boom")
+		}).
+		Permit(triggerX, stateC, func(_ context.Context, _ ...any) bool {
+			return true
+		})
+
+	var reports []string
+	sm.OnGuardPanic(func(_ context.Context, trigger Trigger, recovered any) {
+		reports = append(reports, fmt.Sprintf("%v: %v", trigger, recovered))
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("state = %v, want %v", got, stateC)
+	}
+	if want := []string{"X: boom"}; !reflect.DeepEqual(reports, want) {
+		t.Errorf("reports = %v, want %v", reports, want)
+	}
+}
+
+func TestStateMachine_OnGuardPanic_NotRegistered(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, func(_ context.Context, _ ...any) bool {
+			panic("boom")
+		})
+	assertPanic(t, func() { sm.Fire(triggerX) })
+}
+
+func TestStateMachine_SetTriggerDefault(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(""))
+	sm.SetTriggerDefault(triggerX, "default")
+	var entered []any
+	sm.Configure(stateA).PermitReentry(triggerX)
+	sm.Configure(stateA).OnEntry(func(_ context.Context, args ...any) error {
+		entered = append(entered, args...)
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire(triggerX, "explicit"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{"default", "explicit"}; !reflect.DeepEqual(entered, want) {
+		t.Errorf("entry args = %v, want %v", entered, want)
+	}
+}
+
+func TestStateMachine_SetTriggerDefault_NotSet(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTriggerDefault(triggerY, "default")
+	var entered []any
+	sm.Configure(stateA).PermitReentry(triggerX).OnEntry(func(_ context.Context, args ...any) error {
+		entered = args
+		return nil
+	})
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if len(entered) != 0 {
+		t.Errorf("entry args = %v, want none", entered)
+	}
+}
+
+func TestStateMachine_RemoveSubstate(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).Permit(triggerX, stateC)
+	sm.Configure(stateB).SubstateOf(stateA)
+
+	if ok, _ := sm.CanFire(triggerX); !ok {
+		t.Fatal("expected trigger to be inherited from the superstate")
+	}
+	if err := sm.RemoveSubstate(stateB); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := sm.CanFire(triggerX); ok {
+		t.Error("expected trigger not to be inherited after detaching")
+	}
+	if ok, _ := sm.IsInState(stateA); ok {
+		t.Error("expected state not to be in its former superstate")
+	}
+	for _, sr := range sm.stateConfig[stateA].Substates {
+		if sr.State == stateB {
+			t.Error("expected state to be removed from the superstate substates")
+		}
+	}
+	if err := sm.Fire(triggerX); err == nil {
+		t.Error("expected error firing a trigger no longer handled")
+	}
+}
+
+func TestStateMachine_RemoveSubstate_Error(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA)
+	if err := sm.RemoveSubstate(stateA); err == nil {
+		t.Error("expected error for a state without superstate")
+	}
+	if err := sm.RemoveSubstate(stateB); err == nil {
+		t.Error("expected error for an unconfigured state")
+	}
+}
+
+func TestStateMachine_DefaultOnEntryExit(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var actions []string
+	record := func(s string) ActionFunc {
+		return func(ctx context.Context, _ ...any) error {
+			actions = append(actions, s)
+			return nil
+		}
+	}
+	sm.DefaultOnEntry(func(ctx context.Context, _ ...any) error {
+		actions = append(actions, "default entry")
+		return nil
+	})
+	sm.DefaultOnExit(func(ctx context.Context, _ ...any) error {
+		actions = append(actions, "default exit")
+		return nil
+	})
+	sm.Configure(stateA).Permit(triggerX, stateC).OnExit(record("exit A"))
+	sm.Configure(stateB).OnEntry(record("entry B"))
+	sm.Configure(stateC).SubstateOf(stateB).OnEntry(record("entry C"))
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"default exit", "exit A", "default entry", "entry B", "default entry", "entry C"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+}
+
+func TestStateMachine_DefaultOnEntry_Error(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	entered := false
+	abortErr := errors.New("aborted")
+	sm.DefaultOnEntry(func(_ context.Context, _ ...any) error {
+		return abortErr
+	})
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).OnEntry(func(_ context.Context, _ ...any) error {
+		entered = true
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); !errors.Is(err, abortErr) {
+		t.Errorf("error = %v, want %v", err, abortErr)
+	}
+	if entered {
+		t.Error("expected state entry actions not to run")
+	}
+}
+
+func TestStateMachine_Fire_QueuedContextCanceled(t *testing.T) {
+	sm := NewStateMachineWithMode(stateA, FiringQueued)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			if err := sm.FireCtx(ctx, triggerY); err != nil {
+				return err
+			}
+			cancel()
+			return nil
+		}).
+		Permit(triggerY, stateC)
+	sm.Configure(stateC).Ignore(triggerZ)
+
+	if err := sm.FireCtx(ctx, triggerX); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+
+	// The remaining trigger is processed by the next drain.
+	if err := sm.Fire(triggerZ); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("state = %v, want %v", got, stateC)
+	}
+}
+
+func TestStateMachine_OnStateChanged(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sm := NewStateMachine(stateB)
+	sm.SetClock(func() time.Time { return at })
+	sm.Configure(stateA).
+		Permit(triggerX, stateC).
+		Permit(triggerY, stateD)
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		PermitReentry(triggerZ).
+		InternalTransition("W", func(_ context.Context, _ ...any) error { return nil }).
+		Ignore("V").
+		Block(triggerY)
+
+	var events []StateChangedEvent
+	sm.OnStateChanged(func(_ context.Context, e StateChangedEvent) {
+		events = append(events, e)
+	})
+
+	if err := sm.Fire(triggerZ); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire("W"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire("V"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire(triggerY); err == nil {
+		t.Fatal("expected blocked trigger to be unhandled")
+	}
+	if len(events) != 0 {
+		t.Fatalf("events = %v, want none", events)
+	}
+
+	if err := sm.Fire(triggerX, "arg"); err != nil {
+		t.Fatal(err)
+	}
+	want := []StateChangedEvent{{From: stateB, To: stateC, Trigger: triggerX, At: at, Args: []any{"arg"}}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestStateConfiguration_PermitWith(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	balance := 0
+	sm.Configure(stateA).PermitWith(triggerX, stateB,
+		GuardDescription{Guard: func(_ context.Context, _ ...any) bool { return balance > 0 }, Description: "balance > 0"},
+		GuardDescription{Guard: isAdmin},
+	)
+
+	err := sm.Fire(triggerX)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if want := "[balance > 0]"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %q", err, want)
+	}
+	if got := sm.GuardsFor(stateA, triggerX)[0].GuardDescriptions; !reflect.DeepEqual(got, []string{"balance > 0", "isAdmin"}) {
+		t.Errorf("GuardDescriptions = %v", got)
+	}
+	if graph := sm.ToGraph(); !strings.Contains(graph, "X [balance &gt; 0] [isAdmin]") {
+		t.Errorf("graph doesn't contain the guard descriptions:\n%s", graph)
+	}
+
+	balance = 1
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+}
+
+func TestStateMachine_FireCtxResult(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.Configure(stateA).
+			Permit(triggerX, stateB).
+			Ignore(triggerY)
+		sm.Configure(stateB).
+			InitialTransition(stateC).
+			InternalTransition(triggerZ, func(ctx context.Context, _ ...any) error {
+				// Triggers fired from actions don't change the result.
+				return sm.FireCtx(ctx, triggerY)
+			})
+		sm.Configure(stateC).
+			SubstateOf(stateB).
+			Ignore(triggerY)
+		ctx := context.Background()
+
+		got, err := sm.FireCtxResult(ctx, triggerY)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (Transition{Source: stateA, Destination: stateA, Trigger: triggerY}); got != want {
+			t.Errorf("ignored: got %v, want %v", got, want)
+		}
+		got, err = sm.FireCtxResult(ctx, triggerX)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (Transition{Source: stateA, Destination: stateC, Trigger: triggerX}); got != want {
+			t.Errorf("transition: got %v, want %v", got, want)
+		}
+		got, err = sm.FireCtxResult(ctx, triggerZ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (Transition{Source: stateC, Destination: stateC, Trigger: triggerZ, isInternal: true}); got != want {
+			t.Errorf("internal: got %v, want %v", got, want)
+		}
+		if _, err = sm.FireCtxResult(ctx, triggerX); err == nil {
+			t.Error("expected error for an unhandled trigger")
+		}
+	}
+}
+
+func TestStateMachine_FireCtxHandled(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.Configure(stateA).
+			Permit(triggerX, stateB).
+			Ignore(triggerY)
+		sm.Configure(stateB).
+			PermitReentry(triggerX).
+			InternalTransition(triggerZ, func(ctx context.Context, _ ...any) error {
+				// Triggers fired from actions don't change the result.
+				return sm.FireCtx(ctx, triggerY)
+			}).
+			Ignore(triggerY)
+		ctx := context.Background()
+
+		tests := []struct {
+			trigger Trigger
+			want    bool
+		}{
+			{triggerY, false},
+			{triggerX, true},
+			{triggerX, true},
+			{triggerZ, true},
+			{triggerY, false},
+		}
+		for _, tt := range tests {
+			got, err := sm.FireCtxHandled(ctx, tt.trigger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FireCtxHandled(%v) = %v, want %v", tt.trigger, got, tt.want)
+			}
+		}
+		sm.OnUnhandledTrigger(func(_ context.Context, _ State, _ Trigger, _ []string) error {
+			return nil
+		})
+		if got, err := sm.FireCtxHandled(ctx, "T"); err != nil || got {
+			t.Errorf("FireCtxHandled(T) = %v, %v, want false, nil", got, err)
+		}
+	}
+}
+
+func TestStateMachine_FireBatch(t *testing.T) {
+	for _, mode := range []FiringMode{FiringQueued, FiringImmediate} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		var args []any
+		sm.Configure(stateA).
+			Permit(triggerX, stateB)
+		sm.Configure(stateB).
+			OnEntry(func(_ context.Context, a ...any) error {
+				args = a
+				return nil
+			}).
+			Permit(triggerY, stateC)
+
+		n, err := sm.FireBatch(context.Background(), []TriggerArg{
+			{Trigger: triggerX, Args: []any{1}},
+			{Trigger: triggerY},
+			{Trigger: triggerX},
+			{Trigger: triggerZ},
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if n != 2 {
+			t.Errorf("index = %d, want 2", n)
+		}
+		if got := sm.MustState(); got != stateC {
+			t.Errorf("state = %v, want %v", got, stateC)
+		}
+		if len(args) != 1 || args[0] != 1 {
+			t.Errorf("args = %v, want [1]", args)
+		}
+		if f, ok := sm.mode.(*fireModeQueued); ok && len(f.triggers) != 0 {
+			t.Errorf("queued triggers = %d, want 0", len(f.triggers))
+		}
+	}
+}
+
+func TestStateMachine_FireBatch_Queued(t *testing.T) {
+	sm := NewStateMachineWithMode(stateA, FiringQueued)
+	var order []Trigger
+	sm.OnTransitioned(func(_ context.Context, tr Transition) {
+		order = append(order, tr.Trigger)
+	})
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			// Processed after the whole batch.
+			return sm.FireCtx(ctx, triggerZ)
+		}).
+		Permit(triggerY, stateC)
+	sm.Configure(stateC).
+		Permit(triggerZ, stateA)
+
+	n, err := sm.FireBatch(context.Background(), []TriggerArg{{Trigger: triggerX}, {Trigger: triggerY}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("index = %d, want 2", n)
+	}
+	if want := []Trigger{triggerX, triggerY, triggerZ}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("state = %v, want %v", got, stateA)
+	}
+}
+
+func TestStateMachine_FireBatch_QueuedAfterError(t *testing.T) {
+	sm := NewStateMachineWithMode(stateA, FiringQueued)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			// Processed after the whole batch, and not handled in stateC.
+			return sm.FireCtx(ctx, triggerZ)
+		}).
+		Permit(triggerY, stateC)
+
+	n, err := sm.FireBatch(context.Background(), []TriggerArg{{Trigger: triggerX}, {Trigger: triggerY}})
+	if err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+	if n != 2 {
+		t.Errorf("index = %d, want 2", n)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("state = %v, want %v", got, stateC)
+	}
+}
+
+func TestStateConfiguration_PermitAfter(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	done := make(chan Transition, 1)
+	sm.OnTransitioned(func(_ context.Context, tr Transition) {
+		if tr.Trigger == triggerY {
+			done <- tr
+		}
+	})
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).PermitAfter(triggerY, stateC, 10*time.Millisecond)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case tr := <-done:
+		if want := (Transition{Source: stateB, Destination: stateC, Trigger: triggerY}); tr != want {
+			t.Errorf("transition = %v, want %v", tr, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed trigger not fired")
+	}
+}
+
+func TestStateConfiguration_PermitAfter_CanceledOnExit(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		PermitAfter(triggerY, stateC, 20*time.Millisecond).
+		Permit(triggerZ, stateD)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire(triggerZ); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sm.stateConfig[stateB].timers); n != 0 {
+		t.Errorf("armed timers = %d, want 0", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("state = %v, want %v", got, stateD)
+	}
+}
+
+func TestStateConfiguration_PermitAfter_ExpiredWhileExiting(t *testing.T) {
+	for _, mode := range []FiringMode{FiringImmediate, FiringQueued} {
+		sm := NewStateMachineWithMode(stateA, mode)
+		sm.SetAllowReentrantFire(false)
+		sm.Configure(stateA).Permit(triggerX, stateB)
+		sm.Configure(stateB).
+			PermitAfter(triggerY, stateC, 10*time.Millisecond).
+			Permit(triggerZ, stateD).
+			OnExit(func(_ context.Context, _ ...any) error {
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			})
+		sm.Configure(stateD).Permit(triggerY, stateA)
+
+		if err := sm.Fire(triggerX); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.Fire(triggerZ); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if got := sm.MustState(); got != stateD {
+			t.Errorf("mode %v: state = %v, want %v", mode, got, stateD)
+		}
+	}
+}
+
+func TestStateConfiguration_OnEntryFireAfter(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var entries atomic.Int32
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			entries.Add(1)
+			return nil
+		}).
+		OnEntryFireAfter(triggerY, 5*time.Millisecond).
+		PermitReentry(triggerY).
+		Permit(triggerZ, stateC)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); entries.Load() < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("entries = %d, want at least 3", entries.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := sm.Fire(triggerZ); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateC {
+		t.Errorf("state = %v, want %v", got, stateC)
+	}
+}
+
+func TestStateConfiguration_PermitAfter_InitialState(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	done := make(chan Transition, 1)
+	sm.OnTransitioned(func(_ context.Context, tr Transition) {
+		done <- tr
+	})
+	sm.Configure(stateB).PermitAfter(triggerY, stateC, 10*time.Millisecond)
+
+	if err := sm.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	sr := sm.stateConfig[stateB]
+	sr.timersMu.Lock()
+	if !sr.timersArmed || len(sr.timers) > 1 {
+		t.Errorf("armed timers = %d, want 1", len(sr.timers))
+	}
+	sr.timersMu.Unlock()
+	select {
+	case tr := <-done:
+		if want := (Transition{Source: stateB, Destination: stateC, Trigger: triggerY}); tr != want {
+			t.Errorf("transition = %v, want %v", tr, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed trigger not fired")
+	}
+}
+
+func TestStateConfiguration_InitialTransitionWithHistory(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		InitialTransitionWithHistory(stateC).
+		Permit(triggerX, stateA)
+	sm.Configure(stateC).
+		SubstateOf(stateB).
+		Permit(triggerY, stateD)
+	sm.Configure(stateD).
+		SubstateOf(stateB)
+
+	fire := func(trigger Trigger, want State) {
+		t.Helper()
+		if err := sm.Fire(trigger); err != nil {
+			t.Fatal(err)
+		}
+		if got := sm.MustState(); got != want {
+			t.Fatalf("state = %v, want %v", got, want)
+		}
+	}
+	// First entry falls back to the initial transition.
+	fire(triggerX, stateC)
+	fire(triggerY, stateD)
+	fire(triggerX, stateA)
+	// Reentering resumes the last active substate.
+	fire(triggerX, stateD)
+}
+
+func TestStateConfiguration_PermitE(t *testing.T) {
+	errDB := errors.New("db unavailable")
+	var failing bool
+	balanceOK := func(_ context.Context, _ ...any) (bool, error) {
+		if failing {
+			return false, errDB
+		}
+		return true, nil
+	}
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		PermitE(triggerX, stateB, balanceOK).
+		IgnoreE(triggerY, balanceOK).
+		InternalTransitionE(triggerZ, func(_ context.Context, _ ...any) error { return nil }, balanceOK)
+	sm.Configure(stateB).Permit(triggerX, stateA)
+
+	failing = true
+	for _, trigger := range []Trigger{triggerX, triggerY, triggerZ} {
+		if err := sm.Fire(trigger); !errors.Is(err, errDB) {
+			t.Errorf("Fire(%v) = %v, want %v", trigger, err, errDB)
+		}
+	}
+	if ok, err := sm.CanFire(triggerX); ok || !errors.Is(err, errDB) {
+		t.Errorf("CanFire() = %v, %v, want false, %v", ok, err, errDB)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("state = %v, want %v", got, stateA)
+	}
+	if triggers, _ := sm.PermittedTriggers(); len(triggers) != 0 {
+		t.Errorf("PermittedTriggers() = %v, want none", triggers)
+	}
+
+	failing = false
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+}
+
+func TestStateMachine_Reset(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	ctx, cancel := context.WithCancel(context.Background())
+	var activations int
+	sm.Configure(stateA).
+		OnActive(func(_ context.Context) error {
+			activations++
+			return nil
+		}).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnEntry(func(ctx context.Context, _ ...any) error {
+			if err := sm.Reset(ctx, stateA); err == nil {
+				t.Error("expected error resetting while firing")
+			}
+			sm.FireCtx(ctx, triggerY)
+			cancel()
+			return nil
+		}).
+		Permit(triggerY, stateC)
+
+	if err := sm.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	// Leave triggerY queued.
+	if err := sm.FireCtx(ctx, triggerX); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+
+	if err := sm.Reset(context.Background(), stateA); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("state = %v, want %v", got, stateA)
+	}
+	if n := len(sm.mode.(*fireModeQueued).triggers); n != 0 {
+		t.Errorf("queued triggers = %d, want 0", n)
+	}
+	if err := sm.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if activations != 2 {
+		t.Errorf("activations = %d, want 2", activations)
+	}
+}
+
+func TestStateMachine_PermitFromAll(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.PermitFromAll(triggerX, stateD)
+	sm.Configure(stateB).
+		Permit(triggerX, stateC)
+	sm.Configure(stateC).
+		SubstateOf(stateB)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateD {
+		t.Errorf("state = %v, want %v", got, stateD)
+	}
+	if ok, _ := sm.CanFire(triggerX); ok {
+		t.Error("expected not to permit the transition to the destination itself")
+	}
+	if err := sm.Fire(triggerX); err == nil {
+		t.Error("expected error firing from the destination")
+	}
+
+	// A state-specific behaviour, even inherited, overrides the default.
+	sm = NewStateMachine(stateC)
+	sm.PermitFromAll(triggerX, stateD)
+	sm.Configure(stateB).
+		Permit(triggerX, stateA)
+	sm.Configure(stateC).
+		SubstateOf(stateB)
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("state = %v, want %v", got, stateA)
+	}
+}
+
+func TestStateMachine_PermitFromAll_Guards(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	allowed := false
+	sm.PermitFromAll(triggerX, stateB, func(_ context.Context, _ ...any) bool {
+		return allowed
+	})
+
+	if triggers, _ := sm.PermittedTriggers(); len(triggers) != 0 {
+		t.Errorf("PermittedTriggers() = %v, want none", triggers)
+	}
+	if err := sm.Fire(triggerX); err == nil {
+		t.Error("expected error when the guard is not met")
+	}
+	allowed = true
+	if triggers, _ := sm.PermittedTriggers(); !reflect.DeepEqual(triggers, []Trigger{triggerX}) {
+		t.Errorf("PermittedTriggers() = %v, want %v", triggers, []Trigger{triggerX})
+	}
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+}
+
+func TestStateMachine_Fire_ExitActionAbortsTransition(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var entered, transitioned bool
+	sm.Configure(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			return fmt.Errorf("validation: %w", ErrAbortTransition)
+		}).
+		Permit(triggerX, stateB).
+		PermitReentry(triggerY)
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			entered = true
+			return nil
+		})
+	sm.OnTransitioned(func(_ context.Context, _ Transition) {
+		transitioned = true
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatalf("Fire() = %v, want nil", err)
+	}
+	if err := sm.Fire(triggerY); err != nil {
+		t.Fatalf("Fire() = %v, want nil", err)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("state = %v, want %v", got, stateA)
+	}
+	if entered || transitioned {
+		t.Errorf("entered = %t, transitioned = %t, want false", entered, transitioned)
+	}
+}
+
+func TestStateMachine_Fire_AbortedTransitionNotHandled(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			return ErrAbortTransition
+		}).
+		Permit(triggerX, stateB)
+
+	handled, err := sm.FireCtxHandled(context.Background(), triggerX)
+	if err != nil || handled {
+		t.Errorf("FireCtxHandled() = %t, %v, want false, nil", handled, err)
+	}
+}
+
+func TestStateMachine_Fire_AbortedTransitionKeepsTimers(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var proceed atomic.Bool
+	done := make(chan struct{})
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		OnExit(func(_ context.Context, _ ...any) error {
+			if !proceed.Load() {
+				return ErrAbortTransition
+			}
+			return nil
+		}).
+		PermitAfter(triggerY, stateC, 20*time.Millisecond).
+		Permit(triggerZ, stateD)
+	sm.Configure(stateC).OnEntry(func(_ context.Context, _ ...any) error {
+		close(done)
+		return nil
+	})
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Fire(triggerZ); err != nil {
+		t.Fatal(err)
+	}
+	proceed.Store(true)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed trigger not fired after the aborted transition")
+	}
+}
+
+func TestStateMachine_Fire_AbortedTransitionKeepsRegions(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	on := sm.Configure(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			return ErrAbortTransition
+		}).
+		Permit(triggerX, stateB)
+	region := on.AddRegion("r").InitialState("R1")
+	region.Configure("R1").Permit(triggerY, "R2")
+	region.Configure("R2")
+
+	sm.Fire(triggerY)
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := region.State(context.Background()); err != nil || got != "R2" {
+		t.Errorf("region state = %v, %v, want R2", got, err)
+	}
+}
+
+func TestStateMachine_Fire_SuperstateExitActionAbortsTransition(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var exited bool
+	sm.Configure(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			return ErrAbortTransition
+		})
+	sm.Configure(stateB).
+		SubstateOf(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			exited = true
+			return nil
+		}).
+		Permit(triggerX, stateC)
+
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatalf("Fire() = %v, want nil", err)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+	// There is no rollback of the exit actions already executed.
+	if !exited {
+		t.Error("expected the substate exit action to be executed")
+	}
+}
+
+func TestStateMachine_Fire_PermitWithAction(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	var calls []string
+	sm.OnTransitioning(func(_ context.Context, _ Transition) {
+		calls = append(calls, "transitioning")
+	})
+	sm.Configure(stateA).
+		OnExit(func(_ context.Context, _ ...any) error {
+			calls = append(calls, "exit A")
+			return nil
+		}).
+		PermitWithAction(triggerX, stateB, func(ctx context.Context, args ...any) error {
+			calls = append(calls, "action")
+			want := Transition{Source: stateA, Destination: stateB, Trigger: triggerX}
+			if got := GetTransition(ctx); got != want {
+				t.Errorf("GetTransition() = %v, want %v", got, want)
+			}
+			if state := sm.MustState(); state != stateA {
+				t.Errorf("state = %v, want %v", state, stateA)
+			}
+			if !reflect.DeepEqual(args, []any{1}) {
+				t.Errorf("args = %v, want [1]", args)
+			}
+			return nil
+		})
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			calls = append(calls, "enter B")
+			return nil
+		})
+
+	if err := sm.Fire(triggerX, 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"exit A", "transitioning", "action", "enter B"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if got := sm.MustState(); got != stateB {
+		t.Errorf("state = %v, want %v", got, stateB)
+	}
+}
+
+func TestStateMachine_Fire_PermitWithAction_Error(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	wantErr := errors.New("action error")
+	sm.Configure(stateA).
+		PermitWithAction(triggerX, stateB, func(_ context.Context, _ ...any) error {
+			return wantErr
+		})
+
+	if err := sm.Fire(triggerX); !errors.Is(err, wantErr) {
+		t.Fatalf("Fire() = %v, want %v", err, wantErr)
+	}
+	if got := sm.MustState(); got != stateA {
+		t.Errorf("state = %v, want %v", got, stateA)
+	}
+	assertPanic(t, func() { sm.Configure(stateA).PermitWithAction(triggerY, stateA, nil) })
+}
+
+func TestStateMachine_Fire_UnhandledTriggerError(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, func(_ context.Context, _ ...any) bool { return false })
+
+	err := sm.Fire(triggerX)
+	if !errors.Is(err, ErrGuardNotMet) || errors.Is(err, ErrUnhandledTrigger) {
+		t.Errorf("error = %v, want %v", err, ErrGuardNotMet)
+	}
+	var unhandled *UnhandledTriggerError
+	if !errors.As(err, &unhandled) {
+		t.Fatalf("error = %T, want %T", err, unhandled)
+	}
+	if unhandled.State != stateA || unhandled.Trigger != triggerX || len(unhandled.UnmetGuards) != 1 {
+		t.Errorf("error = %+v, want state %v, trigger %v and one unmet guard", unhandled, stateA, triggerX)
+	}
+
+	err = sm.Fire(triggerY)
+	if !errors.Is(err, ErrUnhandledTrigger) || errors.Is(err, ErrGuardNotMet) {
+		t.Errorf("error = %v, want %v", err, ErrUnhandledTrigger)
+	}
+}
+
+func TestStateMachine_Fire_ActionError(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	wantErr := &ExhaustivenessError{}
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		InternalTransition(triggerY, func(_ context.Context, _ ...any) error {
+			return wantErr
+		})
+	sm.Configure(stateB).
+		OnEntry(func(_ context.Context, _ ...any) error {
+			return wantErr
+		})
+
+	tests := []struct {
+		trigger Trigger
+		want    Transition
+	}{
+		{triggerY, Transition{Source: stateA, Destination: stateA, Trigger: triggerY, isInternal: true}},
+		{triggerX, Transition{Source: stateA, Destination: stateB, Trigger: triggerX}},
+	}
+	for _, tt := range tests {
+		err := sm.Fire(tt.trigger)
+		var actionErr *ActionError
+		if !errors.As(err, &actionErr) {
+			t.Fatalf("error = %T, want %T", err, actionErr)
+		}
+		if actionErr.Transition != tt.want {
+			t.Errorf("transition = %v, want %v", actionErr.Transition, tt.want)
+		}
+		var got *ExhaustivenessError
+		if !errors.As(err, &got) || got != wantErr {
+			t.Errorf("error = %v, want it to wrap %v", err, wantErr)
+		}
+	}
+}
+
+func TestStateMachine_GetStepTransition(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	var steps []Transition
+	record := func(ctx context.Context, _ ...any) error {
+		steps = append(steps, GetStepTransition(ctx))
+		if got := GetTransition(ctx); got.Trigger != GetStepTransition(ctx).Trigger {
+			t.Errorf("transition = %v, want trigger %v", got, GetStepTransition(ctx).Trigger)
+		}
+		return nil
+	}
+	sm.Configure(stateA).
+		OnEntry(record).
+		OnExit(record)
+	sm.Configure("A1").
+		SubstateOf(stateA).
+		OnEntry(record).
+		OnExit(record).
+		Permit(triggerY, stateB)
+	sm.Configure(stateB).
+		Permit(triggerX, "A1").
+		Permit(triggerZ, stateC)
+	sm.Configure(stateC).
+		InitialTransition("C1").
+		OnEntry(record)
+	sm.Configure("C1").
+		SubstateOf(stateC).
+		OnEntry(record)
+
+	sm.Fire(triggerX)
+	sm.Fire(triggerY)
+	sm.Fire(triggerZ)
+	want := []Transition{
+		{Source: stateB, Destination: stateA, Trigger: triggerX},
+		{Source: stateA, Destination: "A1", Trigger: triggerX},
+		{Source: "A1", Destination: stateA, Trigger: triggerY},
+		{Source: stateA, Destination: stateB, Trigger: triggerY},
+		{Source: stateB, Destination: stateC, Trigger: triggerZ},
+		{Source: stateC, Destination: "C1", Trigger: triggerZ},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+}
@@ -3,22 +3,62 @@ package stateless
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reentryCondition restricts the execution of an entry action depending on whether the transition is a reentry.
+type reentryCondition uint8
+
+const (
+	reentryAny reentryCondition = iota
+	reentryOnly
+	reentryExcept
 )
 
 type actionBehaviour struct {
 	Action      ActionFunc
 	Description invocationInfo
 	Trigger     *Trigger
+	Reentry     reentryCondition
+	// InitialOnly restricts the action to the states entered by an initial transition.
+	InitialOnly bool
 }
 
-func (a actionBehaviour) Execute(ctx context.Context, transition Transition, args ...any) (err error) {
+func (a actionBehaviour) Execute(ctx context.Context, transition, step Transition, args ...any) (err error) {
+	switch a.Reentry {
+	case reentryOnly:
+		if !transition.IsReentry() {
+			return
+		}
+	case reentryExcept:
+		if transition.IsReentry() {
+			return
+		}
+	}
+	if a.InitialOnly && !transition.IsInitial() {
+		return
+	}
 	if a.Trigger == nil || *a.Trigger == transition.Trigger {
-		ctx = withTransition(ctx, transition)
-		err = a.Action(ctx, args...)
+		ctx = withStepTransition(ctx, transition, step)
+		err = actionErr(transition, a.Action(ctx, args...))
 	}
 	return
 }
 
+// entryActionsFor returns the descriptions of the entry actions that only execute when entering through the trigger t.
+// The unconditional entry actions are listed in the state itself.
+func entryActionsFor(ab []actionBehaviour, t Trigger) []string {
+	var actions []string
+	for _, ea := range ab {
+		if ea.Trigger != nil && *ea.Trigger == t {
+			actions = append(actions, ea.Description.String())
+		}
+	}
+	return actions
+}
+
 type actionBehaviourSteady struct {
 	Action      func(ctx context.Context) error
 	Description invocationInfo
@@ -28,9 +68,40 @@ func (a actionBehaviourSteady) Execute(ctx context.Context) error {
 	return a.Action(ctx)
 }
 
+// defaultActions holds the entry and exit actions and the transitions shared by all the states of a state machine.
+type defaultActions struct {
+	EntryActions []actionBehaviour
+	ExitActions  []actionBehaviour
+	// Transitions are the transitions configured with PermitFromAll,
+	// only consulted when the trigger is not configured in the state hierarchy.
+	Transitions map[Trigger][]triggerBehaviour
+}
+
+// timedTrigger is a trigger fired automatically once the configured state has been active for some time.
+type timedTrigger struct {
+	Trigger Trigger
+	After   time.Duration
+	fire    func(context.Context, Trigger)
+}
+
+// armedTimer is a running timer of a timed trigger.
+type armedTimer struct {
+	timer *time.Timer
+}
+
+type timedFireKey struct{}
+
+// timedFire identifies the timer that fired a timed trigger,
+// so the trigger is dropped if the state has been exited since the timer was armed.
+type timedFire struct {
+	sr    *stateRepresentation
+	armed *armedTimer
+}
+
 type stateRepresentation struct {
 	State                   State
 	InitialTransitionTarget State
+	InitialTransitionPath   []State // substates entered in order by the initial transition, starting with InitialTransitionTarget
 	Superstate              *stateRepresentation
 	EntryActions            []actionBehaviour
 	ExitActions             []actionBehaviour
@@ -38,7 +109,28 @@ type stateRepresentation struct {
 	DeactivateActions       []actionBehaviourSteady
 	Substates               []*stateRepresentation
 	TriggerBehaviours       map[Trigger][]triggerBehaviour
+	BlockedTriggers         map[Trigger]struct{}
+	UnhandledTriggerAction  UnhandledTriggerActionFunc
+	Defaults                *defaultActions
+	Resolution              *GuardResolution
+	TimedTriggers           []timedTrigger
+	Metadata                map[string]any
+	Regions                 []*Region
 	HasInitialState         bool
+	HasHistory              bool
+	Configured              bool
+
+	// configMu guards the TriggerBehaviours, BlockedTriggers, Superstate, Substates, Metadata
+	// and the initial transition against concurrent configuration.
+	configMu sync.RWMutex
+
+	timersMu    sync.Mutex
+	timers      []*armedTimer
+	timersArmed bool // the timers have been armed since the state was last exited
+
+	historyMu  sync.Mutex
+	history    State
+	hasHistory bool
 }
 
 func newstateRepresentation(state State) *stateRepresentation {
@@ -49,10 +141,147 @@ func newstateRepresentation(state State) *stateRepresentation {
 }
 
 func (sr *stateRepresentation) SetInitialTransition(state State) {
+	sr.configMu.Lock()
+	defer sr.configMu.Unlock()
 	sr.InitialTransitionTarget = state
 	sr.HasInitialState = true
 }
 
+// setSuperstate makes sr a substate of super, detaching it from its previous superstate, if any.
+// Passing a nil super only detaches it. The caller must serialize the changes of the hierarchy.
+func (sr *stateRepresentation) setSuperstate(super *stateRepresentation) {
+	sr.configMu.Lock()
+	old := sr.Superstate
+	sr.Superstate = super
+	sr.configMu.Unlock()
+	if old == super {
+		return
+	}
+	if old != nil {
+		old.configMu.Lock()
+		substates := make([]*stateRepresentation, 0, len(old.Substates))
+		for _, substate := range old.Substates {
+			if substate != sr {
+				substates = append(substates, substate)
+			}
+		}
+		old.Substates = substates
+		old.configMu.Unlock()
+	}
+	if super != nil {
+		super.configMu.Lock()
+		super.Substates = append(super.Substates[:len(super.Substates):len(super.Substates)], sr)
+		super.configMu.Unlock()
+	}
+}
+
+// superstate returns the superstate of sr, or nil if it has none.
+func (sr *stateRepresentation) superstate() *stateRepresentation {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	return sr.Superstate
+}
+
+// substates returns the substates of sr.
+// The returned slice must not be modified, setSuperstate replaces it instead.
+func (sr *stateRepresentation) substates() []*stateRepresentation {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	return sr.Substates
+}
+
+// behaviours returns the behaviours configured for the trigger.
+// The returned slice must not be modified, AddTriggerBehaviour replaces it instead.
+func (sr *stateRepresentation) behaviours(trigger Trigger) []triggerBehaviour {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	return sr.TriggerBehaviours[trigger]
+}
+
+// triggerBehaviours returns a snapshot of the behaviours configured for each trigger,
+// so they can be evaluated without holding the lock.
+func (sr *stateRepresentation) triggerBehaviours() map[Trigger][]triggerBehaviour {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	behaviours := make(map[Trigger][]triggerBehaviour, len(sr.TriggerBehaviours))
+	for trigger, tbs := range sr.TriggerBehaviours {
+		behaviours[trigger] = tbs
+	}
+	return behaviours
+}
+
+// block prevents the trigger from being handled by the superstates.
+func (sr *stateRepresentation) block(trigger Trigger) {
+	sr.configMu.Lock()
+	defer sr.configMu.Unlock()
+	if sr.BlockedTriggers == nil {
+		sr.BlockedTriggers = make(map[Trigger]struct{})
+	}
+	sr.BlockedTriggers[trigger] = struct{}{}
+}
+
+// setMetadata attaches value to the state under key.
+func (sr *stateRepresentation) setMetadata(key string, value any) {
+	sr.configMu.Lock()
+	defer sr.configMu.Unlock()
+	if sr.Metadata == nil {
+		sr.Metadata = make(map[string]any)
+	}
+	sr.Metadata[key] = value
+}
+
+// metadata returns a copy of the metadata attached to the state, or nil if there is none.
+func (sr *stateRepresentation) metadata() map[string]any {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	if len(sr.Metadata) == 0 {
+		return nil
+	}
+	metadata := make(map[string]any, len(sr.Metadata))
+	for key, value := range sr.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// blocks returns true if the trigger is blocked in sr.
+func (sr *stateRepresentation) blocks(trigger Trigger) bool {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	_, ok := sr.BlockedTriggers[trigger]
+	return ok
+}
+
+// initialTarget returns the substate to enter when entering the state, which is the last active substate
+// if the state has shallow history and it has been recorded, or the initial transition target otherwise.
+func (sr *stateRepresentation) initialTarget() State {
+	if sr.HasHistory {
+		sr.historyMu.Lock()
+		defer sr.historyMu.Unlock()
+		if sr.hasHistory {
+			return sr.history
+		}
+	}
+	return sr.InitialTransitionTarget
+}
+
+// recordHistory records the active substate of every superstate of sr with shallow history.
+func (sr *stateRepresentation) recordHistory() {
+	for rep, super := sr, sr.superstate(); super != nil; rep, super = super, super.superstate() {
+		if super.HasHistory {
+			super.historyMu.Lock()
+			super.history, super.hasHistory = rep.State, true
+			super.historyMu.Unlock()
+		}
+	}
+}
+
+func (sr *stateRepresentation) clearHistory() {
+	sr.historyMu.Lock()
+	defer sr.historyMu.Unlock()
+	sr.history, sr.hasHistory = nil, false
+}
+
 func (sr *stateRepresentation) state() State {
 	return sr.State
 }
@@ -63,23 +292,105 @@ func (sr *stateRepresentation) CanHandle(ctx context.Context, trigger Trigger, a
 }
 
 func (sr *stateRepresentation) FindHandler(ctx context.Context, trigger Trigger, args ...any) (handler triggerBehaviourResult, ok bool) {
+	if _, override := sr.findOverrideHandler(ctx, trigger, args...); override != nil {
+		return triggerBehaviourResult{Handler: override}, true
+	}
+	if sr.Defaults != nil && len(sr.Defaults.Transitions[trigger]) != 0 && !sr.handles(trigger) {
+		return sr.findDefaultHandler(ctx, trigger, args...)
+	}
+	return sr.findNearestHandler(ctx, trigger, args...)
+}
+
+// findDefaultHandler looks for a transition configured with PermitFromAll,
+// skipping the ones whose destination is the state itself.
+func (sr *stateRepresentation) findDefaultHandler(ctx context.Context, trigger Trigger, args ...any) (triggerBehaviourResult, bool) {
+	var behaviours []triggerBehaviour
+	for _, behaviour := range sr.Defaults.Transitions[trigger] {
+		if t, ok := behaviour.(*transitioningTriggerBehaviour); !ok || t.Destination != sr.State {
+			behaviours = append(behaviours, behaviour)
+		}
+	}
+	return sr.selectHandler(ctx, trigger, behaviours, args...)
+}
+
+// permittedDefaultTriggers returns the triggers configured with PermitFromAll
+// that are permitted in the state, following the same rules as FindHandler.
+func (sr *stateRepresentation) permittedDefaultTriggers(ctx context.Context, args ...any) (triggers []Trigger) {
+	if sr.Defaults == nil {
+		return nil
+	}
+	for trigger := range sr.Defaults.Transitions {
+		if sr.handles(trigger) {
+			continue
+		}
+		if _, ok := sr.findDefaultHandler(ctx, trigger, args...); ok {
+			triggers = append(triggers, trigger)
+		}
+	}
+	return triggers
+}
+
+func (sr *stateRepresentation) findNearestHandler(ctx context.Context, trigger Trigger, args ...any) (handler triggerBehaviourResult, ok bool) {
 	handler, ok = sr.findHandler(ctx, trigger, args...)
-	if ok || sr.Superstate == nil {
+	super := sr.superstateFor(trigger)
+	if ok || super == nil {
 		return
 	}
-	handler, ok = sr.Superstate.FindHandler(ctx, trigger, args...)
+	handler, ok = super.findNearestHandler(ctx, trigger, args...)
 	return
 }
 
+// findOverrideHandler looks for an override transition whose guards are met in the superstates,
+// starting from the outermost one, and returns it together with the state where it is configured.
+func (sr *stateRepresentation) findOverrideHandler(ctx context.Context, trigger Trigger, args ...any) (*stateRepresentation, triggerBehaviour) {
+	super := sr.superstateFor(trigger)
+	if super == nil {
+		return nil, nil
+	}
+	if rep, override := super.findOverrideHandler(ctx, trigger, args...); override != nil {
+		return rep, override
+	}
+	for _, behaviour := range super.behaviours(trigger) {
+		if t, ok := behaviour.(*transitioningTriggerBehaviour); ok && t.Override && t.GuardConditionMet(ctx, args...) {
+			return super, t
+		}
+	}
+	return nil, nil
+}
+
+// superstateFor returns the superstate that should be consulted to handle the trigger,
+// which is nil if the trigger is blocked in the state.
+func (sr *stateRepresentation) superstateFor(trigger Trigger) *stateRepresentation {
+	sr.configMu.RLock()
+	defer sr.configMu.RUnlock()
+	if _, ok := sr.BlockedTriggers[trigger]; ok {
+		return nil
+	}
+	return sr.Superstate
+}
+
 func (sr *stateRepresentation) findHandler(ctx context.Context, trigger Trigger, args ...any) (result triggerBehaviourResult, ok bool) {
-	possibleBehaviours, ok := sr.TriggerBehaviours[trigger]
-	if !ok {
+	return sr.selectHandler(ctx, trigger, sr.behaviours(trigger), args...)
+}
+
+// selectHandler returns the behaviour whose guards are met among the possible ones, sorted by priority.
+// If there is none, the result contains the first one with the unmet guard conditions.
+func (sr *stateRepresentation) selectHandler(ctx context.Context, trigger Trigger, possibleBehaviours []triggerBehaviour, args ...any) (result triggerBehaviourResult, ok bool) {
+	if len(possibleBehaviours) == 0 {
 		return
 	}
 	var unmet []string
 	for _, behaviour := range possibleBehaviours {
+		// Behaviours are sorted by priority, so once a behaviour is permitted
+		// the ones with lower priority can't be selected.
+		if result.Handler != nil && len(result.UnmetGuardConditions) == 0 && behaviour.GetPriority() < result.Handler.GetPriority() {
+			break
+		}
 		unmet = behaviour.UnmetGuardConditions(ctx, unmet[:0], args...)
 		if len(unmet) == 0 {
+			if sr.Resolution != nil && *sr.Resolution == FirstMatch {
+				return triggerBehaviourResult{Handler: behaviour}, true
+			}
 			if result.Handler != nil && len(result.UnmetGuardConditions) == 0 {
 				panic(fmt.Sprintf("stateless: Multiple permitted exit transitions are configured from state '%v' for trigger '%v'. Guard clauses must be mutually exclusive.", sr.State, trigger))
 			}
@@ -94,58 +405,190 @@ func (sr *stateRepresentation) findHandler(ctx context.Context, trigger Trigger,
 	return result, result.Handler != nil && len(result.UnmetGuardConditions) == 0
 }
 
-func (sr *stateRepresentation) Activate(ctx context.Context) error {
-	if sr.Superstate != nil {
-		if err := sr.Superstate.Activate(ctx); err != nil {
+func (sr *stateRepresentation) Activate(ctx context.Context, activated *activationSet) error {
+	if super := sr.superstate(); super != nil {
+		if err := super.Activate(ctx, activated); err != nil {
 			return err
 		}
 	}
-	return sr.executeActivationActions(ctx)
+	if activated.contains(sr.State) {
+		return nil
+	}
+	if err := sr.executeActivationActions(ctx); err != nil {
+		return err
+	}
+	activated.add(sr.State)
+	return nil
 }
 
-func (sr *stateRepresentation) Deactivate(ctx context.Context) error {
+func (sr *stateRepresentation) Deactivate(ctx context.Context, activated *activationSet) error {
 	if err := sr.executeDeactivationActions(ctx); err != nil {
 		return err
 	}
-	if sr.Superstate != nil {
-		return sr.Superstate.Deactivate(ctx)
+	activated.remove(sr.State)
+	if super := sr.superstate(); super != nil {
+		return super.Deactivate(ctx, activated)
 	}
 	return nil
 }
 
 func (sr *stateRepresentation) Enter(ctx context.Context, transition Transition, args ...any) error {
+	step := Transition{Source: transition.Source, Destination: sr.State, Trigger: transition.Trigger}
 	if transition.IsReentry() {
-		return sr.executeEntryActions(ctx, transition, args...)
+		return sr.enter(ctx, transition, step, args...)
 	}
 	if sr.IncludeState(transition.Source) {
 		return nil
 	}
-	if sr.Superstate != nil && !transition.isInitial {
-		if err := sr.Superstate.Enter(ctx, transition, args...); err != nil {
+	if super := sr.superstate(); super != nil {
+		if transition.isInitial {
+			// The superstate has just been entered by the initial transition.
+			step.Source = super.State
+		} else {
+			if err := super.Enter(ctx, transition, args...); err != nil {
+				return err
+			}
+			if !super.IncludeState(transition.Source) {
+				step.Source = super.State
+			}
+		}
+	}
+	return sr.enter(ctx, transition, step, args...)
+}
+
+// enter executes the entry actions, enters the initial state of the regions
+// and then arms the timers of the timed triggers.
+func (sr *stateRepresentation) enter(ctx context.Context, transition, step Transition, args ...any) error {
+	if err := sr.executeEntryActions(ctx, transition, step, args...); err != nil {
+		return err
+	}
+	for _, r := range sr.Regions {
+		if err := r.enter(ctx, transition, args...); err != nil {
 			return err
 		}
 	}
-	return sr.executeEntryActions(ctx, transition, args...)
+	sr.armTimers()
+	return nil
+}
+
+func (sr *stateRepresentation) armTimers() {
+	if len(sr.TimedTriggers) == 0 {
+		return
+	}
+	sr.timersMu.Lock()
+	defer sr.timersMu.Unlock()
+	sr.armTimersLocked()
+}
+
+// armIdleTimers arms the timers of the timed triggers unless they have already been armed
+// since the state was last exited.
+func (sr *stateRepresentation) armIdleTimers() {
+	if len(sr.TimedTriggers) == 0 {
+		return
+	}
+	sr.timersMu.Lock()
+	defer sr.timersMu.Unlock()
+	if !sr.timersArmed {
+		sr.armTimersLocked()
+	}
+}
+
+func (sr *stateRepresentation) armTimersLocked() {
+	sr.timersArmed = true
+	for _, tt := range sr.TimedTriggers {
+		tt := tt
+		armed := new(armedTimer)
+		ctx := context.WithValue(context.Background(), timedFireKey{}, timedFire{sr: sr, armed: armed})
+		armed.timer = time.AfterFunc(tt.After, func() {
+			tt.fire(ctx, tt.Trigger)
+		})
+		sr.timers = append(sr.timers, armed)
+	}
+}
+
+// disarm removes the timer from the armed ones, returning false if it has been canceled.
+func (sr *stateRepresentation) disarm(armed *armedTimer) bool {
+	sr.timersMu.Lock()
+	defer sr.timersMu.Unlock()
+	for i, t := range sr.timers {
+		if t == armed {
+			sr.timers = append(sr.timers[:i], sr.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (sr *stateRepresentation) cancelTimers() {
+	sr.timersMu.Lock()
+	defer sr.timersMu.Unlock()
+	for _, t := range sr.timers {
+		t.timer.Stop()
+	}
+	sr.timers = nil
+	sr.timersArmed = false
+}
+
+type exitLogKey struct{}
+
+// exitLog collects the states and the regions exited during a transition, so that the timers are only canceled,
+// and the regions only left, once all the exit actions have succeeded and the transition can't be aborted anymore.
+type exitLog struct {
+	states  []*stateRepresentation
+	regions []*Region
 }
 
+func withExitLog(ctx context.Context) (context.Context, *exitLog) {
+	log := new(exitLog)
+	return context.WithValue(ctx, exitLogKey{}, log), log
+}
+
+// commit cancels the timers of the exited states and leaves the exited regions.
+func (l *exitLog) commit() {
+	for _, sr := range l.states {
+		sr.cancelTimers()
+	}
+	for _, r := range l.regions {
+		r.leave()
+	}
+}
+
+// Exit executes the exit actions of sr, and of its regions and superstates that are left by the transition.
+// If ctx contains an exitLog the timers are not canceled, and the regions are not left, until it is committed.
 func (sr *stateRepresentation) Exit(ctx context.Context, transition Transition, args ...any) (err error) {
 	isReentry := transition.IsReentry()
 	if !isReentry && sr.IncludeState(transition.Destination) {
 		return
 	}
 
-	err = sr.executeExitActions(ctx, transition, args...)
+	if log, ok := ctx.Value(exitLogKey{}).(*exitLog); ok {
+		log.states = append(log.states, sr)
+	} else {
+		sr.cancelTimers()
+	}
+	for _, r := range sr.Regions {
+		if err = r.exit(ctx, transition, args...); err != nil {
+			return
+		}
+	}
+	step := Transition{Source: sr.State, Destination: transition.Destination, Trigger: transition.Trigger}
+	super := sr.superstate()
+	if !isReentry && super != nil && !super.IncludeState(transition.Destination) {
+		// The superstate is exited next.
+		step.Destination = super.State
+	}
+	err = sr.executeExitActions(ctx, transition, step, args...)
 	// Must check if there is a superstate, and if we are leaving that superstate
-	if err == nil && !isReentry && sr.Superstate != nil {
+	if err == nil && !isReentry && super != nil {
 		// Check if destination is within the state list
 		if sr.IsIncludedInState(transition.Destination) {
 			// Destination state is within the list, exit first superstate only if it is NOT the the first
-			if sr.Superstate.state() != transition.Destination {
-				err = sr.Superstate.Exit(ctx, transition, args...)
+			if super.state() != transition.Destination {
+				err = super.Exit(ctx, transition, args...)
 			}
 		} else {
 			// Exit the superstate as well
-			err = sr.Superstate.Exit(ctx, transition, args...)
+			err = super.Exit(ctx, transition, args...)
 		}
 	}
 	return
@@ -162,7 +605,7 @@ func (sr *stateRepresentation) InternalAction(ctx context.Context, transition Tr
 			}
 			break
 		}
-		stateRep = stateRep.Superstate
+		stateRep = stateRep.superstate()
 	}
 	if internalTransition == nil {
 		panic("stateless: The configuration is incorrect, no action assigned to this internal transition.")
@@ -170,11 +613,39 @@ func (sr *stateRepresentation) InternalAction(ctx context.Context, transition Tr
 	return internalTransition.Execute(ctx, transition, args...)
 }
 
+// BroadcastInternalAction executes the internal transitions of every level of the hierarchy
+// that can handle the trigger, from the innermost to the outermost.
+func (sr *stateRepresentation) BroadcastInternalAction(ctx context.Context, transition Transition, args ...any) error {
+	for stateRep := sr; stateRep != nil; stateRep = stateRep.superstateFor(transition.Trigger) {
+		result, ok := stateRep.findHandler(ctx, transition.Trigger, args...)
+		if !ok {
+			continue
+		}
+		if t, ok := result.Handler.(*internalTriggerBehaviour); ok {
+			if err := t.Execute(ctx, transition, args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handles returns true if the state or any of its superstates has a behaviour
+// configured for the trigger, without evaluating the guards.
+func (sr *stateRepresentation) handles(trigger Trigger) bool {
+	for ; sr != nil; sr = sr.superstateFor(trigger) {
+		if len(sr.behaviours(trigger)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (sr *stateRepresentation) IncludeState(state State) bool {
 	if state == sr.State {
 		return true
 	}
-	for _, substate := range sr.Substates {
+	for _, substate := range sr.substates() {
 		if substate.IncludeState(state) {
 			return true
 		}
@@ -186,21 +657,111 @@ func (sr *stateRepresentation) IsIncludedInState(state State) bool {
 	if state == sr.State {
 		return true
 	}
-	if sr.Superstate != nil {
-		return sr.Superstate.IsIncludedInState(state)
+	if super := sr.superstate(); super != nil {
+		return super.IsIncludedInState(state)
 	}
 	return false
 }
 
+// renameReferences rewrites the references to oldState in the initial transition,
+// the destinations of the behaviours and the recorded history. The behaviours are replaced
+// by updated copies, as the snapshots returned by behaviours may be in use.
+func (sr *stateRepresentation) renameReferences(oldState, newState State) {
+	sr.historyMu.Lock()
+	if sr.hasHistory && sr.history == oldState {
+		sr.history = newState
+	}
+	sr.historyMu.Unlock()
+	sr.configMu.Lock()
+	defer sr.configMu.Unlock()
+	if sr.HasInitialState && sr.InitialTransitionTarget == oldState {
+		sr.InitialTransitionTarget = newState
+	}
+	if len(sr.InitialTransitionPath) != 0 {
+		path := make([]State, len(sr.InitialTransitionPath))
+		for i, state := range sr.InitialTransitionPath {
+			if state == oldState {
+				state = newState
+			}
+			path[i] = state
+		}
+		sr.InitialTransitionPath = path
+	}
+	for trigger, behaviours := range sr.TriggerBehaviours {
+		if renamed := renameDestinations(behaviours, oldState, newState); renamed != nil {
+			sr.TriggerBehaviours[trigger] = renamed
+		}
+	}
+}
+
+// renameDestinations returns a copy of behaviours where the destinations equal to oldState,
+// including the possible destinations of the dynamic transitions, are replaced by newState.
+// The behaviours that change are copied too. It returns nil if no behaviour references oldState.
+func renameDestinations(behaviours []triggerBehaviour, oldState, newState State) []triggerBehaviour {
+	var renamed []triggerBehaviour
+	for i, behaviour := range behaviours {
+		var copied triggerBehaviour
+		switch t := behaviour.(type) {
+		case *transitioningTriggerBehaviour:
+			if t.Destination == oldState {
+				c := *t
+				c.Destination = newState
+				copied = &c
+			}
+		case *reentryTriggerBehaviour:
+			if t.Destination == oldState {
+				c := *t
+				c.Destination = newState
+				copied = &c
+			}
+		case *dynamicTriggerBehaviour:
+			for _, state := range t.PossibleDestinations {
+				if state == oldState {
+					c := *t
+					c.PossibleDestinations = make([]State, len(t.PossibleDestinations))
+					for j, state := range t.PossibleDestinations {
+						if state == oldState {
+							state = newState
+						}
+						c.PossibleDestinations[j] = state
+					}
+					copied = &c
+					break
+				}
+			}
+		}
+		if copied == nil {
+			continue
+		}
+		if renamed == nil {
+			renamed = append([]triggerBehaviour(nil), behaviours...)
+		}
+		renamed[i] = copied
+	}
+	return renamed
+}
+
 func (sr *stateRepresentation) AddTriggerBehaviour(tb triggerBehaviour) {
+	sr.configMu.Lock()
+	defer sr.configMu.Unlock()
 	trigger := tb.GetTrigger()
-	sr.TriggerBehaviours[trigger] = append(sr.TriggerBehaviours[trigger], tb)
-
+	// Keep behaviours sorted by descending priority, preserving declaration order for equal priorities.
+	// The slice is copied so the snapshots returned by behaviours are never modified.
+	old := sr.TriggerBehaviours[trigger]
+	i := len(old)
+	for i > 0 && old[i-1].GetPriority() < tb.GetPriority() {
+		i--
+	}
+	behaviours := make([]triggerBehaviour, 0, len(old)+1)
+	behaviours = append(behaviours, old[:i]...)
+	behaviours = append(behaviours, tb)
+	behaviours = append(behaviours, old[i:]...)
+	sr.TriggerBehaviours[trigger] = behaviours
 }
 
 func (sr *stateRepresentation) PermittedTriggers(ctx context.Context, args ...any) (triggers []Trigger) {
 	var unmet []string
-	for key, value := range sr.TriggerBehaviours {
+	for key, value := range sr.triggerBehaviours() {
 		for _, tb := range value {
 			if len(tb.UnmetGuardConditions(ctx, unmet[:0], args...)) == 0 {
 				triggers = append(triggers, key)
@@ -208,15 +769,19 @@ func (sr *stateRepresentation) PermittedTriggers(ctx context.Context, args ...an
 			}
 		}
 	}
-	if sr.Superstate != nil {
-		triggers = append(triggers, sr.Superstate.PermittedTriggers(ctx, args...)...)
-		// remove duplicated
+	if super := sr.superstate(); super != nil {
+		own := len(triggers)
+		triggers = append(triggers, super.PermittedTriggers(ctx, args...)...)
+		// remove duplicated and the inherited ones that are blocked
 		seen := make(map[Trigger]struct{}, len(triggers))
 		j := 0
-		for _, v := range triggers {
+		for i, v := range triggers {
 			if _, ok := seen[v]; ok {
 				continue
 			}
+			if i >= own && sr.blocks(v) {
+				continue
+			}
 			seen[v] = struct{}{}
 			triggers[j] = v
 			j++
@@ -226,6 +791,68 @@ func (sr *stateRepresentation) PermittedTriggers(ctx context.Context, args ...an
 	return
 }
 
+// HasAnyPermittedTrigger returns true as soon as a permitted trigger is found,
+// following the same rules as PermittedTriggers.
+func (sr *stateRepresentation) HasAnyPermittedTrigger(ctx context.Context, args ...any) bool {
+	var unmet []string
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		for trigger, behaviours := range rep.triggerBehaviours() {
+			if sr.blocksInherited(rep, trigger) {
+				continue
+			}
+			for _, tb := range behaviours {
+				if unmet = tb.UnmetGuardConditions(ctx, unmet[:0], args...); len(unmet) == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// blocksInherited returns true if the trigger configured in the ancestor is blocked
+// by the state or any of its superstates below the ancestor.
+func (sr *stateRepresentation) blocksInherited(ancestor *stateRepresentation, trigger Trigger) bool {
+	for rep := sr; rep != ancestor; rep = rep.superstate() {
+		if rep.blocks(trigger) {
+			return true
+		}
+	}
+	return false
+}
+
+// PermittedTransitions returns the transitions that would be taken for each permitted trigger,
+// resolving the handlers in the same way as FindHandler.
+func (sr *stateRepresentation) PermittedTransitions(ctx context.Context, args ...any) []TransitionInfo {
+	var triggers []Trigger
+	seen := make(map[Trigger]struct{})
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		for trigger := range rep.triggerBehaviours() {
+			if _, ok := seen[trigger]; !ok {
+				seen[trigger] = struct{}{}
+				triggers = append(triggers, trigger)
+			}
+		}
+	}
+	var infos []TransitionInfo
+	for _, trigger := range triggers {
+		if rep, override := sr.findOverrideHandler(ctx, trigger, args...); override != nil {
+			infos = append(infos, newTransitionInfo(rep, override))
+			continue
+		}
+		for rep := sr; rep != nil; rep = rep.superstateFor(trigger) {
+			if result, ok := rep.findHandler(ctx, trigger, args...); ok {
+				infos = append(infos, newTransitionInfo(rep, result.Handler))
+				break
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return fmt.Sprint(infos[i].Trigger) < fmt.Sprint(infos[j].Trigger)
+	})
+	return infos
+}
+
 func (sr *stateRepresentation) executeActivationActions(ctx context.Context) error {
 	for _, a := range sr.ActivateActions {
 		if err := a.Execute(ctx); err != nil {
@@ -244,20 +871,36 @@ func (sr *stateRepresentation) executeDeactivationActions(ctx context.Context) e
 	return nil
 }
 
-func (sr *stateRepresentation) executeEntryActions(ctx context.Context, transition Transition, args ...any) error {
-	for _, a := range sr.EntryActions {
-		if err := a.Execute(ctx, transition, args...); err != nil {
+func (sr *stateRepresentation) executeEntryActions(ctx context.Context, transition, step Transition, args ...any) error {
+	if sr.Defaults != nil {
+		if err := executeActions(ctx, sr.Defaults.EntryActions, transition, step, args...); err != nil {
 			return err
 		}
 	}
-	return nil
+	return executeActions(ctx, sr.EntryActions, transition, step, args...)
 }
 
-func (sr *stateRepresentation) executeExitActions(ctx context.Context, transition Transition, args ...any) error {
-	for _, a := range sr.ExitActions {
-		if err := a.Execute(ctx, transition, args...); err != nil {
+func (sr *stateRepresentation) executeExitActions(ctx context.Context, transition, step Transition, args ...any) error {
+	if sr.Defaults != nil {
+		if err := executeActions(ctx, sr.Defaults.ExitActions, transition, step, args...); err != nil {
 			return err
 		}
 	}
+	return executeActions(ctx, sr.ExitActions, transition, step, args...)
+}
+
+func executeActions(ctx context.Context, actions []actionBehaviour, transition, step Transition, args ...any) error {
+	for _, a := range actions {
+		if err := transitionTimeoutErr(ctx); err != nil {
+			return err
+		}
+		if err := a.Execute(ctx, transition, step, args...); err != nil {
+			return err
+		}
+	}
+	if len(actions) != 0 {
+		// The last action may have overrun the deadline too.
+		return transitionTimeoutErr(ctx)
+	}
 	return nil
 }
@@ -0,0 +1,90 @@
+package stateless
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// DefaultSubscriptionBuffer is the default size of the channels returned by StateMachine.Subscribe.
+const DefaultSubscriptionBuffer = 16
+
+// SubscriptionDroppedFunc defines a function that is called when a transition can't be delivered
+// to a subscriber because its channel is full.
+type SubscriptionDroppedFunc = func(ctx context.Context, transition Transition)
+
+// subscriptions fans out the transitions to the channels returned by StateMachine.Subscribe.
+type subscriptions struct {
+	mu        sync.Mutex
+	buffer    int
+	channels  map[chan Transition]struct{}
+	onDropped SubscriptionDroppedFunc
+}
+
+func (s *subscriptions) subscribe() (chan Transition, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.channels == nil {
+		s.channels = make(map[chan Transition]struct{})
+	}
+	buffer := s.buffer
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+	ch := make(chan Transition, buffer)
+	s.channels[ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.channels, ch)
+			close(ch)
+		})
+	}
+}
+
+// publish sends transition to all the subscribers without blocking.
+func (s *subscriptions) publish(ctx context.Context, transition Transition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.channels {
+		select {
+		case ch <- transition:
+		default:
+			if s.onDropped != nil {
+				s.onDropped(ctx, transition)
+			} else {
+				log.Printf("stateless: Dropping transition %v for a slow subscriber", transition)
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every transition reported to the OnTransitioned callbacks,
+// after they have been called, together with a function that cancels the subscription and closes the channel.
+//
+// The transitions are sent without blocking, so a slow consumer can't stall Fire.
+// If the channel is full the transition is dropped and reported to the callback registered
+// with OnSubscriptionDropped, or logged if there is none.
+// The size of the channel can be configured with SetSubscriptionBuffer.
+func (sm *StateMachine) Subscribe() (<-chan Transition, func()) {
+	return sm.subscriptions.subscribe()
+}
+
+// SetSubscriptionBuffer sets the size of the channels returned by the following calls to Subscribe.
+// A value lower or equal than 0 means DefaultSubscriptionBuffer.
+func (sm *StateMachine) SetSubscriptionBuffer(size int) {
+	sm.subscriptions.mu.Lock()
+	defer sm.subscriptions.mu.Unlock()
+	sm.subscriptions.buffer = size
+}
+
+// OnSubscriptionDropped registers a callback that will be invoked when a transition can't be delivered
+// to a subscriber created with Subscribe because its channel is full.
+// The callback must not call Subscribe nor cancel a subscription.
+func (sm *StateMachine) OnSubscriptionDropped(fn SubscriptionDroppedFunc) {
+	sm.subscriptions.mu.Lock()
+	defer sm.subscriptions.mu.Unlock()
+	sm.subscriptions.onDropped = fn
+}
@@ -0,0 +1,58 @@
+package stateless
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStateMachine_Subscribe(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).Permit(triggerY, stateA)
+
+	ch, unsubscribe := sm.Subscribe()
+	sm.Fire(triggerX)
+	sm.Fire(triggerY)
+
+	want := []Transition{
+		{Source: stateA, Destination: stateB, Trigger: triggerX},
+		{Source: stateB, Destination: stateA, Trigger: triggerY},
+	}
+	for _, w := range want {
+		if got := <-ch; got != w {
+			t.Errorf("transition = %v, want %v", got, w)
+		}
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Error("expected closed channel")
+	}
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateMachine_Subscribe_Dropped(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetSubscriptionBuffer(1)
+	var dropped []Transition
+	sm.OnSubscriptionDropped(func(_ context.Context, tr Transition) {
+		dropped = append(dropped, tr)
+	})
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).Permit(triggerY, stateA)
+
+	ch, unsubscribe := sm.Subscribe()
+	defer unsubscribe()
+	sm.Fire(triggerX)
+	sm.Fire(triggerY)
+
+	if got, want := <-ch, (Transition{Source: stateA, Destination: stateB, Trigger: triggerX}); got != want {
+		t.Errorf("transition = %v, want %v", got, want)
+	}
+	if want := (Transition{Source: stateB, Destination: stateA, Trigger: triggerY}); len(dropped) != 1 || dropped[0] != want {
+		t.Errorf("dropped = %v, want [%v]", dropped, want)
+	}
+}
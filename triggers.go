@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 type invocationInfo struct {
@@ -34,10 +35,14 @@ func (inv invocationInfo) String() string {
 type guardCondition struct {
 	Guard       GuardFunc
 	Description invocationInfo
+	// unwrapped is the guard as configured by the user, before being wrapped to recover its panics.
+	unwrapped GuardFunc
 }
 
 type transitionGuard struct {
 	Guards []guardCondition
+	// Any is true if the guard is met when any of the guard conditions is met, instead of all of them.
+	Any bool
 }
 
 func newtransitionGuard(guards ...GuardFunc) transitionGuard {
@@ -51,8 +56,17 @@ func newtransitionGuard(guards ...GuardFunc) transitionGuard {
 	return tg
 }
 
-// GuardConditionsMet is true if all of the guard functions return true.
+// GuardConditionsMet is true if all of the guard functions return true,
+// or if any of them does when the guard has been created with PermitAny.
 func (t transitionGuard) GuardConditionMet(ctx context.Context, args ...any) bool {
+	if t.Any && len(t.Guards) > 0 {
+		for _, guard := range t.Guards {
+			if guard.Guard(ctx, args...) {
+				return true
+			}
+		}
+		return false
+	}
 	for _, guard := range t.Guards {
 		if !guard.Guard(ctx, args...) {
 			return false
@@ -69,26 +83,56 @@ func (t transitionGuard) UnmetGuardConditions(ctx context.Context, buf []string,
 	for _, guard := range t.Guards {
 		if !guard.Guard(ctx, args...) {
 			buf = append(buf, guard.Description.String())
+		} else if t.Any {
+			// A single met condition is enough, so none is unmet.
+			return buf[:0]
 		}
 	}
 	return buf
 }
 
+// descriptions returns the descriptions of the guard conditions to be displayed.
+// The conditions of an Any guard are combined in a single description.
+func (t transitionGuard) descriptions() []string {
+	if len(t.Guards) == 0 {
+		return nil
+	}
+	descriptions := make([]string, len(t.Guards))
+	for i, guard := range t.Guards {
+		descriptions[i] = guard.Description.String()
+	}
+	if t.Any && len(descriptions) > 1 {
+		return []string{strings.Join(descriptions, " || ")}
+	}
+	return descriptions
+}
+
 type triggerBehaviour interface {
 	GuardConditionMet(context.Context, ...any) bool
 	UnmetGuardConditions(context.Context, []string, ...any) []string
 	GetTrigger() Trigger
+	GetPriority() int
+	base() *baseTriggerBehaviour
 }
 
 type baseTriggerBehaviour struct {
-	Guard   transitionGuard
-	Trigger Trigger
+	Guard    transitionGuard
+	Trigger  Trigger
+	Priority int
 }
 
 func (t *baseTriggerBehaviour) GetTrigger() Trigger {
 	return t.Trigger
 }
 
+func (t *baseTriggerBehaviour) GetPriority() int {
+	return t.Priority
+}
+
+func (t *baseTriggerBehaviour) base() *baseTriggerBehaviour {
+	return t
+}
+
 func (t *baseTriggerBehaviour) GuardConditionMet(ctx context.Context, args ...any) bool {
 	return t.Guard.GuardConditionMet(ctx, args...)
 }
@@ -109,11 +153,45 @@ type reentryTriggerBehaviour struct {
 type transitioningTriggerBehaviour struct {
 	baseTriggerBehaviour
 	Destination State
+	Override    bool
+	// Action is executed on the transition itself, configured with PermitWithAction.
+	Action            ActionFunc
+	ActionDescription invocationInfo
+}
+
+// actions returns the descriptions of the action of the transition, if any,
+// followed by the entry actions of dest configured for the trigger.
+func (t *transitioningTriggerBehaviour) actions(dest *stateRepresentation) []string {
+	var actions []string
+	if t.Action != nil {
+		actions = append(actions, t.ActionDescription.String())
+	}
+	if dest != nil {
+		actions = append(actions, entryActionsFor(dest.EntryActions, t.Trigger)...)
+	}
+	return actions
 }
 
 type dynamicTriggerBehaviour struct {
 	baseTriggerBehaviour
 	Destination func(context.Context, ...any) (State, error)
+	Description invocationInfo
+	// PossibleDestinations contains the states the selector may return, if declared with PermitDynamicDest.
+	PossibleDestinations []State
+}
+
+// allows returns true if destination is one of the declared possible destinations,
+// or if no destination has been declared.
+func (t *dynamicTriggerBehaviour) allows(destination State) bool {
+	if len(t.PossibleDestinations) == 0 {
+		return true
+	}
+	for _, d := range t.PossibleDestinations {
+		if d == destination {
+			return true
+		}
+	}
+	return false
 }
 
 type internalTriggerBehaviour struct {
@@ -123,7 +201,20 @@ type internalTriggerBehaviour struct {
 
 func (t *internalTriggerBehaviour) Execute(ctx context.Context, transition Transition, args ...any) error {
 	ctx = withTransition(ctx, transition)
-	return t.Action(ctx, args...)
+	return actionErr(transition, t.Action(ctx, args...))
+}
+
+// internalDynamicTriggerBehaviour is an internal transition whose action can escalate it into a transition.
+type internalDynamicTriggerBehaviour struct {
+	baseTriggerBehaviour
+	Action      InternalDestinationFunc
+	Description invocationInfo
+}
+
+func (t *internalDynamicTriggerBehaviour) Execute(ctx context.Context, transition Transition, args ...any) (State, bool, error) {
+	ctx = withTransition(ctx, transition)
+	destination, escalate, err := t.Action(ctx, args...)
+	return destination, escalate, actionErr(transition, err)
 }
 
 type triggerBehaviourResult struct {
@@ -132,20 +223,50 @@ type triggerBehaviourResult struct {
 }
 
 // triggerWithParameters associates configured parameters with an underlying trigger value.
+// If VariadicLast is true, the last argument type accepts zero or more arguments.
 type triggerWithParameters struct {
 	Trigger       Trigger
 	ArgumentTypes []reflect.Type
+	VariadicLast  bool
 }
 
 func (t triggerWithParameters) validateParameters(args ...any) {
-	if len(args) != len(t.ArgumentTypes) {
+	if t.VariadicLast {
+		if len(args) < len(t.ArgumentTypes)-1 {
+			panic(fmt.Sprintf("stateless: An unexpected amount of parameters have been supplied. Expecting at least '%d' but got '%d'.", len(t.ArgumentTypes)-1, len(args)))
+		}
+	} else if len(args) != len(t.ArgumentTypes) {
 		panic(fmt.Sprintf("stateless: An unexpected amount of parameters have been supplied. Expecting '%d' but got '%d'.", len(t.ArgumentTypes), len(args)))
 	}
-	for i := range t.ArgumentTypes {
+	for i := range args {
 		tp := reflect.TypeOf(args[i])
-		want := t.ArgumentTypes[i]
+		want := t.ArgumentTypes[len(t.ArgumentTypes)-1]
+		if i < len(t.ArgumentTypes) {
+			want = t.ArgumentTypes[i]
+		}
+		if tp == nil {
+			// An untyped nil is only valid for the types that can be nil.
+			if !isNillable(want) {
+				panic(fmt.Sprintf("stateless: The argument in position '%d' is nil but must be convertible to '%v'.", i, want))
+			}
+			continue
+		}
 		if !tp.ConvertibleTo(want) {
 			panic(fmt.Sprintf("stateless: The argument in position '%d' is of type '%v' but must be convertible to '%v'.", i, tp, want))
 		}
 	}
 }
+
+func isNillable(tp reflect.Type) bool {
+	switch tp.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+// deprecatedTrigger holds the deprecation notice of a trigger.
+type deprecatedTrigger struct {
+	Message string
+	once    sync.Once
+}
@@ -0,0 +1,168 @@
+package stateless
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// OnEntryFromTyped1 behaves like StateConfiguration.OnEntryFrom, but the action receives the single argument
+// of the trigger converted to P1. If the parameters of the trigger have been registered with
+// SetTriggerParameters, it panics when they don't match the action.
+func OnEntryFromTyped1[P1 any](sc *StateConfiguration, trigger Trigger, action func(context.Context, P1) error) *StateConfiguration {
+	sc.checkTypedParameters(trigger, typeOf[P1]())
+	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
+		Action:      typedAction1(action),
+		Description: newinvocationInfo(action),
+		Trigger:     &trigger,
+	})
+	return sc
+}
+
+// OnEntryFromTyped2 behaves like OnEntryFromTyped1 for triggers with two arguments.
+func OnEntryFromTyped2[P1, P2 any](sc *StateConfiguration, trigger Trigger, action func(context.Context, P1, P2) error) *StateConfiguration {
+	sc.checkTypedParameters(trigger, typeOf[P1](), typeOf[P2]())
+	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
+		Action:      typedAction2(action),
+		Description: newinvocationInfo(action),
+		Trigger:     &trigger,
+	})
+	return sc
+}
+
+// OnEntryFromTyped3 behaves like OnEntryFromTyped1 for triggers with three arguments.
+func OnEntryFromTyped3[P1, P2, P3 any](sc *StateConfiguration, trigger Trigger, action func(context.Context, P1, P2, P3) error) *StateConfiguration {
+	sc.checkTypedParameters(trigger, typeOf[P1](), typeOf[P2](), typeOf[P3]())
+	sc.sr.EntryActions = append(sc.sr.EntryActions, actionBehaviour{
+		Action:      typedAction3(action),
+		Description: newinvocationInfo(action),
+		Trigger:     &trigger,
+	})
+	return sc
+}
+
+// OnExitWithTyped1 behaves like StateConfiguration.OnExitWith, but the action receives the single argument
+// of the trigger converted to P1. If the parameters of the trigger have been registered with
+// SetTriggerParameters, it panics when they don't match the action.
+func OnExitWithTyped1[P1 any](sc *StateConfiguration, trigger Trigger, action func(context.Context, P1) error) *StateConfiguration {
+	sc.checkTypedParameters(trigger, typeOf[P1]())
+	sc.sr.ExitActions = append(sc.sr.ExitActions, actionBehaviour{
+		Action:      typedAction1(action),
+		Description: newinvocationInfo(action),
+		Trigger:     &trigger,
+	})
+	return sc
+}
+
+// OnExitWithTyped2 behaves like OnExitWithTyped1 for triggers with two arguments.
+func OnExitWithTyped2[P1, P2 any](sc *StateConfiguration, trigger Trigger, action func(context.Context, P1, P2) error) *StateConfiguration {
+	sc.checkTypedParameters(trigger, typeOf[P1](), typeOf[P2]())
+	sc.sr.ExitActions = append(sc.sr.ExitActions, actionBehaviour{
+		Action:      typedAction2(action),
+		Description: newinvocationInfo(action),
+		Trigger:     &trigger,
+	})
+	return sc
+}
+
+// OnExitWithTyped3 behaves like OnExitWithTyped1 for triggers with three arguments.
+func OnExitWithTyped3[P1, P2, P3 any](sc *StateConfiguration, trigger Trigger, action func(context.Context, P1, P2, P3) error) *StateConfiguration {
+	sc.checkTypedParameters(trigger, typeOf[P1](), typeOf[P2](), typeOf[P3]())
+	sc.sr.ExitActions = append(sc.sr.ExitActions, actionBehaviour{
+		Action:      typedAction3(action),
+		Description: newinvocationInfo(action),
+		Trigger:     &trigger,
+	})
+	return sc
+}
+
+func typedAction1[P1 any](action func(context.Context, P1) error) ActionFunc {
+	return func(ctx context.Context, args ...any) error {
+		p1, err := typedArg[P1](args, 0)
+		if err != nil {
+			return err
+		}
+		return action(ctx, p1)
+	}
+}
+
+func typedAction2[P1, P2 any](action func(context.Context, P1, P2) error) ActionFunc {
+	return func(ctx context.Context, args ...any) error {
+		p1, err := typedArg[P1](args, 0)
+		if err != nil {
+			return err
+		}
+		p2, err := typedArg[P2](args, 1)
+		if err != nil {
+			return err
+		}
+		return action(ctx, p1, p2)
+	}
+}
+
+func typedAction3[P1, P2, P3 any](action func(context.Context, P1, P2, P3) error) ActionFunc {
+	return func(ctx context.Context, args ...any) error {
+		p1, err := typedArg[P1](args, 0)
+		if err != nil {
+			return err
+		}
+		p2, err := typedArg[P2](args, 1)
+		if err != nil {
+			return err
+		}
+		p3, err := typedArg[P3](args, 2)
+		if err != nil {
+			return err
+		}
+		return action(ctx, p1, p2, p3)
+	}
+}
+
+func typeOf[P any]() reflect.Type {
+	return reflect.TypeOf((*P)(nil)).Elem()
+}
+
+// typedArg returns the argument in position i converted to P.
+// A nil argument is returned as the zero value of P.
+func typedArg[P any](args []any, i int) (P, error) {
+	var p P
+	if i >= len(args) {
+		return p, fmt.Errorf("stateless: Missing argument in position '%d', expecting '%d' arguments.", i, i+1)
+	}
+	if args[i] == nil {
+		return p, nil
+	}
+	if v, ok := args[i].(P); ok {
+		return v, nil
+	}
+	v := reflect.ValueOf(args[i])
+	if want := typeOf[P](); v.Type().ConvertibleTo(want) {
+		return v.Convert(want).Interface().(P), nil
+	}
+	return p, fmt.Errorf("stateless: The argument in position '%d' is of type '%v' but must be convertible to '%v'.", i, v.Type(), typeOf[P]())
+}
+
+// checkTypedParameters panics if the parameters registered for trigger with SetTriggerParameters
+// don't match the parameters of a typed action.
+func (sc *StateConfiguration) checkTypedParameters(trigger Trigger, params ...reflect.Type) {
+	config, ok := sc.sm.triggerParameters(trigger)
+	if !ok {
+		return
+	}
+	if config.VariadicLast {
+		if len(params) < len(config.ArgumentTypes)-1 {
+			panic(fmt.Sprintf("stateless: The action expects '%d' parameters but trigger '%v' has at least '%d'.", len(params), trigger, len(config.ArgumentTypes)-1))
+		}
+	} else if len(params) != len(config.ArgumentTypes) {
+		panic(fmt.Sprintf("stateless: The action expects '%d' parameters but trigger '%v' has '%d'.", len(params), trigger, len(config.ArgumentTypes)))
+	}
+	for i, param := range params {
+		want := config.ArgumentTypes[len(config.ArgumentTypes)-1]
+		if i < len(config.ArgumentTypes) {
+			want = config.ArgumentTypes[i]
+		}
+		if !want.ConvertibleTo(param) {
+			panic(fmt.Sprintf("stateless: The parameter in position '%d' of trigger '%v' is of type '%v' and can't be converted to '%v'.", i, trigger, want, param))
+		}
+	}
+}
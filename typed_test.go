@@ -0,0 +1,64 @@
+package stateless
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOnEntryFromTyped(t *testing.T) {
+	type myInt int
+	sm := NewStateMachine(stateA)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(""), reflect.TypeOf(0))
+	var (
+		name  string
+		count myInt
+		exit  string
+	)
+	sc := sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	OnExitWithTyped2(sc, triggerX, func(_ context.Context, s string, _ int) error {
+		exit = s
+		return nil
+	})
+	OnEntryFromTyped2(sm.Configure(stateB), triggerX, func(_ context.Context, s string, n myInt) error {
+		name, count = s, n
+		return nil
+	})
+
+	if err := sm.Fire(triggerX, "foo", 3); err != nil {
+		t.Fatal(err)
+	}
+	if name != "foo" || count != 3 || exit != "foo" {
+		t.Errorf("got %q, %d, %q, want %q, %d, %q", name, count, exit, "foo", 3, "foo")
+	}
+	if got := sm.stateConfig[stateB].EntryActions[0].Description.String(); got != "func2" {
+		t.Errorf("description = %s, want func2", got)
+	}
+}
+
+func TestOnEntryFromTyped_Mismatch(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.SetTriggerParameters(triggerX, reflect.TypeOf(""))
+	sc := sm.Configure(stateA)
+
+	assertPanic(t, func() {
+		OnEntryFromTyped2(sc, triggerX, func(_ context.Context, _ string, _ int) error { return nil })
+	})
+	assertPanic(t, func() {
+		OnEntryFromTyped1(sc, triggerX, func(_ context.Context, _ []int) error { return nil })
+	})
+}
+
+func TestOnEntryFromTyped_Unregistered(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	OnEntryFromTyped1(sm.Configure(stateB), triggerX, func(_ context.Context, n int) error {
+		return nil
+	})
+
+	if err := sm.Fire(triggerX, "foo"); err == nil {
+		t.Error("expected error for an argument of the wrong type")
+	}
+}
@@ -0,0 +1,368 @@
+package stateless
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StateTrigger identifies a trigger in a given state.
+type StateTrigger struct {
+	State   State
+	Trigger Trigger
+}
+
+// ExhaustivenessError is returned by AssertExhaustive when the configuration
+// does not cover all the supplied states and triggers.
+type ExhaustivenessError struct {
+	// UnconfiguredStates contains the states that have not been configured using Configure.
+	UnconfiguredStates []State
+	// UnhandledTriggers contains the pairs of state and trigger that are neither permitted nor ignored,
+	// not even by a superstate.
+	UnhandledTriggers []StateTrigger
+}
+
+func (e *ExhaustivenessError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("stateless: The configuration is not exhaustive.")
+	if len(e.UnconfiguredStates) != 0 {
+		fmt.Fprintf(&sb, " Unconfigured states: %v.", e.UnconfiguredStates)
+	}
+	if len(e.UnhandledTriggers) != 0 {
+		sb.WriteString(" Unhandled triggers:")
+		for i, st := range e.UnhandledTriggers {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, " '%v' in state '%v'", st.Trigger, st.State)
+		}
+		sb.WriteByte('.')
+	}
+	return sb.String()
+}
+
+// AssertExhaustive checks that every state in allStates has been configured and,
+// if allTriggers is not empty, that every trigger in allTriggers is explicitly handled in every state,
+// either by the state itself or by one of its superstates. A trigger is considered handled
+// if it is permitted or ignored, regardless of its guards.
+// It is meant to be used with enumeration-like states and triggers, for which the full set of values is known.
+//
+// If there are gaps, the returned error is an *ExhaustivenessError listing them
+// in the order of the supplied states and triggers.
+func (sm *StateMachine) AssertExhaustive(allStates []State, allTriggers []Trigger) error {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	var report ExhaustivenessError
+	for _, state := range allStates {
+		sr, ok := sm.stateConfig[state]
+		if !ok || !sr.Configured {
+			report.UnconfiguredStates = append(report.UnconfiguredStates, state)
+		}
+		for _, trigger := range allTriggers {
+			if !sr.handles(trigger) {
+				report.UnhandledTriggers = append(report.UnhandledTriggers, StateTrigger{State: state, Trigger: trigger})
+			}
+		}
+	}
+	if len(report.UnconfiguredStates) == 0 && len(report.UnhandledTriggers) == 0 {
+		return nil
+	}
+	return &report
+}
+
+// IsConfigured returns true if the state has been configured using Configure.
+func (sm *StateMachine) IsConfigured(state State) bool {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	sr, ok := sm.stateConfig[state]
+	return ok && sr.Configured
+}
+
+// StateCount returns the number of states explicitly configured with Configure.
+// States that are only referenced, e.g. as a destination, are not counted.
+func (sm *StateMachine) StateCount() int {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	var n int
+	for _, sr := range sm.stateConfig {
+		if sr.Configured {
+			n++
+		}
+	}
+	return n
+}
+
+// TriggerCount returns the number of distinct triggers that have a behaviour configured in any state.
+func (sm *StateMachine) TriggerCount() int {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	triggers := make(map[Trigger]struct{})
+	for _, sr := range sm.stateConfig {
+		for trigger := range sr.TriggerBehaviours {
+			triggers[trigger] = struct{}{}
+		}
+	}
+	return len(triggers)
+}
+
+// IsSubstateOf returns true if child is a direct or indirect substate of parent.
+// A state is not considered a substate of itself.
+// It returns false if child is not configured.
+func (sm *StateMachine) IsSubstateOf(child, parent State) bool {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	sr, ok := sm.stateConfig[child]
+	if !ok {
+		return false
+	}
+	for sr = sr.superstate(); sr != nil; sr = sr.superstate() {
+		if sr.State == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// Superstate returns the direct superstate of state.
+// The boolean is false if state is not configured or has no superstate.
+func (sm *StateMachine) Superstate(state State) (State, bool) {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	sr, ok := sm.stateConfig[state]
+	if !ok {
+		return nil, false
+	}
+	super := sr.superstate()
+	if super == nil {
+		return nil, false
+	}
+	return super.State, true
+}
+
+// Metadata returns a copy of the metadata attached to state with StateConfiguration.WithMetadata,
+// or nil if there is none.
+func (sm *StateMachine) Metadata(state State) map[string]any {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	sr, ok := sm.stateConfig[state]
+	if !ok {
+		return nil
+	}
+	return sr.metadata()
+}
+
+// Validate inspects the configuration and returns all the problems found, or nil if there are none.
+// It reports:
+//   - transitions and initial transitions whose destination has not been configured.
+//   - initial transitions whose target is not a substate of the configured state.
+//   - configured states that are not the destination of any transition nor the target of an initial transition.
+//   - configured states that can't be reached from the current state.
+//     This check is skipped if a dynamic transition configured with PermitDynamic can be taken,
+//     as its destinations are not known statically. The ones declared with PermitDynamicDest are checked.
+//
+// Guards are not evaluated, so a transition is considered possible regardless of them.
+// Validate does not fire any trigger nor execute any action, and it is safe to call concurrently.
+func (sm *StateMachine) Validate() []error {
+	initial, initialErr := sm.State(context.Background())
+
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+
+	states := make([]*stateRepresentation, 0, len(sm.stateConfig))
+	for _, sr := range sm.stateConfig {
+		states = append(states, sr)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return fmt.Sprint(states[i].State) < fmt.Sprint(states[j].State)
+	})
+
+	var errs []error
+	// entered contains the states that can be entered by a transition, including the superstates of the destinations.
+	entered := make(map[State]struct{})
+	enter := func(state State) {
+		entered[state] = struct{}{}
+		if sr, ok := sm.stateConfig[state]; ok {
+			for sr = sr.superstate(); sr != nil; sr = sr.superstate() {
+				entered[sr.State] = struct{}{}
+			}
+		}
+	}
+	if initialErr == nil {
+		enter(initial)
+	}
+	for _, sr := range states {
+		if sr.HasInitialState {
+			target := sr.InitialTransitionTarget
+			enter(target)
+			if rep, ok := sm.stateConfig[target]; !ok || !rep.Configured {
+				errs = append(errs, fmt.Errorf("stateless: The initial transition of state '%v' targets the unconfigured state '%v'.", sr.State, target))
+			} else if super := rep.superstate(); super == nil || !super.IsIncludedInState(sr.State) {
+				errs = append(errs, fmt.Errorf("stateless: The initial transition of state '%v' targets '%v', which is not one of its substates.", sr.State, target))
+			}
+		}
+		for _, tb := range sortedBehaviours(sr) {
+			dests, _ := behaviourDestinations(tb)
+			for _, dest := range dests {
+				enter(dest)
+				if rep, ok := sm.stateConfig[dest]; !ok || !rep.Configured {
+					errs = append(errs, fmt.Errorf("stateless: Trigger '%v' in state '%v' transitions to the unconfigured state '%v'.", tb.GetTrigger(), sr.State, dest))
+				}
+			}
+		}
+	}
+	for _, sr := range states {
+		if _, ok := entered[sr.State]; sr.Configured && !ok {
+			errs = append(errs, fmt.Errorf("stateless: State '%v' is not the destination of any transition.", sr.State))
+		}
+	}
+	if initialErr != nil {
+		return errs
+	}
+
+	reached, dynamic := sm.reachableStates(initial)
+	if dynamic {
+		return errs
+	}
+	for _, sr := range states {
+		_, isEntered := entered[sr.State]
+		if _, ok := reached[sr.State]; sr.Configured && isEntered && !ok {
+			errs = append(errs, fmt.Errorf("stateless: State '%v' is not reachable from the initial state '%v'.", sr.State, initial))
+		}
+	}
+	return errs
+}
+
+// ReachableStates returns the states that can be reached from the current state by firing triggers,
+// sorted by their string representation. It follows the static destinations of the transitions, including
+// the ones inherited from the superstates, the initial transitions and the possible destinations declared
+// with PermitDynamicDest. The guards are not evaluated, so this is a structural query.
+//
+// The current state and the superstates of the reachable states are included.
+// The destinations of dynamic transitions configured with PermitDynamic can't be known, so they are not followed.
+func (sm *StateMachine) ReachableStates(ctx context.Context) ([]State, error) {
+	current, err := sm.State(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sm.stateMutex.RLock()
+	reached, _ := sm.reachableStates(current)
+	sm.stateMutex.RUnlock()
+	states := make([]State, 0, len(reached))
+	for state := range reached {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return fmt.Sprint(states[i]) < fmt.Sprint(states[j])
+	})
+	return states, nil
+}
+
+// TerminalStates returns the configured states that can't be left by firing a trigger,
+// sorted by their string representation. A state is terminal if neither it nor its superstates
+// have transitioning, reentry or dynamic behaviours, regardless of their guards.
+// Ignored triggers and internal transitions don't leave the state, so they are not taken into account.
+func (sm *StateMachine) TerminalStates() []State {
+	sm.stateMutex.RLock()
+	defer sm.stateMutex.RUnlock()
+	var states []State
+	for state, sr := range sm.stateConfig {
+		if !leavesState(sr) {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return fmt.Sprint(states[i]) < fmt.Sprint(states[j])
+	})
+	return states
+}
+
+// leavesState returns true if sr or any of its superstates has a behaviour that leaves the state.
+func leavesState(sr *stateRepresentation) bool {
+	for rep := sr; rep != nil; rep = rep.superstate() {
+		for _, behaviours := range rep.TriggerBehaviours {
+			for _, tb := range behaviours {
+				switch tb.(type) {
+				case *transitioningTriggerBehaviour, *reentryTriggerBehaviour,
+					*dynamicTriggerBehaviour, *internalDynamicTriggerBehaviour:
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// reachableStates returns the states that can be reached from initial, ignoring the guards.
+// dynamic is true if a dynamic transition can be taken, in which case the result may be incomplete.
+// It must be called with stateMutex held.
+func (sm *StateMachine) reachableStates(initial State) (reached map[State]struct{}, dynamic bool) {
+	reached = make(map[State]struct{})
+	pending := []State{initial}
+	for len(pending) > 0 {
+		state := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := reached[state]; ok {
+			continue
+		}
+		reached[state] = struct{}{}
+		sr, ok := sm.stateConfig[state]
+		if !ok {
+			continue
+		}
+		if sr.HasInitialState {
+			pending = append(pending, sr.InitialTransitionTarget)
+		}
+		for rep := sr; rep != nil; rep = rep.superstate() {
+			reached[rep.State] = struct{}{}
+			for _, behaviours := range rep.TriggerBehaviours {
+				for _, tb := range behaviours {
+					dests, known := behaviourDestinations(tb)
+					if !known {
+						dynamic = true
+					}
+					pending = append(pending, dests...)
+				}
+			}
+		}
+	}
+	return reached, dynamic
+}
+
+// behaviourDestinations returns the states a trigger behaviour can transition to.
+// known is false for dynamic transitions without declared possible destinations.
+func behaviourDestinations(tb triggerBehaviour) (dests []State, known bool) {
+	switch t := tb.(type) {
+	case *transitioningTriggerBehaviour:
+		return []State{t.Destination}, true
+	case *reentryTriggerBehaviour:
+		return []State{t.Destination}, true
+	case *dynamicTriggerBehaviour:
+		return t.PossibleDestinations, len(t.PossibleDestinations) > 0
+	case *internalDynamicTriggerBehaviour:
+		return nil, false
+	}
+	return nil, true
+}
+
+// sortedBehaviours returns the trigger behaviours of sr sorted by trigger.
+func sortedBehaviours(sr *stateRepresentation) []triggerBehaviour {
+	behaviours := make([]triggerBehaviour, 0, len(sr.TriggerBehaviours))
+	for _, tbs := range sr.TriggerBehaviours {
+		behaviours = append(behaviours, tbs...)
+	}
+	sort.SliceStable(behaviours, func(i, j int) bool {
+		return lessByString(behaviours[i].GetTrigger(), behaviours[j].GetTrigger())
+	})
+	return behaviours
+}
+
+// lessByString reports whether a sorts before b by their string representation,
+// breaking ties by their type so that values of different types that print the same are ordered too.
+func lessByString(a, b any) bool {
+	sa, sb := fmt.Sprint(a), fmt.Sprint(b)
+	if sa != sb {
+		return sa < sb
+	}
+	return fmt.Sprintf("%T", a) < fmt.Sprintf("%T", b)
+}
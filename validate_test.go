@@ -0,0 +1,310 @@
+package stateless
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStateMachine_AssertExhaustive(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		Ignore(triggerY)
+	sm.Configure(stateB).
+		SubstateOf(stateC).
+		Permit(triggerY, stateA)
+	sm.Configure(stateC).
+		Ignore(triggerX)
+
+	err := sm.AssertExhaustive([]State{stateA, stateB, stateC, stateD}, []Trigger{triggerX, triggerY})
+	var report *ExhaustivenessError
+	if !errors.As(err, &report) {
+		t.Fatalf("AssertExhaustive() = %v, want *ExhaustivenessError", err)
+	}
+	if want := []State{stateD}; !reflect.DeepEqual(report.UnconfiguredStates, want) {
+		t.Errorf("UnconfiguredStates = %v, want %v", report.UnconfiguredStates, want)
+	}
+	want := []StateTrigger{
+		{State: stateC, Trigger: triggerY},
+		{State: stateD, Trigger: triggerX},
+		{State: stateD, Trigger: triggerY},
+	}
+	if !reflect.DeepEqual(report.UnhandledTriggers, want) {
+		t.Errorf("UnhandledTriggers = %v, want %v", report.UnhandledTriggers, want)
+	}
+	wantMsg := "stateless: The configuration is not exhaustive. Unconfigured states: [D]. Unhandled triggers: 'Y' in state 'C', 'X' in state 'D', 'Y' in state 'D'."
+	if err.Error() != wantMsg {
+		t.Errorf("Error() = %q, want %q", err, wantMsg)
+	}
+}
+
+func TestStateMachine_AssertExhaustive_Complete(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).Permit(triggerX, stateB)
+	sm.Configure(stateB).Ignore(triggerX)
+
+	if err := sm.AssertExhaustive([]State{stateA, stateB}, []Trigger{triggerX}); err != nil {
+		t.Errorf("AssertExhaustive() = %v, want nil", err)
+	}
+}
+
+func TestStateMachine_IsConfigured(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateB).SubstateOf(stateC)
+	// The representation of the current state is created lazily, but it is not configured.
+	sm.CanFire(triggerX)
+
+	for state, want := range map[State]bool{stateA: false, stateB: true, stateC: false, stateD: false} {
+		if got := sm.IsConfigured(state); got != want {
+			t.Errorf("IsConfigured(%v) = %v, want %v", state, got, want)
+		}
+	}
+}
+
+type orderState string
+
+const (
+	orderPending   orderState = "Pending"
+	orderPaid      orderState = "Paid"
+	orderCancelled orderState = "Cancelled"
+)
+
+func (orderState) States() []orderState {
+	return []orderState{orderPending, orderPaid, orderCancelled}
+}
+
+func TestRegisterStates(t *testing.T) {
+	sm := NewStateMachine(orderPending)
+	RegisterStates(sm, orderState("").States()...)
+	sm.Configure(orderPending).Permit(triggerX, orderPaid)
+
+	for _, state := range orderState("").States() {
+		if !sm.IsConfigured(state) {
+			t.Errorf("IsConfigured(%v) = false, want true", state)
+		}
+	}
+	allStates := []State{orderPending, orderPaid, orderCancelled}
+	if err := sm.AssertExhaustive(allStates, nil); err != nil {
+		t.Errorf("AssertExhaustive() = %v, want nil", err)
+	}
+}
+
+func TestStateMachine_StateCount_TriggerCount(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		Ignore(triggerY)
+	sm.Configure(stateC).
+		Permit(triggerX, stateD).
+		Permit(triggerZ, stateA)
+	// stateB and stateD are only referenced as destinations.
+	if err := sm.Fire(triggerX); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sm.StateCount(); got != 2 {
+		t.Errorf("StateCount() = %d, want 2", got)
+	}
+	if got := sm.TriggerCount(); got != 3 {
+		t.Errorf("TriggerCount() = %d, want 3", got)
+	}
+}
+
+func TestStateMachine_IsSubstateOf(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateB).SubstateOf(stateC)
+	sm.Configure(stateC).SubstateOf(stateD)
+
+	tests := []struct {
+		child, parent State
+		want          bool
+	}{
+		{stateB, stateC, true},
+		{stateB, stateD, true},
+		{stateC, stateD, true},
+		{stateB, stateB, false},
+		{stateD, stateB, false},
+		{stateA, stateD, false},
+		{"W", stateD, false},
+	}
+	for _, tt := range tests {
+		if got := sm.IsSubstateOf(tt.child, tt.parent); got != tt.want {
+			t.Errorf("IsSubstateOf(%v, %v) = %v, want %v", tt.child, tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestStateMachine_Superstate(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateB).SubstateOf(stateC)
+
+	if got, ok := sm.Superstate(stateB); !ok || got != stateC {
+		t.Errorf("Superstate(%v) = %v, %v, want %v, true", stateB, got, ok, stateC)
+	}
+	for _, state := range []State{stateC, stateD} {
+		if got, ok := sm.Superstate(state); ok || got != nil {
+			t.Errorf("Superstate(%v) = %v, %v, want nil, false", state, got, ok)
+		}
+	}
+}
+
+func TestStateMachine_Metadata(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		WithMetadata("label", "Start").
+		WithMetadata("terminal", false).
+		WithMetadata("terminal", true)
+	sm.Configure(stateB)
+
+	want := map[string]any{"label": "Start", "terminal": true}
+	if got := sm.Metadata(stateA); !reflect.DeepEqual(got, want) {
+		t.Errorf("Metadata(%v) = %v, want %v", stateA, got, want)
+	}
+	sm.Metadata(stateA)["label"] = "changed"
+	if got := sm.Metadata(stateA)["label"]; got != "Start" {
+		t.Errorf("expected the metadata not to be modified through the returned map, got %v", got)
+	}
+	for _, state := range []State{stateB, stateC} {
+		if got := sm.Metadata(state); got != nil {
+			t.Errorf("Metadata(%v) = %v, want nil", state, got)
+		}
+	}
+}
+
+func TestStateMachine_TerminalStates(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB, func(_ context.Context, _ ...any) bool { return false })
+	sm.Configure(stateB).
+		Ignore(triggerX).
+		InternalTransition(triggerY, func(_ context.Context, _ ...any) error { return nil })
+	sm.Configure(stateC).
+		PermitReentry(triggerX)
+	sm.Configure(stateD).
+		SubstateOf(stateA)
+	sm.Configure("W").
+		PermitDynamic(triggerX, func(_ context.Context, _ ...any) (State, error) { return stateA, nil })
+
+	want := []State{stateB}
+	if got := sm.TerminalStates(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TerminalStates() = %v, want %v", got, want)
+	}
+}
+
+func TestStateMachine_Validate(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB).
+		Permit(triggerY, "W")
+	sm.Configure(stateB).
+		InitialTransition(stateA)
+	sm.Configure(stateC).
+		Permit(triggerX, stateD)
+	sm.Configure(stateD).
+		Permit(triggerX, stateC)
+	sm.Configure("V")
+
+	want := []string{
+		"stateless: Trigger '" + triggerY + "' in state '" + stateA + "' transitions to the unconfigured state 'W'.",
+		"stateless: The initial transition of state '" + stateB + "' targets '" + stateA + "', which is not one of its substates.",
+		"stateless: State 'V' is not the destination of any transition.",
+		"stateless: State '" + stateC + "' is not reachable from the initial state '" + stateA + "'.",
+		"stateless: State '" + stateD + "' is not reachable from the initial state '" + stateA + "'.",
+	}
+	errs := sm.Validate()
+	got := make([]string, len(errs))
+	for i, err := range errs {
+		got[i] = err.Error()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
+
+func TestStateMachine_Validate_Valid(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		InitialTransition(stateC).
+		PermitReentry(triggerY)
+	sm.Configure(stateC).
+		SubstateOf(stateB).
+		Permit(triggerZ, stateA)
+	sm.Configure(stateA).
+		Permit(triggerZ, stateD, func(_ context.Context, _ ...any) bool { return false })
+	sm.Configure(stateD).
+		Permit(triggerX, stateA)
+
+	if errs := sm.Validate(); len(errs) != 0 {
+		t.Errorf("Validate() = %v, want nil", errs)
+	}
+}
+
+func TestStateMachine_Validate_PermitDynamicDest(t *testing.T) {
+	sm := NewStateMachine(stateA)
+	selector := func(_ context.Context, _ ...any) (State, error) { return stateB, nil }
+	sm.Configure(stateA).
+		PermitDynamicDest(triggerX, selector, []State{stateB, "W"})
+	sm.Configure(stateB)
+	sm.Configure(stateC).
+		PermitReentry(triggerX)
+
+	want := []string{
+		"stateless: Trigger '" + triggerX + "' in state '" + stateA + "' transitions to the unconfigured state 'W'.",
+		"stateless: State '" + stateC + "' is not reachable from the initial state '" + stateA + "'.",
+	}
+	errs := sm.Validate()
+	got := make([]string, len(errs))
+	for i, err := range errs {
+		got[i] = err.Error()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
+
+func TestStateMachine_ReachableStates(t *testing.T) {
+	sm := NewStateMachine(stateB)
+	sm.Configure(stateA).
+		Permit(triggerX, stateB)
+	sm.Configure(stateB).
+		SubstateOf("V").
+		PermitDynamicDest(triggerY, func(_ context.Context, _ ...any) (State, error) {
+			return stateC, nil
+		}, []State{stateC}).
+		PermitDynamic(triggerZ, func(_ context.Context, _ ...any) (State, error) {
+			return stateA, nil
+		})
+	sm.Configure(stateC).
+		InitialTransition(stateD).
+		Permit(triggerX, stateA, func(_ context.Context, _ ...any) bool { return false })
+	sm.Configure(stateD).
+		SubstateOf(stateC)
+	sm.Configure("V").
+		Permit("T", "W")
+	sm.Configure("W")
+
+	got, err := sm.ReachableStates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []State{stateA, stateB, stateC, stateD, "V", "W"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReachableStates() = %v, want %v", got, want)
+	}
+
+	sm.Configure("W").Ignore(triggerX)
+	if err := sm.Fire("T"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = sm.ReachableStates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []State{"W"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReachableStates() = %v, want %v", got, want)
+	}
+}